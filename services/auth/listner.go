@@ -35,6 +35,14 @@ func startServer() {
 		logrus.SetOutput(f)
 	}
 
+	if flag.Arg(0) == "migrate" {
+		err := runMigrate(config, flag.Args()[1:])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
 	// initialise database and other dependencies (store)
 	s := store.NewStore(config)
 
@@ -42,6 +50,7 @@ func startServer() {
 	var opts []grpc.ServerOption
 	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterAuthServiceServer(grpcServer, server.NewServer(s))
+	pb.RegisterAdminServiceServer(grpcServer, server.NewAdminServer(s))
 
 	listener, err := net.Listen("tcp", config.Server.Listen)
 	if err != nil {
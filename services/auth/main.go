@@ -1,5 +1,8 @@
 package main
 
+// time zones of user profiles are validated with the embedded database, the alpine image has none
+import _ "time/tzdata"
+
 func main() {
 	startServer()
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/bhrg3se/flahmingo-homework/utils/migrations"
+	"strconv"
+)
+
+const migrateUsage = "usage: auth [-c config] migrate up|down [steps]|status|baseline <version>"
+
+// runMigrate runs the migrate command, it only needs the database
+func runMigrate(config utils.Config, args []string) error {
+	if len(args) < 1 {
+		return errors.New(migrateUsage)
+	}
+
+	db, dialect, err := store.OpenDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migrations\n", reverted)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-30s %s\n", s.Version, s.Name, appliedAt)
+		}
+
+	case "baseline":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		recorded, err := migrator.Baseline(ctx, version)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("recorded %d migrations as applied\n", recorded)
+
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -26,6 +26,38 @@ type AuthServiceClient interface {
 	LoginWithPhoneNumber(ctx context.Context, in *User, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	ValidatePhoneNumberLogin(ctx context.Context, in *VerifyPhoneNumberRequest, opts ...grpc.CallOption) (*Token, error)
 	GetProfile(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*User, error)
+	// changes the fields in updateMask of the profile of the user in the auth token and returns the updated profile
+	UpdateProfile(ctx context.Context, in *UpdateProfileRequest, opts ...grpc.CallOption) (*User, error)
+	// sends an otp to the new phone number of the user in the auth token,
+	// and to the current phone number too if the auth service is configured to verify it
+	StartPhoneNumberChange(ctx context.Context, in *StartPhoneNumberChangeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// changes the phone number if the otps are correct, revokes all auth tokens of the user and returns a new one
+	ConfirmPhoneNumberChange(ctx context.Context, in *ConfirmPhoneNumberChangeRequest, opts ...grpc.CallOption) (*Token, error)
+	// deletes the account of the user in the auth token and revokes its auth tokens.
+	// The account and all its data are purged once the grace period returned in the response passed.
+	DeleteAccount(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AccountDeletion, error)
+	// sends an otp to the phone number of a deleted account which is not purged yet, to restore it
+	StartAccountRestore(ctx context.Context, in *User, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// takes the otp sent by StartAccountRestore, cancels the deletion of the account and returns an auth token
+	RestoreAccount(ctx context.Context, in *VerifyPhoneNumberRequest, opts ...grpc.CallOption) (*Token, error)
+	// returns everything kept about the user in the auth token as a json document
+	ExportMyData(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DataExport, error)
+	// returns the logins of the user in the auth token, newest first, with failed ones where the user was known
+	GetLoginHistory(ctx context.Context, in *LoginHistoryRequest, opts ...grpc.CallOption) (*LoginHistory, error)
+	// returns the delivery status of the latest otp sent to the phone number,
+	// so that clients can offer another way to receive the otp if it was not delivered.
+	// Only callers with the auth token of the phone number in the "token" metadata get the details of the delivery,
+	// others only get the status of an otp they requested with the id from the "delivery-id" header of their request.
+	GetDeliveryStatus(ctx context.Context, in *DeliveryStatusRequest, opts ...grpc.CallOption) (*DeliveryStatus, error)
+	// sends an otp and a magic link to the email of a registered user
+	LoginWithEmail(ctx context.Context, in *LoginWithEmailRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// takes otp sent to the email and returns an auth token if it is correct
+	ValidateEmailLogin(ctx context.Context, in *VerifyEmailRequest, opts ...grpc.CallOption) (*Token, error)
+	// takes the token carried in the magic link and returns an auth token if it is valid and not used yet
+	ValidateMagicLink(ctx context.Context, in *ValidateMagicLinkRequest, opts ...grpc.CallOption) (*Token, error)
+	// takes the token carried in the link sent to confirm an email and makes that email the email of the user.
+	// Only confirmed emails can be used to login.
+	ConfirmEmail(ctx context.Context, in *ConfirmEmailRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
 
 type authServiceClient struct {
@@ -81,6 +113,123 @@ func (c *authServiceClient) GetProfile(ctx context.Context, in *emptypb.Empty, o
 	return out, nil
 }
 
+func (c *authServiceClient) UpdateProfile(ctx context.Context, in *UpdateProfileRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/UpdateProfile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) StartPhoneNumberChange(ctx context.Context, in *StartPhoneNumberChangeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/StartPhoneNumberChange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ConfirmPhoneNumberChange(ctx context.Context, in *ConfirmPhoneNumberChangeRequest, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/ConfirmPhoneNumberChange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) DeleteAccount(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AccountDeletion, error) {
+	out := new(AccountDeletion)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/DeleteAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) StartAccountRestore(ctx context.Context, in *User, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/StartAccountRestore", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) RestoreAccount(ctx context.Context, in *VerifyPhoneNumberRequest, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/RestoreAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ExportMyData(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DataExport, error) {
+	out := new(DataExport)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/ExportMyData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) GetLoginHistory(ctx context.Context, in *LoginHistoryRequest, opts ...grpc.CallOption) (*LoginHistory, error) {
+	out := new(LoginHistory)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/GetLoginHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) GetDeliveryStatus(ctx context.Context, in *DeliveryStatusRequest, opts ...grpc.CallOption) (*DeliveryStatus, error) {
+	out := new(DeliveryStatus)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/GetDeliveryStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) LoginWithEmail(ctx context.Context, in *LoginWithEmailRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/LoginWithEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ValidateEmailLogin(ctx context.Context, in *VerifyEmailRequest, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/ValidateEmailLogin", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ValidateMagicLink(ctx context.Context, in *ValidateMagicLinkRequest, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/ValidateMagicLink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ConfirmEmail(ctx context.Context, in *ConfirmEmailRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/grpc.AuthService/ConfirmEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AuthServiceServer is the server API for AuthService service.
 // All implementations must embed UnimplementedAuthServiceServer
 // for forward compatibility
@@ -92,6 +241,38 @@ type AuthServiceServer interface {
 	LoginWithPhoneNumber(context.Context, *User) (*emptypb.Empty, error)
 	ValidatePhoneNumberLogin(context.Context, *VerifyPhoneNumberRequest) (*Token, error)
 	GetProfile(context.Context, *emptypb.Empty) (*User, error)
+	// changes the fields in updateMask of the profile of the user in the auth token and returns the updated profile
+	UpdateProfile(context.Context, *UpdateProfileRequest) (*User, error)
+	// sends an otp to the new phone number of the user in the auth token,
+	// and to the current phone number too if the auth service is configured to verify it
+	StartPhoneNumberChange(context.Context, *StartPhoneNumberChangeRequest) (*emptypb.Empty, error)
+	// changes the phone number if the otps are correct, revokes all auth tokens of the user and returns a new one
+	ConfirmPhoneNumberChange(context.Context, *ConfirmPhoneNumberChangeRequest) (*Token, error)
+	// deletes the account of the user in the auth token and revokes its auth tokens.
+	// The account and all its data are purged once the grace period returned in the response passed.
+	DeleteAccount(context.Context, *emptypb.Empty) (*AccountDeletion, error)
+	// sends an otp to the phone number of a deleted account which is not purged yet, to restore it
+	StartAccountRestore(context.Context, *User) (*emptypb.Empty, error)
+	// takes the otp sent by StartAccountRestore, cancels the deletion of the account and returns an auth token
+	RestoreAccount(context.Context, *VerifyPhoneNumberRequest) (*Token, error)
+	// returns everything kept about the user in the auth token as a json document
+	ExportMyData(context.Context, *emptypb.Empty) (*DataExport, error)
+	// returns the logins of the user in the auth token, newest first, with failed ones where the user was known
+	GetLoginHistory(context.Context, *LoginHistoryRequest) (*LoginHistory, error)
+	// returns the delivery status of the latest otp sent to the phone number,
+	// so that clients can offer another way to receive the otp if it was not delivered.
+	// Only callers with the auth token of the phone number in the "token" metadata get the details of the delivery,
+	// others only get the status of an otp they requested with the id from the "delivery-id" header of their request.
+	GetDeliveryStatus(context.Context, *DeliveryStatusRequest) (*DeliveryStatus, error)
+	// sends an otp and a magic link to the email of a registered user
+	LoginWithEmail(context.Context, *LoginWithEmailRequest) (*emptypb.Empty, error)
+	// takes otp sent to the email and returns an auth token if it is correct
+	ValidateEmailLogin(context.Context, *VerifyEmailRequest) (*Token, error)
+	// takes the token carried in the magic link and returns an auth token if it is valid and not used yet
+	ValidateMagicLink(context.Context, *ValidateMagicLinkRequest) (*Token, error)
+	// takes the token carried in the link sent to confirm an email and makes that email the email of the user.
+	// Only confirmed emails can be used to login.
+	ConfirmEmail(context.Context, *ConfirmEmailRequest) (*emptypb.Empty, error)
 	mustEmbedUnimplementedAuthServiceServer()
 }
 
@@ -114,6 +295,45 @@ func (UnimplementedAuthServiceServer) ValidatePhoneNumberLogin(context.Context,
 func (UnimplementedAuthServiceServer) GetProfile(context.Context, *emptypb.Empty) (*User, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetProfile not implemented")
 }
+func (UnimplementedAuthServiceServer) UpdateProfile(context.Context, *UpdateProfileRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateProfile not implemented")
+}
+func (UnimplementedAuthServiceServer) StartPhoneNumberChange(context.Context, *StartPhoneNumberChangeRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartPhoneNumberChange not implemented")
+}
+func (UnimplementedAuthServiceServer) ConfirmPhoneNumberChange(context.Context, *ConfirmPhoneNumberChangeRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmPhoneNumberChange not implemented")
+}
+func (UnimplementedAuthServiceServer) DeleteAccount(context.Context, *emptypb.Empty) (*AccountDeletion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAccount not implemented")
+}
+func (UnimplementedAuthServiceServer) StartAccountRestore(context.Context, *User) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartAccountRestore not implemented")
+}
+func (UnimplementedAuthServiceServer) RestoreAccount(context.Context, *VerifyPhoneNumberRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreAccount not implemented")
+}
+func (UnimplementedAuthServiceServer) ExportMyData(context.Context, *emptypb.Empty) (*DataExport, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportMyData not implemented")
+}
+func (UnimplementedAuthServiceServer) GetLoginHistory(context.Context, *LoginHistoryRequest) (*LoginHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLoginHistory not implemented")
+}
+func (UnimplementedAuthServiceServer) GetDeliveryStatus(context.Context, *DeliveryStatusRequest) (*DeliveryStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeliveryStatus not implemented")
+}
+func (UnimplementedAuthServiceServer) LoginWithEmail(context.Context, *LoginWithEmailRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LoginWithEmail not implemented")
+}
+func (UnimplementedAuthServiceServer) ValidateEmailLogin(context.Context, *VerifyEmailRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidateEmailLogin not implemented")
+}
+func (UnimplementedAuthServiceServer) ValidateMagicLink(context.Context, *ValidateMagicLinkRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidateMagicLink not implemented")
+}
+func (UnimplementedAuthServiceServer) ConfirmEmail(context.Context, *ConfirmEmailRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmEmail not implemented")
+}
 func (UnimplementedAuthServiceServer) mustEmbedUnimplementedAuthServiceServer() {}
 
 // UnsafeAuthServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -217,32 +437,864 @@ func _AuthService_GetProfile_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
-// AuthService_ServiceDesc is the grpc.ServiceDesc for AuthService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var AuthService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "grpc.AuthService",
-	HandlerType: (*AuthServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "SignupWithPhoneNumber",
-			Handler:    _AuthService_SignupWithPhoneNumber_Handler,
-		},
-		{
-			MethodName: "VerifyPhoneNumber",
-			Handler:    _AuthService_VerifyPhoneNumber_Handler,
-		},
-		{
-			MethodName: "LoginWithPhoneNumber",
-			Handler:    _AuthService_LoginWithPhoneNumber_Handler,
-		},
-		{
-			MethodName: "ValidatePhoneNumberLogin",
-			Handler:    _AuthService_ValidatePhoneNumberLogin_Handler,
-		},
-		{
-			MethodName: "GetProfile",
-			Handler:    _AuthService_GetProfile_Handler,
+func _AuthService_UpdateProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).UpdateProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/UpdateProfile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).UpdateProfile(ctx, req.(*UpdateProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_StartPhoneNumberChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartPhoneNumberChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).StartPhoneNumberChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/StartPhoneNumberChange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).StartPhoneNumberChange(ctx, req.(*StartPhoneNumberChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ConfirmPhoneNumberChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmPhoneNumberChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ConfirmPhoneNumberChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/ConfirmPhoneNumberChange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ConfirmPhoneNumberChange(ctx, req.(*ConfirmPhoneNumberChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_DeleteAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).DeleteAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/DeleteAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).DeleteAccount(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_StartAccountRestore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(User)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).StartAccountRestore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/StartAccountRestore",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).StartAccountRestore(ctx, req.(*User))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_RestoreAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyPhoneNumberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).RestoreAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/RestoreAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).RestoreAccount(ctx, req.(*VerifyPhoneNumberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ExportMyData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ExportMyData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/ExportMyData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ExportMyData(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_GetLoginHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).GetLoginHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/GetLoginHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).GetLoginHistory(ctx, req.(*LoginHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_GetDeliveryStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeliveryStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).GetDeliveryStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/GetDeliveryStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).GetDeliveryStatus(ctx, req.(*DeliveryStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_LoginWithEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginWithEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).LoginWithEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/LoginWithEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).LoginWithEmail(ctx, req.(*LoginWithEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ValidateEmailLogin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ValidateEmailLogin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/ValidateEmailLogin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ValidateEmailLogin(ctx, req.(*VerifyEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ValidateMagicLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateMagicLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ValidateMagicLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/ValidateMagicLink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ValidateMagicLink(ctx, req.(*ValidateMagicLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ConfirmEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ConfirmEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AuthService/ConfirmEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ConfirmEmail(ctx, req.(*ConfirmEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AuthService_ServiceDesc is the grpc.ServiceDesc for AuthService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AuthService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.AuthService",
+	HandlerType: (*AuthServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SignupWithPhoneNumber",
+			Handler:    _AuthService_SignupWithPhoneNumber_Handler,
+		},
+		{
+			MethodName: "VerifyPhoneNumber",
+			Handler:    _AuthService_VerifyPhoneNumber_Handler,
+		},
+		{
+			MethodName: "LoginWithPhoneNumber",
+			Handler:    _AuthService_LoginWithPhoneNumber_Handler,
+		},
+		{
+			MethodName: "ValidatePhoneNumberLogin",
+			Handler:    _AuthService_ValidatePhoneNumberLogin_Handler,
+		},
+		{
+			MethodName: "GetProfile",
+			Handler:    _AuthService_GetProfile_Handler,
+		},
+		{
+			MethodName: "UpdateProfile",
+			Handler:    _AuthService_UpdateProfile_Handler,
+		},
+		{
+			MethodName: "StartPhoneNumberChange",
+			Handler:    _AuthService_StartPhoneNumberChange_Handler,
+		},
+		{
+			MethodName: "ConfirmPhoneNumberChange",
+			Handler:    _AuthService_ConfirmPhoneNumberChange_Handler,
+		},
+		{
+			MethodName: "DeleteAccount",
+			Handler:    _AuthService_DeleteAccount_Handler,
+		},
+		{
+			MethodName: "StartAccountRestore",
+			Handler:    _AuthService_StartAccountRestore_Handler,
+		},
+		{
+			MethodName: "RestoreAccount",
+			Handler:    _AuthService_RestoreAccount_Handler,
+		},
+		{
+			MethodName: "ExportMyData",
+			Handler:    _AuthService_ExportMyData_Handler,
+		},
+		{
+			MethodName: "GetLoginHistory",
+			Handler:    _AuthService_GetLoginHistory_Handler,
+		},
+		{
+			MethodName: "GetDeliveryStatus",
+			Handler:    _AuthService_GetDeliveryStatus_Handler,
+		},
+		{
+			MethodName: "LoginWithEmail",
+			Handler:    _AuthService_LoginWithEmail_Handler,
+		},
+		{
+			MethodName: "ValidateEmailLogin",
+			Handler:    _AuthService_ValidateEmailLogin_Handler,
+		},
+		{
+			MethodName: "ValidateMagicLink",
+			Handler:    _AuthService_ValidateMagicLink_Handler,
+		},
+		{
+			MethodName: "ConfirmEmail",
+			Handler:    _AuthService_ConfirmEmail_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/service.proto",
+}
+
+// AdminServiceClient is the client API for AdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminServiceClient interface {
+	// returns all sender numbers and ids used to send sms, needs the ADMIN role
+	ListSenders(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*SenderList, error)
+	// adds a sender or updates the sender with the same address, needs the ADMIN role
+	SaveSender(ctx context.Context, in *Sender, opts ...grpc.CallOption) (*Sender, error)
+	// removes a sender, recipients which were assigned to it get another one. Needs the ADMIN role.
+	DeleteSender(ctx context.Context, in *DeleteSenderRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// returns a page of users matching the filters, ordered by creation time. Needs the SUPPORT role.
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*UserList, error)
+	// returns a user by id, needs the SUPPORT role
+	GetUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error)
+	// suspends a user for abuse and revokes its auth tokens, suspended users can not login. Needs the SUPPORT role.
+	SuspendUser(ctx context.Context, in *UserStatusRequest, opts ...grpc.CallOption) (*User, error)
+	// lifts the suspension of a user, needs the SUPPORT role
+	UnsuspendUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error)
+	// locks a user to protect it, like when the account is compromised, and revokes its auth tokens.
+	// Locked users can not login. Needs the SUPPORT role.
+	LockUser(ctx context.Context, in *UserStatusRequest, opts ...grpc.CallOption) (*User, error)
+	// unlocks a locked user, needs the SUPPORT role
+	UnlockUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error)
+	// marks the phone number of a user as verified without an otp, needs the SUPPORT role
+	ForceVerify(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error)
+	// deletes the account of a user like DeleteAccount does, needs the ADMIN role
+	DeleteUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*AccountDeletion, error)
+	// changes the role of a user, needs the ADMIN role
+	SetUserRole(ctx context.Context, in *SetUserRoleRequest, opts ...grpc.CallOption) (*User, error)
+	// returns a page of auth events matching the filters, newest first, or exports it as CSV or JSON. Needs the ADMIN role.
+	QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...grpc.CallOption) (*AuditLogPage, error)
+}
+
+type adminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminServiceClient(cc grpc.ClientConnInterface) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) ListSenders(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*SenderList, error) {
+	out := new(SenderList)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/ListSenders", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SaveSender(ctx context.Context, in *Sender, opts ...grpc.CallOption) (*Sender, error) {
+	out := new(Sender)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/SaveSender", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) DeleteSender(ctx context.Context, in *DeleteSenderRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/DeleteSender", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*UserList, error) {
+	out := new(UserList)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/ListUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) GetUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/GetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SuspendUser(ctx context.Context, in *UserStatusRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/SuspendUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) UnsuspendUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/UnsuspendUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) LockUser(ctx context.Context, in *UserStatusRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/LockUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) UnlockUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/UnlockUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ForceVerify(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/ForceVerify", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) DeleteUser(ctx context.Context, in *AdminUserRequest, opts ...grpc.CallOption) (*AccountDeletion, error) {
+	out := new(AccountDeletion)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/DeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) SetUserRole(ctx context.Context, in *SetUserRoleRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/SetUserRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) QueryAuditLog(ctx context.Context, in *QueryAuditLogRequest, opts ...grpc.CallOption) (*AuditLogPage, error) {
+	out := new(AuditLogPage)
+	err := c.cc.Invoke(ctx, "/grpc.AdminService/QueryAuditLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServiceServer is the server API for AdminService service.
+// All implementations must embed UnimplementedAdminServiceServer
+// for forward compatibility
+type AdminServiceServer interface {
+	// returns all sender numbers and ids used to send sms, needs the ADMIN role
+	ListSenders(context.Context, *emptypb.Empty) (*SenderList, error)
+	// adds a sender or updates the sender with the same address, needs the ADMIN role
+	SaveSender(context.Context, *Sender) (*Sender, error)
+	// removes a sender, recipients which were assigned to it get another one. Needs the ADMIN role.
+	DeleteSender(context.Context, *DeleteSenderRequest) (*emptypb.Empty, error)
+	// returns a page of users matching the filters, ordered by creation time. Needs the SUPPORT role.
+	ListUsers(context.Context, *ListUsersRequest) (*UserList, error)
+	// returns a user by id, needs the SUPPORT role
+	GetUser(context.Context, *AdminUserRequest) (*User, error)
+	// suspends a user for abuse and revokes its auth tokens, suspended users can not login. Needs the SUPPORT role.
+	SuspendUser(context.Context, *UserStatusRequest) (*User, error)
+	// lifts the suspension of a user, needs the SUPPORT role
+	UnsuspendUser(context.Context, *AdminUserRequest) (*User, error)
+	// locks a user to protect it, like when the account is compromised, and revokes its auth tokens.
+	// Locked users can not login. Needs the SUPPORT role.
+	LockUser(context.Context, *UserStatusRequest) (*User, error)
+	// unlocks a locked user, needs the SUPPORT role
+	UnlockUser(context.Context, *AdminUserRequest) (*User, error)
+	// marks the phone number of a user as verified without an otp, needs the SUPPORT role
+	ForceVerify(context.Context, *AdminUserRequest) (*User, error)
+	// deletes the account of a user like DeleteAccount does, needs the ADMIN role
+	DeleteUser(context.Context, *AdminUserRequest) (*AccountDeletion, error)
+	// changes the role of a user, needs the ADMIN role
+	SetUserRole(context.Context, *SetUserRoleRequest) (*User, error)
+	// returns a page of auth events matching the filters, newest first, or exports it as CSV or JSON. Needs the ADMIN role.
+	QueryAuditLog(context.Context, *QueryAuditLogRequest) (*AuditLogPage, error)
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+// UnimplementedAdminServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServiceServer struct {
+}
+
+func (UnimplementedAdminServiceServer) ListSenders(context.Context, *emptypb.Empty) (*SenderList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSenders not implemented")
+}
+func (UnimplementedAdminServiceServer) SaveSender(context.Context, *Sender) (*Sender, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SaveSender not implemented")
+}
+func (UnimplementedAdminServiceServer) DeleteSender(context.Context, *DeleteSenderRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSender not implemented")
+}
+func (UnimplementedAdminServiceServer) ListUsers(context.Context, *ListUsersRequest) (*UserList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedAdminServiceServer) GetUser(context.Context, *AdminUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedAdminServiceServer) SuspendUser(context.Context, *UserStatusRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SuspendUser not implemented")
+}
+func (UnimplementedAdminServiceServer) UnsuspendUser(context.Context, *AdminUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnsuspendUser not implemented")
+}
+func (UnimplementedAdminServiceServer) LockUser(context.Context, *UserStatusRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LockUser not implemented")
+}
+func (UnimplementedAdminServiceServer) UnlockUser(context.Context, *AdminUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnlockUser not implemented")
+}
+func (UnimplementedAdminServiceServer) ForceVerify(context.Context, *AdminUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ForceVerify not implemented")
+}
+func (UnimplementedAdminServiceServer) DeleteUser(context.Context, *AdminUserRequest) (*AccountDeletion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (UnimplementedAdminServiceServer) SetUserRole(context.Context, *SetUserRoleRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUserRole not implemented")
+}
+func (UnimplementedAdminServiceServer) QueryAuditLog(context.Context, *QueryAuditLogRequest) (*AuditLogPage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAuditLog not implemented")
+}
+func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
+
+// UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServiceServer will
+// result in compilation errors.
+type UnsafeAdminServiceServer interface {
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+func RegisterAdminServiceServer(s grpc.ServiceRegistrar, srv AdminServiceServer) {
+	s.RegisterService(&AdminService_ServiceDesc, srv)
+}
+
+func _AdminService_ListSenders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ListSenders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/ListSenders",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ListSenders(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SaveSender_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Sender)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SaveSender(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/SaveSender",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SaveSender(ctx, req.(*Sender))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_DeleteSender_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSenderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).DeleteSender(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/DeleteSender",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).DeleteSender(ctx, req.(*DeleteSenderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/ListUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).GetUser(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SuspendUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SuspendUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/SuspendUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SuspendUser(ctx, req.(*UserStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_UnsuspendUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).UnsuspendUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/UnsuspendUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).UnsuspendUser(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_LockUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).LockUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/LockUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).LockUser(ctx, req.(*UserStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_UnlockUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).UnlockUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/UnlockUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).UnlockUser(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ForceVerify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ForceVerify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/ForceVerify",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ForceVerify(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/DeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).DeleteUser(ctx, req.(*AdminUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_SetUserRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetUserRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).SetUserRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/SetUserRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).SetUserRole(ctx, req.(*SetUserRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_QueryAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).QueryAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.AdminService/QueryAuditLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).QueryAuditLog(ctx, req.(*QueryAuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSenders",
+			Handler:    _AdminService_ListSenders_Handler,
+		},
+		{
+			MethodName: "SaveSender",
+			Handler:    _AdminService_SaveSender_Handler,
+		},
+		{
+			MethodName: "DeleteSender",
+			Handler:    _AdminService_DeleteSender_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _AdminService_ListUsers_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _AdminService_GetUser_Handler,
+		},
+		{
+			MethodName: "SuspendUser",
+			Handler:    _AdminService_SuspendUser_Handler,
+		},
+		{
+			MethodName: "UnsuspendUser",
+			Handler:    _AdminService_UnsuspendUser_Handler,
+		},
+		{
+			MethodName: "LockUser",
+			Handler:    _AdminService_LockUser_Handler,
+		},
+		{
+			MethodName: "UnlockUser",
+			Handler:    _AdminService_UnlockUser_Handler,
+		},
+		{
+			MethodName: "ForceVerify",
+			Handler:    _AdminService_ForceVerify_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _AdminService_DeleteUser_Handler,
+		},
+		{
+			MethodName: "SetUserRole",
+			Handler:    _AdminService_SetUserRole_Handler,
+		},
+		{
+			MethodName: "QueryAuditLog",
+			Handler:    _AdminService_QueryAuditLog_Handler,
 		},
 	},
 	Streams:  []grpc.StreamDesc{},
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"time"
+)
+
+// DeleteAccount deletes the account of the user in the auth token and revokes all its auth tokens.
+// The account is kept for auth.deletionGracePeriod before it is purged with all its data.
+func (s Server) DeleteAccount(ctx context.Context, e *emptypb.Empty) (*pb.AccountDeletion, error) {
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.store.DeleteUser(ctx, user.ID)
+	if err != nil {
+		return nil, storeError(err, "user", "could not delete account")
+	}
+	logrus.Infof("account of user %s deleted", user.ID)
+	s.recordEvent(ctx, tokenRevoked(user.ID, user.ID, "delete_account"), nil)
+
+	return accountDeletion(s.store.GetConfig()), nil
+}
+
+// StartAccountRestore sends an otp to the phone number of a deleted account, which can be restored with it
+// until the account is purged.
+func (s Server) StartAccountRestore(ctx context.Context, request *pb.User) (_ *emptypb.Empty, err error) {
+	event := otpSent("", request.PhoneNumber, store.PurposeAccountRestore)
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	user, err := s.deletedUser(ctx, request.PhoneNumber)
+	if err != nil {
+		return empty, err
+	}
+	event.UserID = user.ID
+
+	err = s.allowOTPRequest(ctx, request.PhoneNumber)
+	if err != nil {
+		return empty, err
+	}
+
+	otp := utils.GetRandomOTP()
+	err = s.store.SaveOTP(ctx, otp, store.RestoreKey(request.PhoneNumber))
+	if err != nil {
+		return nil, storeError(err, "otp", "could not save otp")
+	}
+
+	err = s.store.PublishOTP(ctx, store.OTPMessage{
+		ID:          deliveryID(ctx),
+		OTP:         otp,
+		PhoneNumber: request.PhoneNumber,
+		Channel:     channelFromPb(request.Channel),
+		Purpose:     store.PurposeAccountRestore,
+		Locale:      request.Locale,
+	})
+	if err != nil {
+		return nil, storeError(err, "otp", "could not send otp")
+	}
+	return empty, nil
+}
+
+// RestoreAccount takes the otp sent by StartAccountRestore, cancels the deletion of the account and returns an auth token.
+// Auth tokens issued before the deletion stay revoked.
+func (s Server) RestoreAccount(ctx context.Context, request *pb.VerifyPhoneNumberRequest) (_ *pb.Token, err error) {
+	event := store.AuthEvent{Type: store.EventAccountRestored, Target: request.PhoneNumber}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	// the user is fetched before the otp is checked, so that failed attempts are recorded with it
+	user, err := s.deletedUser(ctx, request.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	event.UserID = user.ID
+
+	err = s.checkOTP(ctx, user.ID, store.RestoreKey(request.PhoneNumber), request.Otp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.store.RestoreUser(ctx, user.ID)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		return nil, phoneNumberRegisteredError()
+	}
+	if err != nil {
+		return nil, storeError(err, "user", "could not restore account")
+	}
+	logrus.Infof("account of user %s restored", user.ID)
+
+	user, err = s.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+	return s.issueToken(ctx, user, store.EventTokenIssued)
+}
+
+// deletedUser returns the deleted account of the phone number, with the status it had before the deletion, if it can
+// still be restored. Accounts whose grace period passed are not found even if they are not purged yet, and suspended
+// or locked accounts can not be restored by their users.
+func (s Server) deletedUser(ctx context.Context, phoneNumber string) (*store.User, error) {
+	notFound := errorWithDetails(codes.NotFound, reasonNotFound, "no deleted account with this phone number", 0, map[string]string{"resource": "user"})
+	user, err := s.store.GetDeletedUser(ctx, phoneNumber)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+	gracePeriod := s.store.GetConfig().Auth.DeletionGracePeriod
+	if gracePeriod > 0 && user.DeletedAt != nil && time.Since(*user.DeletedAt) > gracePeriod {
+		return nil, notFound
+	}
+	// deleting the account does not lift a suspension or lock, such accounts are only restored by admins lifting it
+	if user.Status == store.StatusSuspended || user.Status == store.StatusLocked {
+		return nil, userStatusError(user)
+	}
+	return user, nil
+}
+
+// accountDeletion tells when an account deleted now is purged
+func accountDeletion(config utils.Config) *pb.AccountDeletion {
+	var deletion pb.AccountDeletion
+	// accounts are never purged if there is no grace period
+	if gracePeriod := config.Auth.DeletionGracePeriod; gracePeriod > 0 {
+		deletion.PurgeTime = timestampToPb(time.Now().Add(gracePeriod))
+	}
+	return &deletion
+}
+
+// dataExport is the json document returned by ExportMyData
+type dataExport struct {
+	ExportedAt    time.Time         `json:"exportedAt"`
+	Profile       exportProfile     `json:"profile"`
+	OTPDeliveries []exportDelivery  `json:"otpDeliveries"`
+	MagicLinks    []exportMagicLink `json:"magicLinks"`
+	Devices       []exportDevice    `json:"devices"`
+	// SMSSender is the number or id which sms are sent to the user from, null if no sms was sent yet
+	SMSSender *exportSender `json:"smsSender"`
+	// LoginHistory are the logins of the user like GetLoginHistory returns them, oldest first
+	LoginHistory []exportLogin `json:"loginHistory"`
+	// AuthEvents are the signups, otps, logins, auth tokens and admin actions of the audit log about the user
+	AuthEvents []exportAuthEvent `json:"authEvents"`
+}
+
+type exportProfile struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	PhoneNumber string            `json:"phoneNumber"`
+	IsVerified  bool              `json:"isVerified"`
+	Email       string            `json:"email"`
+	DisplayName string            `json:"displayName"`
+	AvatarURL   string            `json:"avatarUrl"`
+	Locale      string            `json:"locale"`
+	Timezone    string            `json:"timezone"`
+	Metadata    map[string]string `json:"metadata"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+}
+
+type exportDelivery struct {
+	MessageID string    `json:"messageId"`
+	Channel   string    `json:"channel"`
+	Provider  string    `json:"provider"`
+	Status    string    `json:"status"`
+	ErrorCode string    `json:"errorCode"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type exportMagicLink struct {
+	Expiry time.Time  `json:"expiry"`
+	UsedAt *time.Time `json:"usedAt"`
+}
+
+type exportDevice struct {
+	UserAgent string    `json:"userAgent"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+type exportSender struct {
+	Sender     string    `json:"sender"`
+	AssignedAt time.Time `json:"assignedAt"`
+}
+
+type exportLogin struct {
+	Time          time.Time `json:"time"`
+	Method        string    `json:"method"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"userAgent"`
+	NewDevice     bool      `json:"newDevice"`
+}
+
+type exportAuthEvent struct {
+	Type      string            `json:"type"`
+	Action    string            `json:"action,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Target    string            `json:"target"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"userAgent"`
+	Details   map[string]string `json:"details"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// ExportMyData returns everything kept about the user in the auth token as a json document.
+// Otps, which expire within minutes, ids of magic links, which are secrets, device fingerprints and the admins who
+// took actions on the user are left out.
+func (s Server) ExportMyData(ctx context.Context, e *emptypb.Empty) (*pb.DataExport, error) {
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.store.ExportUserData(ctx, user.ID)
+	if err != nil {
+		return nil, storeError(err, "user", "could not export data")
+	}
+
+	export := dataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			ID:          data.User.ID,
+			Name:        data.User.Name,
+			PhoneNumber: data.User.PhoneNumber,
+			IsVerified:  data.User.IsVerified,
+			Email:       data.User.Email,
+			DisplayName: data.User.DisplayName,
+			AvatarURL:   data.User.AvatarURL,
+			Locale:      data.User.Locale,
+			Timezone:    data.User.Timezone,
+			Metadata:    data.User.Metadata,
+			CreatedAt:   data.User.CreatedAt,
+			UpdatedAt:   data.User.UpdatedAt,
+		},
+		OTPDeliveries: make([]exportDelivery, 0, len(data.Deliveries)),
+		MagicLinks:    make([]exportMagicLink, 0, len(data.MagicLinks)),
+		Devices:       make([]exportDevice, 0, len(data.Devices)),
+		LoginHistory:  []exportLogin{},
+		AuthEvents:    make([]exportAuthEvent, 0, len(data.AuthEvents)),
+	}
+	for _, d := range data.Deliveries {
+		export.OTPDeliveries = append(export.OTPDeliveries, exportDelivery{
+			MessageID: d.MessageID,
+			Channel:   d.Channel,
+			Provider:  d.Provider,
+			Status:    d.Status,
+			ErrorCode: d.ErrorCode,
+			CreatedAt: d.CreatedAt,
+			UpdatedAt: d.UpdatedAt,
+		})
+	}
+	for _, l := range data.MagicLinks {
+		export.MagicLinks = append(export.MagicLinks, exportMagicLink{Expiry: l.Expiry, UsedAt: l.UsedAt})
+	}
+	for _, d := range data.Devices {
+		export.Devices = append(export.Devices, exportDevice{UserAgent: d.UserAgent, FirstSeen: d.CreatedAt, LastSeen: d.LastSeenAt})
+	}
+	if a := data.SenderAssignment; a != nil {
+		export.SMSSender = &exportSender{Sender: a.Sender, AssignedAt: a.AssignedAt}
+	}
+	for _, e := range data.AuthEvents {
+		if e.Type == store.EventLogin {
+			export.LoginHistory = append(export.LoginHistory, exportLogin{
+				Time:          e.CreatedAt,
+				Method:        e.Details["method"],
+				Success:       e.Outcome == store.OutcomeSuccess,
+				FailureReason: e.Details["reason"],
+				IP:            e.IP,
+				UserAgent:     e.UserAgent,
+				NewDevice:     e.Details["newDevice"] == "true",
+			})
+		}
+		export.AuthEvents = append(export.AuthEvents, exportAuthEvent{
+			Type:      e.Type,
+			Action:    e.Action,
+			Outcome:   e.Outcome,
+			Target:    e.Target,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	document, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		logrus.Error(err)
+		return nil, status.Error(codes.Internal, "could not export data")
+	}
+	return &pb.DataExport{Data: document, ContentType: "application/json"}, nil
+}
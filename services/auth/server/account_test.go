@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"testing"
+	"time"
+)
+
+func TestServer_DeleteAccount(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		gracePeriod time.Duration
+		deleteErr   error
+		wantCode    codes.Code
+		wantPurge   bool
+	}{
+		{
+			name:     "should fail without auth token",
+			ctx:      context.Background(),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:      "should fail when deleted at the same time",
+			ctx:       ctx,
+			deleteErr: store.ErrNotFound,
+			wantCode:  codes.NotFound,
+		},
+		{
+			name:        "should delete account with purge time",
+			ctx:         ctx,
+			gracePeriod: time.Hour * 24 * 30,
+			wantPurge:   true,
+		},
+		{
+			name: "should delete account without purge time",
+			ctx:  ctx,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			var config utils.Config
+			config.Auth.DeletionGracePeriod = tt.gracePeriod
+
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, "someNumber").Return(&user, nil)
+			mockStore.On("DeleteUser", mock.Anything, "someID").Return(tt.deleteErr)
+
+			s := Server{store: mockStore}
+			got, err := s.DeleteAccount(tt.ctx, &emptypb.Empty{})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("DeleteAccount() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantPurge != (got.PurgeTime != nil) {
+				t.Fatalf("DeleteAccount() purge time = %v, want purge time %v", got.PurgeTime, tt.wantPurge)
+			}
+			if tt.wantPurge && got.PurgeTime.AsTime().Before(time.Now().Add(tt.gracePeriod-time.Minute)) {
+				t.Errorf("DeleteAccount() purge time = %v, want after the grace period", got.PurgeTime.AsTime())
+			}
+		})
+	}
+}
+
+func TestServer_StartAccountRestore(t *testing.T) {
+	deletedAt := time.Now().Add(-time.Hour * 24)
+
+	tests := []struct {
+		name       string
+		deletedAt  time.Time
+		status     string
+		deletedErr error
+		wantCode   codes.Code
+	}{
+		{
+			name:       "should fail without deleted account",
+			deletedErr: store.ErrNotFound,
+			wantCode:   codes.NotFound,
+		},
+		{
+			name:      "should fail after the grace period",
+			deletedAt: time.Now().Add(-time.Hour * 24 * 31),
+			wantCode:  codes.NotFound,
+		},
+		{
+			name:      "should fail for suspended account",
+			deletedAt: deletedAt,
+			status:    store.StatusSuspended,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "should fail for locked account",
+			deletedAt: deletedAt,
+			status:    store.StatusLocked,
+			wantCode:  codes.PermissionDenied,
+		},
+		{
+			name:      "should send otp",
+			deletedAt: deletedAt,
+			status:    store.StatusActive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted *store.User
+			if tt.deletedErr == nil {
+				user := testutils.MockUser1
+				user.Status, user.DeletedAt = tt.status, &tt.deletedAt
+				deleted = &user
+			}
+			var config utils.Config
+			config.Auth.DeletionGracePeriod = time.Hour * 24 * 30
+
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetDeletedUser", mock.Anything, "someNumber").Return(deleted, tt.deletedErr)
+			mockStore.On("AllowOTPRequest", mock.Anything, "someNumber").Return(time.Duration(0), nil)
+			mockStore.On("SaveOTP", mock.Anything, mock.Anything, store.RestoreKey("someNumber")).Return(nil)
+			mockStore.On("PublishOTP", mock.Anything, mock.MatchedBy(func(message store.OTPMessage) bool {
+				return message.PhoneNumber == "someNumber" && message.Purpose == store.PurposeAccountRestore
+			})).Return(nil)
+
+			s := Server{store: mockStore}
+			_, err := s.StartAccountRestore(context.Background(), &pb.User{PhoneNumber: "someNumber"})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("StartAccountRestore() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				mockStore.AssertNotCalled(t, "PublishOTP", mock.Anything, mock.Anything)
+				return
+			}
+			mockStore.AssertCalled(t, "PublishOTP", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestServer_RestoreAccount(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	deletedAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		otp        string
+		status     string
+		restoreErr error
+		wantCode   codes.Code
+	}{
+		{
+			name:     "should fail when otp is different",
+			otp:      "654321",
+			status:   store.StatusActive,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "should not lift a suspension",
+			otp:      "123456",
+			status:   store.StatusSuspended,
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:       "should fail when phone number was registered again",
+			otp:        "123456",
+			status:     store.StatusActive,
+			restoreErr: store.ErrAlreadyExists,
+			wantCode:   codes.AlreadyExists,
+		},
+		{
+			name:   "should restore account",
+			otp:    "123456",
+			status: store.StatusActive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := testutils.MockUser1
+			deleted.Status, deleted.DeletedAt = tt.status, &deletedAt
+			restored := testutils.MockUser1
+			var config utils.Config
+			config.Auth.DeletionGracePeriod = time.Hour * 24 * 30
+
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetJWTPrivateKey").Return(privateKey)
+			mockStore.On("CheckOTP", mock.Anything, store.RestoreKey("someNumber"), "123456").Return(nil)
+			mockStore.On("CheckOTP", mock.Anything, store.RestoreKey("someNumber"), mock.Anything).Return(store.ErrOTPInvalid)
+			mockStore.On("GetDeletedUser", mock.Anything, "someNumber").Return(&deleted, nil)
+			mockStore.On("RestoreUser", mock.Anything, "someID").Return(tt.restoreErr)
+			mockStore.On("GetUserByID", mock.Anything, "someID").Return(&restored, nil)
+
+			s := Server{store: mockStore}
+			got, err := s.RestoreAccount(context.Background(), &pb.VerifyPhoneNumberRequest{PhoneNumber: "someNumber", Otp: tt.otp})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("RestoreAccount() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				if tt.restoreErr == nil {
+					mockStore.AssertNotCalled(t, "RestoreUser", mock.Anything, mock.Anything)
+				}
+				mockStore.AssertNotCalled(t, "GetUserByID", mock.Anything, mock.Anything)
+				return
+			}
+			token, err := parseAuthToken(got.Token, &privateKey.PublicKey)
+			if err != nil || token.Subject != "someID" {
+				t.Errorf("RestoreAccount() token = %+v, %v, want a token of the restored user", token, err)
+			}
+		})
+	}
+}
+
+func TestServer_ExportMyData(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	usedAt := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		data     *store.UserData
+		wantCode codes.Code
+		want     string
+	}{
+		{
+			name:     "should fail without auth token",
+			ctx:      context.Background(),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "should export empty lists without deliveries and magic links",
+			ctx:  ctx,
+			data: &store.UserData{User: testutils.MockUser1},
+			want: `{"profile":{"id":"someID","name":"Some User","phoneNumber":"someNumber","isVerified":true,"email":"","displayName":"",` +
+				`"avatarUrl":"","locale":"","timezone":"","metadata":null,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"},` +
+				`"otpDeliveries":[],"magicLinks":[],"devices":[],"smsSender":null,"loginHistory":[],"authEvents":[]}`,
+		},
+		{
+			name: "should export deliveries, magic links, devices, sender, login history and auth events",
+			ctx:  ctx,
+			data: &store.UserData{
+				User:             testutils.MockUser1,
+				Deliveries:       []store.Delivery{{MessageID: "messageID", Channel: store.ChannelSMS, Status: store.DeliveryDelivered, CreatedAt: usedAt, UpdatedAt: usedAt}},
+				MagicLinks:       []store.MagicLink{{Email: "user@example.com", Expiry: usedAt, UsedAt: &usedAt}},
+				Devices:          []store.Device{{UserID: "someID", Fingerprint: "someFingerprint", UserAgent: "someAgent", CreatedAt: usedAt, LastSeenAt: usedAt}},
+				SenderAssignment: &store.SenderAssignment{Sender: "+15005550006", AssignedAt: usedAt},
+				AuthEvents: []store.AuthEvent{{ID: "eventID", Type: store.EventLogin, Outcome: store.OutcomeSuccess, UserID: "someID",
+					Actor: "adminID", Target: "someNumber", IP: "10.0.0.1", UserAgent: "someAgent", Details: map[string]string{"method": "phone"},
+					CreatedAt: usedAt}, {ID: "eventID2", Type: store.EventOTPSent, Outcome: store.OutcomeSuccess, UserID: "someID",
+					Target: "someNumber", Details: map[string]string{"purpose": store.PurposeLogin}, CreatedAt: usedAt}},
+			},
+			want: `{"profile":{"id":"someID","name":"Some User","phoneNumber":"someNumber","isVerified":true,"email":"","displayName":"",` +
+				`"avatarUrl":"","locale":"","timezone":"","metadata":null,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"},` +
+				`"otpDeliveries":[{"messageId":"messageID","channel":"sms","provider":"","status":"delivered","errorCode":"",` +
+				`"createdAt":"2021-09-01T10:00:00Z","updatedAt":"2021-09-01T10:00:00Z"}],` +
+				`"magicLinks":[{"expiry":"2021-09-01T10:00:00Z","usedAt":"2021-09-01T10:00:00Z"}],` +
+				`"devices":[{"userAgent":"someAgent","firstSeen":"2021-09-01T10:00:00Z","lastSeen":"2021-09-01T10:00:00Z"}],` +
+				`"smsSender":{"sender":"+15005550006","assignedAt":"2021-09-01T10:00:00Z"},` +
+				`"loginHistory":[{"time":"2021-09-01T10:00:00Z","method":"phone","success":true,"ip":"10.0.0.1","userAgent":"someAgent","newDevice":false}],` +
+				`"authEvents":[{"type":"login","outcome":"success","target":"someNumber","ip":"10.0.0.1","userAgent":"someAgent",` +
+				`"details":{"method":"phone"},"createdAt":"2021-09-01T10:00:00Z"},` +
+				`{"type":"otp_sent","outcome":"success","target":"someNumber","ip":"","userAgent":"",` +
+				`"details":{"purpose":"login"},"createdAt":"2021-09-01T10:00:00Z"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, "someNumber").Return(&user, nil)
+			mockStore.On("ExportUserData", mock.Anything, "someID").Return(tt.data, nil)
+
+			s := Server{store: mockStore}
+			got, err := s.ExportMyData(tt.ctx, &emptypb.Empty{})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("ExportMyData() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if got.ContentType != "application/json" {
+				t.Errorf("ExportMyData() content type = %v", got.ContentType)
+			}
+
+			// the export time changes with every export
+			var document map[string]json.RawMessage
+			if err := json.Unmarshal(got.Data, &document); err != nil {
+				t.Fatalf("ExportMyData() data is not json: %v", err)
+			}
+			delete(document, "exportedAt")
+			var want map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(document)
+			wantJSON, _ := json.Marshal(want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("ExportMyData() got = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	e164Pattern         = regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
+	shortCodePattern    = regexp.MustCompile(`^\d{3,8}$`)
+	alphanumericPattern = regexp.MustCompile(`^[A-Za-z0-9 ]{1,11}$`)
+	countryPattern      = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+func NewAdminServer(store store.GenericStore) *AdminServer {
+	return &AdminServer{store: store}
+}
+
+// AdminServer implements the AdminService
+type AdminServer struct {
+	pb.UnimplementedAdminServiceServer
+	store store.GenericStore
+}
+
+// adminTokenActor is the actor recorded in the audit log for requests made with the admin token
+const adminTokenActor = "admin-token"
+
+// roleRanks orders roles, each role can do everything the roles ranked lower can
+var roleRanks = map[string]int{store.RoleUser: 0, store.RoleSupport: 1, store.RoleAdmin: 2}
+
+// adminActor is who makes a request to the admin service
+type adminActor struct {
+	// ID is the id of the user, or adminTokenActor
+	ID   string
+	Role string
+}
+
+// authorize checks the admin token or the auth token in request metadata.
+// Requests with the admin token can do everything, users need the role or a higher one.
+func (s AdminServer) authorize(ctx context.Context, role string) (adminActor, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if token := md.Get("admin-token"); len(token) > 0 {
+		adminToken := s.store.GetConfig().Auth.AdminToken
+		if adminToken == "" {
+			return adminActor{}, status.Error(codes.PermissionDenied, "admin token is disabled")
+		}
+		if subtle.ConstantTimeCompare([]byte(token[0]), []byte(adminToken)) != 1 {
+			return adminActor{}, status.Error(codes.PermissionDenied, "invalid admin token")
+		}
+		return adminActor{ID: adminTokenActor, Role: store.RoleAdmin}, nil
+	}
+
+	if len(md.Get("token")) > 0 {
+		user, err := Server{store: s.store}.authenticatedUser(ctx)
+		if err != nil {
+			return adminActor{}, err
+		}
+		if roleRanks[user.Role] < roleRanks[role] {
+			return adminActor{}, roleRequiredError(role)
+		}
+		return adminActor{ID: user.ID, Role: user.Role}, nil
+	}
+	return adminActor{}, status.Error(codes.Unauthenticated, "could not find admin token")
+}
+
+// roleRequiredError is returned when a user makes a request which needs a higher role
+func roleRequiredError(role string) error {
+	return errorWithDetails(codes.PermissionDenied, reasonRoleRequired, "the "+role+" role is required", 0, map[string]string{"role": role})
+}
+
+// audited runs f in a transaction and records the admin action in the audit log in it,
+// so that no action is taken without being recorded. f returns grpc errors.
+func (s AdminServer) audited(ctx context.Context, actor adminActor, action store.AuthEvent, f func(tx store.GenericStore) error) error {
+	err := s.store.InTx(ctx, func(tx store.GenericStore) error {
+		if err := f(tx); err != nil {
+			return err
+		}
+		action.Type, action.Actor, action.Outcome = store.EventAdminAction, actor.ID, store.OutcomeSuccess
+		action.IP, action.UserAgent = clientInfo(ctx)
+		if err := tx.RecordAuthEvent(ctx, &action); err != nil {
+			return storeError(err, "audit log", "could not record action")
+		}
+		return nil
+	})
+	if _, ok := status.FromError(err); !ok {
+		// the transaction itself failed
+		return storeError(err, "audit log", "could not record action")
+	}
+	if err == nil {
+		logrus.Infof("admin action %s on %q by %s", action.Action, action.Target, actor.ID)
+	}
+	return err
+}
+
+// ListSenders returns all sender numbers and ids used to send sms
+func (s AdminServer) ListSenders(ctx context.Context, e *emptypb.Empty) (*pb.SenderList, error) {
+	actor, err := s.authorize(ctx, store.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &pb.SenderList{}
+	err = s.audited(ctx, actor, store.AuthEvent{Action: "list_senders"}, func(tx store.GenericStore) error {
+		senders, err := tx.ListSenders(ctx)
+		if err != nil {
+			return storeError(err, "sender", "could not get senders")
+		}
+		for _, sender := range senders {
+			list.Senders = append(list.Senders, senderToPb(sender))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SaveSender adds a sender or updates the sender with the same address
+func (s AdminServer) SaveSender(ctx context.Context, request *pb.Sender) (*pb.Sender, error) {
+	actor, err := s.authorize(ctx, store.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := senderFromPb(request)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{"kind": sender.Kind, "countries": strings.Join(sender.Countries, ","), "enabled": strconv.FormatBool(sender.Enabled)}
+	err = s.audited(ctx, actor, store.AuthEvent{Action: "save_sender", Target: sender.Address, Details: details}, func(tx store.GenericStore) error {
+		if err := tx.SaveSender(ctx, &sender); err != nil {
+			return storeError(err, "sender", "could not save sender")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return senderToPb(sender), nil
+}
+
+// DeleteSender removes a sender, recipients which were assigned to it get another one
+func (s AdminServer) DeleteSender(ctx context.Context, request *pb.DeleteSenderRequest) (*emptypb.Empty, error) {
+	actor, err := s.authorize(ctx, store.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.audited(ctx, actor, store.AuthEvent{Action: "delete_sender", Target: request.Address}, func(tx store.GenericStore) error {
+		if err := tx.DeleteSender(ctx, request.Address); err != nil {
+			return storeError(err, "sender", "could not delete sender")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return empty, nil
+}
+
+// senderFromPb validates the sender and converts it to the store model
+func senderFromPb(sender *pb.Sender) (store.Sender, error) {
+	s := store.Sender{
+		Address:       strings.TrimSpace(sender.Address),
+		RatePerSecond: sender.RatePerSecond,
+		Enabled:       sender.Enabled,
+	}
+
+	switch sender.Kind {
+	case pb.Sender_SHORT_CODE:
+		s.Kind = store.SenderShortCode
+		if !shortCodePattern.MatchString(s.Address) {
+			return s, status.Error(codes.InvalidArgument, "short code must have 3 to 8 digits")
+		}
+	case pb.Sender_ALPHANUMERIC:
+		s.Kind = store.SenderAlphanumeric
+		if !alphanumericPattern.MatchString(s.Address) || shortCodePattern.MatchString(s.Address) {
+			return s, status.Error(codes.InvalidArgument, "alphanumeric sender id must have up to 11 letters, digits or spaces and at least one letter")
+		}
+	default:
+		s.Kind = store.SenderNumber
+		if !e164Pattern.MatchString(s.Address) {
+			return s, status.Error(codes.InvalidArgument, "phone number must be in E.164 format")
+		}
+	}
+
+	if s.RatePerSecond < 0 {
+		return s, status.Error(codes.InvalidArgument, "rate per second can not be negative")
+	}
+
+	for _, country := range sender.Countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !countryPattern.MatchString(country) {
+			return s, status.Errorf(codes.InvalidArgument, "invalid country code %q", country)
+		}
+		s.Countries = append(s.Countries, country)
+	}
+	return s, nil
+}
+
+func senderToPb(sender store.Sender) *pb.Sender {
+	s := &pb.Sender{
+		Address:       sender.Address,
+		Countries:     sender.Countries,
+		RatePerSecond: sender.RatePerSecond,
+		Enabled:       sender.Enabled,
+	}
+	switch sender.Kind {
+	case store.SenderShortCode:
+		s.Kind = pb.Sender_SHORT_CODE
+	case store.SenderAlphanumeric:
+		s.Kind = pb.Sender_ALPHANUMERIC
+	default:
+		s.Kind = pb.Sender_NUMBER
+	}
+	return s
+}
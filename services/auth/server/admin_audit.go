@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"time"
+)
+
+// QueryAuditLog returns a page of auth events matching the filters, newest first, or exports it as CSV or JSON
+func (s AdminServer) QueryAuditLog(ctx context.Context, request *pb.QueryAuditLogRequest) (*pb.AuditLogPage, error) {
+	actor, err := s.authorize(ctx, store.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := requestPageSize(request.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	var before *store.Cursor
+	if request.PageToken != "" {
+		if before, err = decodePageToken(request.PageToken); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+
+	filter := store.AuthEventFilter{UserID: request.UserId, Actor: request.Actor}
+	details := map[string]string{}
+	for _, t := range request.Types {
+		filter.Types = append(filter.Types, eventTypeFromPb[t])
+	}
+	for _, o := range request.Outcomes {
+		filter.Outcomes = append(filter.Outcomes, outcomeFromPb[o])
+	}
+	if len(filter.Types) > 0 {
+		details["types"] = strings.Join(filter.Types, ",")
+	}
+	if len(filter.Outcomes) > 0 {
+		details["outcomes"] = strings.Join(filter.Outcomes, ",")
+	}
+	if filter.UserID != "" {
+		details["userId"] = filter.UserID
+	}
+	if filter.Actor != "" {
+		details["actor"] = filter.Actor
+	}
+	if request.StartTime != nil {
+		filter.Since = request.StartTime.AsTime()
+		details["startTime"] = filter.Since.Format(time.RFC3339)
+	}
+	if request.EndTime != nil {
+		filter.Until = request.EndTime.AsTime()
+		details["endTime"] = filter.Until.Format(time.RFC3339)
+	}
+	if request.Format != pb.QueryAuditLogRequest_EVENTS {
+		details["format"] = request.Format.String()
+	}
+
+	var events []store.AuthEvent
+	err = s.audited(ctx, actor, store.AuthEvent{Action: "query_audit_log", Details: details}, func(tx store.GenericStore) error {
+		// one more event is fetched to know if there is a next page
+		events, err = tx.QueryAuthEvents(ctx, filter, before, pageSize+1)
+		if err != nil {
+			return storeError(err, "audit log", "could not query audit log")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &pb.AuditLogPage{}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		last := events[pageSize-1]
+		page.NextPageToken = encodePageToken(store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	switch request.Format {
+	case pb.QueryAuditLogRequest_CSV:
+		page.Data, err = eventsToCSV(events)
+		page.ContentType = "text/csv"
+	case pb.QueryAuditLogRequest_JSON:
+		page.Data, err = eventsToJSON(events)
+		page.ContentType = "application/json"
+	default:
+		for _, event := range events {
+			page.Events = append(page.Events, eventToPb(event))
+		}
+	}
+	if err != nil {
+		logrus.Error(err)
+		return nil, status.Error(codes.Internal, "could not export audit log")
+	}
+	return page, nil
+}
+
+// eventColumns are the columns of audit log exports
+var eventColumns = []string{"id", "time", "type", "action", "outcome", "userId", "actor", "target", "ip", "userAgent", "details"}
+
+// eventsToCSV exports the events with a header of eventColumns, details are a json object
+func eventsToCSV(events []store.AuthEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(eventColumns); err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		details, err := json.Marshal(event.Details)
+		if err != nil {
+			return nil, err
+		}
+		if event.Details == nil {
+			details = []byte("{}")
+		}
+		err = w.Write([]string{event.ID, event.CreatedAt.UTC().Format(time.RFC3339Nano), event.Type, event.Action, event.Outcome,
+			event.UserID, csvCell(event.Actor), csvCell(event.Target), event.IP, csvCell(event.UserAgent), string(details)})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvCell escapes values set by clients which spreadsheets would run as formulas, phone numbers are kept as they are
+func csvCell(value string) string {
+	if value == "" || e164Pattern.MatchString(value) {
+		return value
+	}
+	switch value[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + value
+	}
+	return value
+}
+
+// exportEvent is an event in JSON exports of the audit log
+type exportEvent struct {
+	ID        string            `json:"id"`
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	Action    string            `json:"action"`
+	Outcome   string            `json:"outcome"`
+	UserID    string            `json:"userId"`
+	Actor     string            `json:"actor"`
+	Target    string            `json:"target"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"userAgent"`
+	Details   map[string]string `json:"details"`
+}
+
+// eventsToJSON exports the events as a json array
+func eventsToJSON(events []store.AuthEvent) ([]byte, error) {
+	export := make([]exportEvent, 0, len(events))
+	for _, event := range events {
+		details := event.Details
+		if details == nil {
+			details = map[string]string{}
+		}
+		export = append(export, exportEvent{
+			ID:        event.ID,
+			Time:      event.CreatedAt.UTC(),
+			Type:      event.Type,
+			Action:    event.Action,
+			Outcome:   event.Outcome,
+			UserID:    event.UserID,
+			Actor:     event.Actor,
+			Target:    event.Target,
+			IP:        event.IP,
+			UserAgent: event.UserAgent,
+			Details:   details,
+		})
+	}
+	return json.Marshal(export)
+}
+
+var eventTypeFromPb = map[pb.AuthEvent_Type]string{
+	pb.AuthEvent_SIGNUP:          store.EventSignup,
+	pb.AuthEvent_OTP_SENT:        store.EventOTPSent,
+	pb.AuthEvent_OTP_VERIFIED:    store.EventOTPVerified,
+	pb.AuthEvent_OTP_FAILED:      store.EventOTPFailed,
+	pb.AuthEvent_LOGIN:           store.EventLogin,
+	pb.AuthEvent_TOKEN_ISSUED:    store.EventTokenIssued,
+	pb.AuthEvent_TOKEN_REFRESHED: store.EventTokenRefreshed,
+	pb.AuthEvent_TOKEN_REVOKED:   store.EventTokenRevoked,
+	pb.AuthEvent_ADMIN_ACTION:    store.EventAdminAction,
+}
+
+var outcomeFromPb = map[pb.AuthEvent_Outcome]string{
+	pb.AuthEvent_SUCCESS: store.OutcomeSuccess,
+	pb.AuthEvent_FAILURE: store.OutcomeFailure,
+}
+
+func eventToPb(event store.AuthEvent) *pb.AuthEvent {
+	e := &pb.AuthEvent{
+		Id:        event.ID,
+		Action:    event.Action,
+		UserId:    event.UserID,
+		Actor:     event.Actor,
+		Target:    event.Target,
+		Ip:        event.IP,
+		UserAgent: event.UserAgent,
+		Details:   event.Details,
+		Time:      timestamppb.New(event.CreatedAt),
+	}
+	for t, eventType := range eventTypeFromPb {
+		if eventType == event.Type {
+			e.Type = t
+		}
+	}
+	if event.Outcome == store.OutcomeFailure {
+		e.Outcome = pb.AuthEvent_FAILURE
+	}
+	return e
+}
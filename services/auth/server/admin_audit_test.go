@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminServer_QueryAuditLog(t *testing.T) {
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	created := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	events := []store.AuthEvent{
+		{ID: "c", Type: store.EventLogin, Outcome: store.OutcomeFailure, Target: "+15005550006", CreatedAt: created.Add(time.Second),
+			Details: map[string]string{"reason": reasonOTPInvalid}},
+		{ID: "b", Type: store.EventAdminAction, Action: "suspend_user", Outcome: store.OutcomeSuccess, Actor: "=cmd()", Target: "someID2", CreatedAt: created},
+		{ID: "a", Type: store.EventSignup, Outcome: store.OutcomeSuccess, UserID: "someID2", CreatedAt: created},
+	}
+	afterB := encodePageToken(store.Cursor{CreatedAt: created, ID: "b"})
+
+	tests := []struct {
+		name       string
+		role       string
+		request    *pb.QueryAuditLogRequest
+		filter     store.AuthEventFilter
+		before     *store.Cursor
+		limit      int
+		found      []store.AuthEvent
+		wantIDs    []string
+		wantNext   string
+		wantCode   codes.Code
+		wantDetail map[string]string
+	}{
+		{
+			name:     "should fail for support users",
+			role:     store.RoleSupport,
+			request:  &pb.QueryAuditLogRequest{},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "should fail with invalid page token",
+			role:     store.RoleAdmin,
+			request:  &pb.QueryAuditLogRequest{PageToken: "not a token"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:       "should return first page with next page token",
+			role:       store.RoleAdmin,
+			request:    &pb.QueryAuditLogRequest{PageSize: 2},
+			limit:      3,
+			found:      events,
+			wantIDs:    []string{"c", "b"},
+			wantNext:   afterB,
+			wantDetail: map[string]string{},
+		},
+		{
+			name: "should return last page with filters",
+			role: store.RoleAdmin,
+			request: &pb.QueryAuditLogRequest{
+				PageSize:  2,
+				PageToken: afterB,
+				Types:     []pb.AuthEvent_Type{pb.AuthEvent_SIGNUP, pb.AuthEvent_LOGIN},
+				Outcomes:  []pb.AuthEvent_Outcome{pb.AuthEvent_SUCCESS},
+				UserId:    "someID2",
+				StartTime: timestamppb.New(created),
+			},
+			filter: store.AuthEventFilter{
+				Types:    []string{store.EventSignup, store.EventLogin},
+				Outcomes: []string{store.OutcomeSuccess},
+				UserID:   "someID2",
+				Since:    created,
+			},
+			before:  &store.Cursor{CreatedAt: created, ID: "b"},
+			limit:   3,
+			found:   events[2:],
+			wantIDs: []string{"a"},
+			wantDetail: map[string]string{
+				"types":     "signup,login",
+				"outcomes":  "success",
+				"userId":    "someID2",
+				"startTime": "2021-09-01T10:00:00Z",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := adminUsersStore(tt.role)
+			mockStore.On("QueryAuthEvents", mock.Anything, tt.filter, tt.before, tt.limit).Return(tt.found, nil)
+
+			s := AdminServer{store: mockStore}
+			got, err := s.QueryAuditLog(ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("QueryAuditLog() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				mockStore.AssertNotCalled(t, "QueryAuthEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			var ids []string
+			for _, event := range got.Events {
+				ids = append(ids, event.Id)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || got.NextPageToken != tt.wantNext {
+				t.Fatalf("QueryAuditLog() got %v, %q, want %v, %q", ids, got.NextPageToken, tt.wantIDs, tt.wantNext)
+			}
+			mockStore.AssertCalled(t, "RecordAuthEvent", mock.Anything,
+				&store.AuthEvent{Type: store.EventAdminAction, Action: "query_audit_log", Outcome: store.OutcomeSuccess, Actor: "someID", Details: tt.wantDetail})
+		})
+	}
+}
+
+func TestAdminServer_QueryAuditLog_export(t *testing.T) {
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	created := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	events := []store.AuthEvent{
+		{ID: "b", Type: store.EventAdminAction, Action: "suspend_user", Outcome: store.OutcomeSuccess, Actor: "=cmd()", Target: "someID2",
+			Details: map[string]string{"reason": "spam"}, CreatedAt: created},
+		{ID: "a", Type: store.EventOTPSent, Outcome: store.OutcomeSuccess, Target: "+15005550006", CreatedAt: created},
+	}
+
+	mockStore := adminUsersStore(store.RoleAdmin)
+	mockStore.On("QueryAuthEvents", mock.Anything, store.AuthEventFilter{}, (*store.Cursor)(nil), defaultPageSize+1).Return(events, nil)
+	s := AdminServer{store: mockStore}
+
+	got, err := s.QueryAuditLog(ctx, &pb.QueryAuditLogRequest{Format: pb.QueryAuditLogRequest_CSV})
+	if err != nil {
+		t.Fatalf("QueryAuditLog() CSV error = %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(got.Data))).ReadAll()
+	if err != nil {
+		t.Fatalf("QueryAuditLog() CSV is invalid: %v", err)
+	}
+	want := [][]string{
+		eventColumns,
+		{"b", "2021-09-01T10:00:00Z", "admin_action", "suspend_user", "success", "", "'=cmd()", "someID2", "", "", `{"reason":"spam"}`},
+		{"a", "2021-09-01T10:00:00Z", "otp_sent", "", "success", "", "", "+15005550006", "", "", "{}"},
+	}
+	if got.ContentType != "text/csv" || len(got.Events) != 0 || !reflect.DeepEqual(records, want) {
+		t.Errorf("QueryAuditLog() CSV got %q %v, want text/csv %v", got.ContentType, records, want)
+	}
+
+	got, err = s.QueryAuditLog(ctx, &pb.QueryAuditLogRequest{Format: pb.QueryAuditLogRequest_JSON})
+	if err != nil {
+		t.Fatalf("QueryAuditLog() JSON error = %v", err)
+	}
+	var exported []exportEvent
+	if err := json.Unmarshal(got.Data, &exported); err != nil {
+		t.Fatalf("QueryAuditLog() JSON is invalid: %v", err)
+	}
+	if got.ContentType != "application/json" || len(exported) != 2 || exported[0].Actor != "=cmd()" || exported[1].Details == nil {
+		t.Errorf("QueryAuditLog() JSON got %q %+v", got.ContentType, exported)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func adminContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("admin-token", token))
+}
+
+func TestAdminServer_SaveSender(t *testing.T) {
+	mockStore := new(store.MockStore)
+	var config utils.Config
+	config.Auth.AdminToken = "someAdminToken"
+	mockStore.On("GetConfig").Return(config)
+	mockStore.On("InTx", mock.Anything).Return(nil)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("SaveSender", mock.Anything, &store.Sender{Address: "+15005550006", Kind: store.SenderNumber, Countries: []string{"US", "CA"}, RatePerSecond: 1, Enabled: true}).Return(nil)
+	mockStore.On("SaveSender", mock.Anything, &store.Sender{Address: "Flahmingo", Kind: store.SenderAlphanumeric, Enabled: true}).Return(nil)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		request *pb.Sender
+		want    *pb.Sender
+		wantErr error
+	}{
+		{
+			name:    "should fail without admin token",
+			ctx:     context.Background(),
+			request: &pb.Sender{Address: "+15005550006"},
+			wantErr: status.Error(codes.Unauthenticated, "could not find admin token"),
+		},
+		{
+			name:    "should fail with wrong admin token",
+			ctx:     adminContext("wrongToken"),
+			request: &pb.Sender{Address: "+15005550006"},
+			wantErr: status.Error(codes.PermissionDenied, "invalid admin token"),
+		},
+		{
+			name:    "should fail when number is not in E.164 format",
+			ctx:     adminContext("someAdminToken"),
+			request: &pb.Sender{Address: "5005550006"},
+			wantErr: status.Error(codes.InvalidArgument, "phone number must be in E.164 format"),
+		},
+		{
+			name:    "should fail when alphanumeric id is too long",
+			ctx:     adminContext("someAdminToken"),
+			request: &pb.Sender{Address: "FlahmingoHomework", Kind: pb.Sender_ALPHANUMERIC},
+			wantErr: status.Error(codes.InvalidArgument, "alphanumeric sender id must have up to 11 letters, digits or spaces and at least one letter"),
+		},
+		{
+			name:    "should fail when country code is invalid",
+			ctx:     adminContext("someAdminToken"),
+			request: &pb.Sender{Address: "+15005550006", Countries: []string{"USA"}},
+			wantErr: status.Error(codes.InvalidArgument, `invalid country code "USA"`),
+		},
+		{
+			name:    "should save number with normalized countries",
+			ctx:     adminContext("someAdminToken"),
+			request: &pb.Sender{Address: "+15005550006", Countries: []string{"us", " CA"}, RatePerSecond: 1, Enabled: true},
+			want:    &pb.Sender{Address: "+15005550006", Countries: []string{"US", "CA"}, RatePerSecond: 1, Enabled: true},
+		},
+		{
+			name:    "should save alphanumeric sender id",
+			ctx:     adminContext("someAdminToken"),
+			request: &pb.Sender{Address: "Flahmingo", Kind: pb.Sender_ALPHANUMERIC, Enabled: true},
+			want:    &pb.Sender{Address: "Flahmingo", Kind: pb.Sender_ALPHANUMERIC, Enabled: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := AdminServer{store: mockStore}
+			got, err := s.SaveSender(tt.ctx, tt.request)
+			if !equalErrors(err, tt.wantErr) {
+				t.Errorf("SaveSender() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("SaveSender() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminServer_DeleteSender(t *testing.T) {
+	mockStore := new(store.MockStore)
+	mockStore.On("GetConfig").Return(utils.Config{})
+
+	s := AdminServer{store: mockStore}
+	_, err := s.DeleteSender(adminContext(""), &pb.DeleteSenderRequest{Address: "+15005550006"})
+	if want := status.Error(codes.PermissionDenied, "admin token is disabled"); !equalErrors(err, want) {
+		t.Errorf("DeleteSender() without admin token in config error = %v, want %v", err, want)
+	}
+
+	mockStore = new(store.MockStore)
+	var config utils.Config
+	config.Auth.AdminToken = "someAdminToken"
+	mockStore.On("GetConfig").Return(config)
+	mockStore.On("InTx", mock.Anything).Return(nil)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("DeleteSender", mock.Anything, "+15005550006").Return(nil)
+	mockStore.On("DeleteSender", mock.Anything, "+15005550007").Return(store.ErrNotFound)
+
+	s = AdminServer{store: mockStore}
+	if _, err := s.DeleteSender(adminContext("someAdminToken"), &pb.DeleteSenderRequest{Address: "+15005550006"}); err != nil {
+		t.Errorf("DeleteSender() error = %v", err)
+	}
+	_, err = s.DeleteSender(adminContext("someAdminToken"), &pb.DeleteSenderRequest{Address: "+15005550007"})
+	if want := errorWithDetails(codes.NotFound, reasonNotFound, "sender not found", 0, map[string]string{"resource": "sender"}); !equalErrors(err, want) {
+		t.Errorf("DeleteSender() of unknown sender error = %v, want %v", err, want)
+	}
+	// only the deletion which was done is recorded
+	mockStore.AssertNumberOfCalls(t, "RecordAuthEvent", 1)
+	mockStore.AssertCalled(t, "RecordAuthEvent", mock.Anything, &store.AuthEvent{Type: store.EventAdminAction, Action: "delete_sender", Outcome: store.OutcomeSuccess, Actor: adminTokenActor, Target: "+15005550006"})
+}
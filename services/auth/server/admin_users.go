@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// page sizes of ListUsers and QueryAuditLog
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var phonePrefixPattern = regexp.MustCompile(`^\+?\d{1,15}$`)
+
+// pageToken is the position of the last user or event of a page, encoded in the nextPageToken of ListUsers and QueryAuditLog
+type pageToken struct {
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+}
+
+func encodePageToken(cursor store.Cursor) string {
+	data, _ := json.Marshal(pageToken{CreatedAt: cursor.CreatedAt, ID: cursor.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(token string) (*store.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var t pageToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &store.Cursor{CreatedAt: t.CreatedAt, ID: t.ID}, nil
+}
+
+// requestPageSize returns the page size of a request, or the default one if it is not set
+func requestPageSize(requested int32) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, status.Error(codes.InvalidArgument, "page size can not be negative")
+	case requested == 0:
+		return defaultPageSize, nil
+	case requested > maxPageSize:
+		return maxPageSize, nil
+	}
+	return int(requested), nil
+}
+
+// ListUsers returns a page of users matching the filters, ordered by creation time
+func (s AdminServer) ListUsers(ctx context.Context, request *pb.ListUsersRequest) (*pb.UserList, error) {
+	actor, err := s.authorize(ctx, store.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := requestPageSize(request.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *store.Cursor
+	if request.PageToken != "" {
+		if after, err = decodePageToken(request.PageToken); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+
+	var filter store.UserFilter
+	details := map[string]string{}
+	switch request.Verification {
+	case pb.ListUsersRequest_VERIFIED, pb.ListUsersRequest_UNVERIFIED:
+		verified := request.Verification == pb.ListUsersRequest_VERIFIED
+		filter.Verified = &verified
+		details["verified"] = strconv.FormatBool(verified)
+	}
+	if request.CreatedAfter != nil {
+		filter.CreatedAfter = request.CreatedAfter.AsTime()
+		details["createdAfter"] = filter.CreatedAfter.Format(time.RFC3339)
+	}
+	if request.CreatedBefore != nil {
+		filter.CreatedBefore = request.CreatedBefore.AsTime()
+		details["createdBefore"] = filter.CreatedBefore.Format(time.RFC3339)
+	}
+	if request.PhoneNumberPrefix != "" {
+		if !phonePrefixPattern.MatchString(request.PhoneNumberPrefix) {
+			return nil, status.Error(codes.InvalidArgument, "phone number prefix must have only digits and an optional leading +")
+		}
+		filter.PhonePrefix = request.PhoneNumberPrefix
+		details["phoneNumberPrefix"] = filter.PhonePrefix
+	}
+
+	var users []store.User
+	err = s.audited(ctx, actor, store.AuthEvent{Action: "list_users", Details: details}, func(tx store.GenericStore) error {
+		// one more user is fetched to know if there is a next page
+		users, err = tx.ListUsers(ctx, filter, after, pageSize+1)
+		if err != nil {
+			return storeError(err, "user", "could not list users")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := &pb.UserList{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		last := users[pageSize-1]
+		list.NextPageToken = encodePageToken(store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	for i := range users {
+		list.Users = append(list.Users, adminUserToPb(&users[i]))
+	}
+	return list, nil
+}
+
+// GetUser returns a user by id
+func (s AdminServer) GetUser(ctx context.Context, request *pb.AdminUserRequest) (*pb.User, error) {
+	actor, err := s.authorize(ctx, store.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *store.User
+	err = s.audited(ctx, actor, userAction("get_user", request.UserId, nil), func(tx store.GenericStore) error {
+		user, err = getUser(ctx, tx, request.UserId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adminUserToPb(user), nil
+}
+
+// SuspendUser suspends a user for abuse and revokes its auth tokens
+func (s AdminServer) SuspendUser(ctx context.Context, request *pb.UserStatusRequest) (*pb.User, error) {
+	return s.restrictUser(ctx, "suspend_user", store.StatusSuspended, request)
+}
+
+// UnsuspendUser lifts the suspension of a user
+func (s AdminServer) UnsuspendUser(ctx context.Context, request *pb.AdminUserRequest) (*pb.User, error) {
+	return s.restoreUser(ctx, "unsuspend_user", store.StatusSuspended, request)
+}
+
+// LockUser locks a user to protect it and revokes its auth tokens
+func (s AdminServer) LockUser(ctx context.Context, request *pb.UserStatusRequest) (*pb.User, error) {
+	return s.restrictUser(ctx, "lock_user", store.StatusLocked, request)
+}
+
+// UnlockUser unlocks a locked user
+func (s AdminServer) UnlockUser(ctx context.Context, request *pb.AdminUserRequest) (*pb.User, error) {
+	return s.restoreUser(ctx, "unlock_user", store.StatusLocked, request)
+}
+
+// restrictUser suspends or locks a user for the reason of the request.
+// Users with a higher role than the actor can not be restricted.
+func (s AdminServer) restrictUser(ctx context.Context, action, to string, request *pb.UserStatusRequest) (*pb.User, error) {
+	actor, err := s.authorize(ctx, store.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+	reason := strings.TrimSpace(request.Reason)
+	if reason == "" {
+		return nil, status.Error(codes.InvalidArgument, "reason is empty")
+	}
+
+	return s.setUserStatus(ctx, actor, action, request.UserId, map[string]string{"reason": reason}, func(user *store.User) (string, string, error) {
+		if roleRanks[user.Role] > roleRanks[actor.Role] {
+			return "", "", roleRequiredError(user.Role)
+		}
+		return to, reason, nil
+	})
+}
+
+// restoreUser lifts the suspension or lock of a user, the user becomes active again or pending if it is not verified.
+// Users with a higher role than the actor can not be restored, like they can not be restricted.
+func (s AdminServer) restoreUser(ctx context.Context, action, from string, request *pb.AdminUserRequest) (*pb.User, error) {
+	actor, err := s.authorize(ctx, store.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.setUserStatus(ctx, actor, action, request.UserId, nil, func(user *store.User) (string, string, error) {
+		if roleRanks[user.Role] > roleRanks[actor.Role] {
+			return "", "", roleRequiredError(user.Role)
+		}
+		if user.Status != from {
+			return "", "", status.Error(codes.FailedPrecondition, "user is not "+from)
+		}
+		if !user.IsVerified {
+			return store.StatusPending, "", nil
+		}
+		return store.StatusActive, "", nil
+	})
+}
+
+// setUserStatus changes the status of a user to the one returned by next, as an audited action
+func (s AdminServer) setUserStatus(ctx context.Context, actor adminActor, action, id string, details map[string]string,
+	next func(user *store.User) (to, reason string, err error)) (*pb.User, error) {
+	var user *store.User
+	err := s.audited(ctx, actor, userAction(action, id, details), func(tx store.GenericStore) error {
+		target, err := getUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		to, reason, err := next(target)
+		if err != nil {
+			return err
+		}
+		if err := tx.SetUserStatus(ctx, id, to, reason); err != nil {
+			return storeError(err, "user", "could not change status")
+		}
+		if to == store.StatusSuspended || to == store.StatusLocked {
+			recordEvent(ctx, tx, tokenRevoked(actor.ID, id, action), nil)
+		}
+		user, err = getUser(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adminUserToPb(user), nil
+}
+
+// ForceVerify marks the phone number of a user as verified without an otp, pending users become active
+func (s AdminServer) ForceVerify(ctx context.Context, request *pb.AdminUserRequest) (*pb.User, error) {
+	actor, err := s.authorize(ctx, store.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *store.User
+	err = s.audited(ctx, actor, userAction("force_verify", request.UserId, nil), func(tx store.GenericStore) error {
+		user, err = getUser(ctx, tx, request.UserId)
+		if err != nil {
+			return err
+		}
+		if err := tx.VerifyUser(ctx, user.PhoneNumber); err != nil {
+			return storeError(err, "user", "could not verify user")
+		}
+		user, err = getUser(ctx, tx, request.UserId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adminUserToPb(user), nil
+}
+
+// DeleteUser deletes the account of a user like DeleteAccount does
+func (s AdminServer) DeleteUser(ctx context.Context, request *pb.AdminUserRequest) (*pb.AccountDeletion, error) {
+	actor, err := s.authorize(ctx, store.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.audited(ctx, actor, userAction("delete_user", request.UserId, nil), func(tx store.GenericStore) error {
+		if err := tx.DeleteUser(ctx, request.UserId); err != nil {
+			return storeError(err, "user", "could not delete user")
+		}
+		recordEvent(ctx, tx, tokenRevoked(actor.ID, request.UserId, "delete_user"), nil)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accountDeletion(s.store.GetConfig()), nil
+}
+
+// SetUserRole changes the role of a user
+func (s AdminServer) SetUserRole(ctx context.Context, request *pb.SetUserRoleRequest) (*pb.User, error) {
+	actor, err := s.authorize(ctx, store.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := roleFromPb[request.Role]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown role")
+	}
+
+	var user *store.User
+	err = s.audited(ctx, actor, userAction("set_role", request.UserId, map[string]string{"role": role}), func(tx store.GenericStore) error {
+		if err := tx.SetUserRole(ctx, request.UserId, role); err != nil {
+			return storeError(err, "user", "could not change role")
+		}
+		user, err = getUser(ctx, tx, request.UserId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adminUserToPb(user), nil
+}
+
+// userAction is an admin action taken on the user with the id
+func userAction(action, id string, details map[string]string) store.AuthEvent {
+	return store.AuthEvent{Action: action, UserID: id, Target: id, Details: details}
+}
+
+// getUser returns the user with the id or a grpc error
+func getUser(ctx context.Context, tx store.GenericStore, id string) (*store.User, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is empty")
+	}
+	user, err := tx.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+	return user, nil
+}
+
+var roleFromPb = map[pb.User_Role]string{
+	pb.User_USER:    store.RoleUser,
+	pb.User_SUPPORT: store.RoleSupport,
+	pb.User_ADMIN:   store.RoleAdmin,
+}
+
+// adminUserToPb converts the user with the fields only returned by the admin service
+func adminUserToPb(user *store.User) *pb.User {
+	u := userToPb(user)
+	u.IsVerified = user.IsVerified
+	for r, role := range roleFromPb {
+		if role == user.Role {
+			u.Role = r
+		}
+	}
+	u.Status = statusToPb[user.Status]
+	u.StatusReason = user.StatusReason
+	u.StatusChangeTime = timestampToPb(user.StatusChangedAt)
+	return u
+}
+
+var statusToPb = map[string]pb.User_Status{
+	store.StatusPending:   pb.User_PENDING,
+	store.StatusActive:    pb.User_ACTIVE,
+	store.StatusSuspended: pb.User_SUSPENDED,
+	store.StatusLocked:    pb.User_LOCKED,
+	store.StatusDeleted:   pb.User_DELETED,
+}
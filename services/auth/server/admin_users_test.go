@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+)
+
+// adminUsersStore returns a mock store where the user of testutils.MockToken1 has the role
+func adminUsersStore(role string) *store.MockStore {
+	privateKey := testutils.GetMockPrivateKey1()
+	actor := testutils.MockUser1
+	actor.Role = role
+	var config utils.Config
+	config.Auth.AdminToken = "someAdminToken"
+
+	mockStore := new(store.MockStore)
+	mockStore.On("GetConfig").Return(config)
+	mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+	mockStore.On("GetUser", mock.Anything, "someNumber").Return(&actor, nil)
+	mockStore.On("InTx", mock.Anything).Return(nil)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	return mockStore
+}
+
+func TestAdminServer_authorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		role       string
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{
+			name:     "should fail without tokens",
+			ctx:      context.Background(),
+			role:     store.RoleAdmin,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:       "should fail for users without a role",
+			ctx:        testutils.GetContextWithAuthToken(testutils.MockToken1),
+			role:       store.RoleUser,
+			wantCode:   codes.PermissionDenied,
+			wantReason: reasonRoleRequired,
+		},
+		{
+			name:       "should fail for support users with sender requests",
+			ctx:        testutils.GetContextWithAuthToken(testutils.MockToken1),
+			role:       store.RoleSupport,
+			wantCode:   codes.PermissionDenied,
+			wantReason: reasonRoleRequired,
+		},
+		{
+			name: "should allow admin users",
+			ctx:  testutils.GetContextWithAuthToken(testutils.MockToken1),
+			role: store.RoleAdmin,
+		},
+		{
+			name: "should allow the admin token",
+			ctx:  adminContext("someAdminToken"),
+			role: store.RoleUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := adminUsersStore(tt.role)
+			mockStore.On("ListSenders", mock.Anything).Return([]store.Sender{}, nil)
+
+			s := AdminServer{store: mockStore}
+			_, err := s.ListSenders(tt.ctx, nil)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("ListSenders() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantReason != "" {
+				if info, _ := errorDetails(err); info == nil || info.Reason != tt.wantReason {
+					t.Errorf("ListSenders() error details = %v, want reason %v", info, tt.wantReason)
+				}
+			}
+			if tt.wantCode != codes.OK {
+				mockStore.AssertNotCalled(t, "ListSenders", mock.Anything)
+				mockStore.AssertNotCalled(t, "RecordAuthEvent", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestAdminServer_ListUsers(t *testing.T) {
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	created := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	users := []store.User{
+		{ID: "a", PhoneNumber: "+9779841000000", CreatedAt: created},
+		{ID: "b", PhoneNumber: "+9779841000001", CreatedAt: created},
+		{ID: "c", PhoneNumber: "+9779841000002", CreatedAt: created.Add(time.Second)},
+	}
+	verified := true
+	afterB := encodePageToken(store.Cursor{CreatedAt: users[1].CreatedAt, ID: users[1].ID})
+
+	tests := []struct {
+		name       string
+		request    *pb.ListUsersRequest
+		filter     store.UserFilter
+		after      *store.Cursor
+		limit      int
+		found      []store.User
+		wantIDs    []string
+		wantNext   string
+		wantCode   codes.Code
+		wantDetail map[string]string
+	}{
+		{
+			name:     "should fail with invalid page token",
+			request:  &pb.ListUsersRequest{PageToken: "not a token"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with invalid phone prefix",
+			request:  &pb.ListUsersRequest{PhoneNumberPrefix: "+977%"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:       "should return first page with next page token",
+			request:    &pb.ListUsersRequest{PageSize: 2},
+			limit:      3,
+			found:      users,
+			wantIDs:    []string{"a", "b"},
+			wantNext:   afterB,
+			wantDetail: map[string]string{},
+		},
+		{
+			name: "should return last page with filters",
+			request: &pb.ListUsersRequest{
+				PageSize:          2,
+				PageToken:         afterB,
+				Verification:      pb.ListUsersRequest_VERIFIED,
+				CreatedAfter:      timestamppb.New(created),
+				PhoneNumberPrefix: "+977",
+			},
+			filter:     store.UserFilter{Verified: &verified, CreatedAfter: created, PhonePrefix: "+977"},
+			after:      &store.Cursor{CreatedAt: created, ID: "b"},
+			limit:      3,
+			found:      users[2:],
+			wantIDs:    []string{"c"},
+			wantDetail: map[string]string{"verified": "true", "createdAfter": "2021-09-01T10:00:00Z", "phoneNumberPrefix": "+977"},
+		},
+		{
+			name:       "should limit page size",
+			request:    &pb.ListUsersRequest{PageSize: 10000},
+			limit:      maxPageSize + 1,
+			found:      users,
+			wantIDs:    []string{"a", "b", "c"},
+			wantDetail: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := adminUsersStore(store.RoleSupport)
+			mockStore.On("ListUsers", mock.Anything, tt.filter, tt.after, tt.limit).Return(tt.found, nil)
+
+			s := AdminServer{store: mockStore}
+			got, err := s.ListUsers(ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("ListUsers() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			var ids []string
+			for _, user := range got.Users {
+				ids = append(ids, user.Id)
+			}
+			if len(ids) != len(tt.wantIDs) || got.NextPageToken != tt.wantNext {
+				t.Fatalf("ListUsers() got %v, %q, want %v, %q", ids, got.NextPageToken, tt.wantIDs, tt.wantNext)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("ListUsers() got %v, want %v", ids, tt.wantIDs)
+				}
+			}
+			mockStore.AssertCalled(t, "RecordAuthEvent", mock.Anything, &store.AuthEvent{Type: store.EventAdminAction, Action: "list_users", Outcome: store.OutcomeSuccess, Actor: "someID", Details: tt.wantDetail})
+		})
+	}
+}
+
+func TestAdminServer_SuspendUser(t *testing.T) {
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+
+	tests := []struct {
+		name       string
+		request    *pb.UserStatusRequest
+		targetRole string
+		wantCode   codes.Code
+	}{
+		{
+			name:     "should fail without reason",
+			request:  &pb.UserStatusRequest{UserId: "someID2"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail for unknown user",
+			request:  &pb.UserStatusRequest{UserId: "unknownID", Reason: "spam"},
+			wantCode: codes.NotFound,
+		},
+		{
+			name:       "should fail for users with a higher role",
+			request:    &pb.UserStatusRequest{UserId: "someID2", Reason: "spam"},
+			targetRole: store.RoleAdmin,
+			wantCode:   codes.PermissionDenied,
+		},
+		{
+			name:       "should suspend user",
+			request:    &pb.UserStatusRequest{UserId: "someID2", Reason: " spam "},
+			targetRole: store.RoleUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := testutils.MockUser2
+			target.Role = tt.targetRole
+			suspended := target
+			suspended.Status, suspended.StatusReason, suspended.StatusChangedAt = store.StatusSuspended, "spam", time.Now()
+
+			mockStore := adminUsersStore(store.RoleSupport)
+			mockStore.On("GetUserByID", mock.Anything, "unknownID").Return(nil, store.ErrNotFound)
+			mockStore.On("GetUserByID", mock.Anything, "someID2").Return(&target, nil).Once()
+			mockStore.On("GetUserByID", mock.Anything, "someID2").Return(&suspended, nil)
+			mockStore.On("SetUserStatus", mock.Anything, "someID2", store.StatusSuspended, "spam").Return(nil)
+
+			s := AdminServer{store: mockStore}
+			got, err := s.SuspendUser(ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("SuspendUser() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				mockStore.AssertNotCalled(t, "SetUserStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				mockStore.AssertNotCalled(t, "RecordAuthEvent", mock.Anything, mock.Anything)
+				return
+			}
+			if got.Status != pb.User_SUSPENDED || got.StatusReason != "spam" || got.StatusChangeTime == nil {
+				t.Errorf("SuspendUser() got = %v, want suspended for spam", got)
+			}
+			mockStore.AssertCalled(t, "RecordAuthEvent", mock.Anything,
+				&store.AuthEvent{Type: store.EventAdminAction, Action: "suspend_user", Outcome: store.OutcomeSuccess, Actor: "someID", UserID: "someID2", Target: "someID2",
+					Details: map[string]string{"reason": "spam"}})
+		})
+	}
+}
+
+func TestAdminServer_UnlockUser(t *testing.T) {
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+
+	tests := []struct {
+		name       string
+		user       store.User
+		wantCode   codes.Code
+		wantStatus string
+	}{
+		{
+			name:     "should fail for users which are not locked",
+			user:     store.User{ID: "someID2", IsVerified: true, Status: store.StatusSuspended},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "should fail for users with a higher role",
+			user:     store.User{ID: "someID2", IsVerified: true, Status: store.StatusLocked, Role: store.RoleAdmin},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:       "should activate verified users",
+			user:       store.User{ID: "someID2", IsVerified: true, Status: store.StatusLocked},
+			wantStatus: store.StatusActive,
+		},
+		{
+			name:       "should keep unverified users pending",
+			user:       store.User{ID: "someID2", Status: store.StatusLocked},
+			wantStatus: store.StatusPending,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := adminUsersStore(store.RoleSupport)
+			mockStore.On("GetUserByID", mock.Anything, "someID2").Return(&tt.user, nil)
+			mockStore.On("SetUserStatus", mock.Anything, "someID2", tt.wantStatus, "").Return(nil)
+
+			s := AdminServer{store: mockStore}
+			_, err := s.UnlockUser(ctx, &pb.AdminUserRequest{UserId: "someID2"})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("UnlockUser() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				mockStore.AssertNotCalled(t, "SetUserStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			mockStore.AssertCalled(t, "SetUserStatus", mock.Anything, "someID2", tt.wantStatus, "")
+			mockStore.AssertCalled(t, "RecordAuthEvent", mock.Anything, &store.AuthEvent{Type: store.EventAdminAction, Action: "unlock_user", Outcome: store.OutcomeSuccess, Actor: "someID", UserID: "someID2", Target: "someID2"})
+		})
+	}
+}
+
+func TestAdminServer_DeleteUser(t *testing.T) {
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+
+	// support users can not delete users
+	mockStore := adminUsersStore(store.RoleSupport)
+	s := AdminServer{store: mockStore}
+	if _, err := s.DeleteUser(ctx, &pb.AdminUserRequest{UserId: "someID2"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DeleteUser() by support error = %v, want %v", err, codes.PermissionDenied)
+	}
+
+	mockStore = adminUsersStore(store.RoleAdmin)
+	mockStore.On("DeleteUser", mock.Anything, "someID2").Return(nil)
+	s = AdminServer{store: mockStore}
+	if _, err := s.DeleteUser(ctx, &pb.AdminUserRequest{UserId: "someID2"}); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	mockStore.AssertCalled(t, "RecordAuthEvent", mock.Anything, &store.AuthEvent{Type: store.EventAdminAction, Action: "delete_user", Outcome: store.OutcomeSuccess, Actor: "someID", UserID: "someID2", Target: "someID2"})
+}
@@ -2,117 +2,220 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
 	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
 	"github.com/bhrg3se/flahmingo-homework/utils"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"time"
 )
 
 var empty = &emptypb.Empty{}
 
-// SignupWithPhoneNumber creates a user profile and begins the phone verification process by sending the otp
-func (s Server) SignupWithPhoneNumber(ctx context.Context, request *pb.User) (*emptypb.Empty, error) {
+// SignupWithPhoneNumber creates a user profile and begins the phone verification process by sending the otp.
+// Signing up again with a number which is not verified yet sends a new otp.
+func (s Server) SignupWithPhoneNumber(ctx context.Context, request *pb.User) (_ *emptypb.Empty, err error) {
+	event := store.AuthEvent{Type: store.EventSignup, Target: request.PhoneNumber}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
 	if request.PhoneNumber == "" {
 		err := status.Error(codes.InvalidArgument, "phone number is empty")
 		logrus.Error(err)
 		return empty, err
 	}
-
-	user := store.User{
-		ID:          uuid.New().String(),
-		Name:        request.Name,
-		IsVerified:  false,
-		PhoneNumber: request.PhoneNumber,
-	}
-	err := s.store.CreateUser(&user)
-	if err != nil {
-		logrus.Error(err)
-		return empty, status.Error(codes.Internal, "could not create user")
+	if err := validateEmail(request.Email); err != nil {
+		return empty, err
 	}
 
-	otp := utils.GetRandomOTP()
-	err = s.store.SaveOTP(otp, request.PhoneNumber)
+	err = s.allowOTPRequest(ctx, request.PhoneNumber)
 	if err != nil {
-		logrus.Error(err)
-		return nil, status.Error(codes.Internal, "could not save otp")
+		return empty, err
 	}
 
-	// publish the otp on pubsub
-	s.store.PublishOTP(ctx, otp, request.PhoneNumber)
-	return empty, nil
+	// the user, its otp and the otp message are saved together, so that a failed signup can be retried
+	err = s.store.InTx(ctx, func(tx store.GenericStore) error {
+		existing, err := tx.GetUser(ctx, request.PhoneNumber)
+		switch {
+		case err == nil && existing.IsVerified:
+			return phoneNumberRegisteredError()
+		case err == nil:
+			event.UserID = existing.ID
+		case errors.Is(err, store.ErrNotFound):
+			// the email is kept as pending email until the link sent to it is opened
+			user := store.User{
+				ID:           uuid.New().String(),
+				Name:         request.Name,
+				IsVerified:   false,
+				PhoneNumber:  request.PhoneNumber,
+				PendingEmail: request.Email,
+			}
+			event.UserID = user.ID
+			err = tx.CreateUser(ctx, &user)
+			if err != nil {
+				return storeError(err, "user", "could not create user")
+			}
+			if user.PendingEmail != "" {
+				if err := s.allowOTPRequest(ctx, user.PendingEmail); err != nil {
+					return err
+				}
+				if err := s.sendEmailVerification(ctx, tx, user.ID, user.PendingEmail, request.Locale); err != nil {
+					return err
+				}
+			}
+		case err != nil:
+			return storeError(err, "user", "could not create user")
+		}
+
+		otp := utils.GetRandomOTP()
+		err = tx.SaveOTP(ctx, otp, request.PhoneNumber)
+		if err != nil {
+			return storeError(err, "otp", "could not save otp")
+		}
+
+		// publish the otp on pubsub once the user is saved
+		err = tx.PublishOTP(ctx, store.OTPMessage{
+			ID:          deliveryID(ctx),
+			OTP:         otp,
+			PhoneNumber: request.PhoneNumber,
+			Channel:     channelFromPb(request.Channel),
+			Purpose:     store.PurposeSignup,
+			Locale:      request.Locale,
+		})
+		if err != nil {
+			return storeError(err, "otp", "could not send otp")
+		}
+		return nil
+	})
+	if _, ok := status.FromError(err); !ok {
+		// the transaction itself failed
+		return empty, storeError(err, "user", "could not create user")
+	}
+	if err == nil {
+		s.recordEvent(ctx, otpSent(event.UserID, request.PhoneNumber, store.PurposeSignup), nil)
+	}
+	return empty, err
 }
 
 // VerifyPhoneNumber takes otp entered by client and checks in database to verify it.
 // If everything is good, user is marked as verified
 func (s Server) VerifyPhoneNumber(ctx context.Context, request *pb.VerifyPhoneNumberRequest) (*emptypb.Empty, error) {
-	otp, err := s.store.GetOTP(request.PhoneNumber)
-	if err != nil {
-		logrus.Error(err)
-		return empty, status.Error(codes.Internal, "could not get otp")
+	var userID string
+	if user, err := s.store.GetUser(ctx, request.PhoneNumber); err == nil {
+		userID = user.ID
 	}
-
-	if request.Otp != otp {
-		logrus.Trace(request.Otp, otp)
-		return empty, status.Error(codes.Unauthenticated, "invalid otp")
+	err := s.checkOTP(ctx, userID, request.PhoneNumber, request.Otp)
+	if err != nil {
+		return empty, err
 	}
 
-	err = s.store.VerifyUser(request.PhoneNumber)
+	err = s.store.VerifyUser(ctx, request.PhoneNumber)
 	if err != nil {
-		logrus.Error(err)
-		return empty, status.Error(codes.Internal, "could not verify user")
+		return empty, storeError(err, "user", "could not verify user")
 	}
 
 	return empty, nil
 }
 
-func (s Server) LoginWithPhoneNumber(ctx context.Context, request *pb.User) (*emptypb.Empty, error) {
+// LoginWithPhoneNumber sends an otp to login to a registered user, unless the user can not login because of its status
+func (s Server) LoginWithPhoneNumber(ctx context.Context, request *pb.User) (_ *emptypb.Empty, err error) {
+	event := otpSent("", request.PhoneNumber, store.PurposeLogin)
+	defer func() { s.recordEvent(ctx, event, err) }()
 
-	_, err := s.store.GetUser(request.PhoneNumber)
+	user, err := s.store.GetUser(ctx, request.PhoneNumber)
+	if errors.Is(err, store.ErrNotFound) {
+		return empty, errorWithDetails(codes.NotFound, reasonNotFound, "phone number not registered", 0, map[string]string{"resource": "user"})
+	}
 	if err != nil {
-		logrus.Debug(err)
-		return empty, status.Error(codes.InvalidArgument, "phone number not registered")
+		return empty, storeError(err, "user", "could not fetch user")
+	}
+	event.UserID = user.ID
+	if err := userStatusError(user); err != nil {
+		return empty, err
+	}
+
+	err = s.allowOTPRequest(ctx, request.PhoneNumber)
+	if err != nil {
+		return empty, err
 	}
 
 	otp := utils.GetRandomOTP()
-	err = s.store.SaveOTP(otp, request.PhoneNumber)
+	err = s.store.SaveOTP(ctx, otp, request.PhoneNumber)
 	if err != nil {
-		logrus.Error(err)
-		return nil, status.Error(codes.Internal, "could not save otp")
+		return nil, storeError(err, "otp", "could not save otp")
 	}
 
 	// publish the otp on pubsub
-	s.store.PublishOTP(ctx, otp, request.PhoneNumber)
+	err = s.store.PublishOTP(ctx, store.OTPMessage{
+		ID:          deliveryID(ctx),
+		OTP:         otp,
+		PhoneNumber: request.PhoneNumber,
+		Channel:     channelFromPb(request.Channel),
+		Purpose:     store.PurposeLogin,
+		Locale:      request.Locale,
+	})
+	if err != nil {
+		return nil, storeError(err, "otp", "could not send otp")
+	}
 	return empty, nil
 }
 
 // ValidatePhoneNumberLogin takes token from client,verifies it and then creates a jwt auth token and returns it.
-func (s Server) ValidatePhoneNumberLogin(ctx context.Context, request *pb.VerifyPhoneNumberRequest) (*pb.Token, error) {
-	otp, err := s.store.GetOTP(request.PhoneNumber)
-	if err != nil {
-		logrus.Error(err)
-		return nil, status.Error(codes.Internal, "could not get otp")
+func (s Server) ValidatePhoneNumberLogin(ctx context.Context, request *pb.VerifyPhoneNumberRequest) (_ *pb.Token, err error) {
+	event := store.AuthEvent{Type: store.EventLogin, Target: request.PhoneNumber, Details: map[string]string{"method": "phone"}}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	// the user is fetched before the otp is checked, so that failed attempts are recorded with it.
+	// Its status is checked on the primary database before issuing the token.
+	user, err := s.store.GetUser(store.Primary(ctx), request.PhoneNumber)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+	if user != nil {
+		event.UserID = user.ID
 	}
 
-	if request.Otp != otp {
-		return nil, status.Error(codes.Unauthenticated, "invalid otp")
+	err = s.checkOTP(ctx, event.UserID, request.PhoneNumber, request.Otp)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, storeError(store.ErrNotFound, "user", "could not fetch user")
+	}
+	if err := userStatusError(user); err != nil {
+		return nil, err
 	}
 
-	token, err := generateAuthToken(request.PhoneNumber, s.store.GetJWTPrivateKey())
+	token, err := s.issueToken(ctx, user, store.EventTokenIssued)
 	if err != nil {
-		logrus.Error(err)
-		return nil, status.Error(codes.Internal, "could not generate token")
+		return nil, err
+	}
+	if s.alertNewDevice(ctx, user) {
+		event.Details["newDevice"] = "true"
 	}
-	return &pb.Token{Token: token}, nil
+	return token, nil
 }
 
-// GetProfile return profile of user based on auth token if the given token is valid
+// GetProfile return profile of user based on auth token if the given token is valid.
+// Users which are not active, like unverified users, get PermissionDenied with the reason of their status.
 func (s Server) GetProfile(ctx context.Context, e *emptypb.Empty) (*pb.User, error) {
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return userToPb(user), nil
+}
+
+// authenticate parses the auth token in the "token" metadata and checks that it is not expired
+func (s Server) authenticate(ctx context.Context) (*JWTToken, error) {
 	// get auth token from metadata
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
@@ -134,25 +237,294 @@ func (s Server) GetProfile(ctx context.Context, e *emptypb.Empty) (*pb.User, err
 	if !token.VerifyExpiresAt(time.Now().Unix(), true) {
 		return nil, status.Error(codes.Unauthenticated, "auth token expired")
 	}
+	return token, nil
+}
 
-	// get user profile from database
-	user, err := s.store.GetUser(token.PhoneNumber)
+// authenticatedUser returns the user of the auth token, if the token was not revoked and the user is active
+func (s Server) authenticatedUser(ctx context.Context) (*store.User, error) {
+	token, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// get user profile from the primary database, a lagging replica could miss revocations and status changes.
+	// Older tokens have only the phone number.
+	var user *store.User
+	if token.Subject != "" {
+		user, err = s.store.GetUserByID(ctx, token.Subject)
+	} else {
+		user, err = s.store.GetUser(store.Primary(ctx), token.PhoneNumber)
+	}
+	if err != nil {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+
+	if err := userStatusError(user); err != nil {
+		return nil, err
+	}
+	if token.TokenVersion != user.TokenVersion {
+		return nil, errorWithDetails(codes.Unauthenticated, reasonTokenRevoked, "auth token revoked", 0, nil)
+	}
+	return user, nil
+}
+
+// deliveryID returns a new id for an otp message to a phone number and sends it to the client in the "delivery-id" header,
+// so that the client can get the delivery status of the message without an auth token
+func deliveryID(ctx context.Context) string {
+	id := uuid.New().String()
+	if err := grpc.SetHeader(ctx, metadata.Pairs("delivery-id", id)); err != nil {
+		logrus.Debugf("could not send delivery id: %v", err)
+	}
+	return id
+}
+
+// GetDeliveryStatus returns the delivery status of the latest otp sent to the phone number.
+// Only callers with the auth token of the phone number get the details of the delivery. Others get a coarse status
+// if the otp can still be used and they prove they requested it with its delivery id, otherwise the status is unknown,
+// so that they can not tell if the number is registered or when otps were sent to it.
+func (s Server) GetDeliveryStatus(ctx context.Context, request *pb.DeliveryStatusRequest) (*pb.DeliveryStatus, error) {
+	if request.PhoneNumber == "" {
+		return nil, status.Error(codes.InvalidArgument, "phone number is empty")
+	}
+
+	config := s.store.GetConfig()
+	ip, _ := clientInfo(ctx)
+	retryAfter, err := s.store.AllowRequest(ctx, "delivery-status:"+ip, config.Auth.DeliveryStatusLimit, config.Auth.DeliveryStatusWindow)
+	if err != nil {
+		return nil, storeError(err, "delivery status requests", "could not check delivery status requests")
+	}
+	if retryAfter > 0 {
+		msg := fmt.Sprintf("too many delivery statuses requested, try again in %v", retryAfter.Round(time.Second))
+		return nil, errorWithDetails(codes.ResourceExhausted, reasonRateLimited, msg, retryAfter, nil)
+	}
+
+	var owner bool
+	if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("token")) > 0 {
+		user, err := s.authenticatedUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if user.PhoneNumber != request.PhoneNumber {
+			return nil, status.Error(codes.PermissionDenied, "auth token is not of this phone number")
+		}
+		owner = true
+	}
+
+	delivery, err := s.store.GetLatestDelivery(ctx, request.PhoneNumber)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, storeError(err, "delivery", "could not get delivery status")
+	}
+	if !owner {
+		coarse := &pb.DeliveryStatus{PhoneNumber: request.PhoneNumber, Status: pb.DeliveryStatus_UNKNOWN}
+		requested := request.DeliveryId != "" && delivery != nil &&
+			subtle.ConstantTimeCompare([]byte(request.DeliveryId), []byte(delivery.MessageID)) == 1
+		if requested && time.Since(delivery.CreatedAt) < config.Auth.OTPExpiry {
+			coarse.Status = deliveryStatusToPb(delivery.Status)
+		}
+		return coarse, nil
+	}
+	if delivery == nil {
+		return nil, errorWithDetails(codes.NotFound, reasonNotFound, "no otp sent to this phone number", 0, map[string]string{"resource": "delivery"})
+	}
+
+	return &pb.DeliveryStatus{
+		MessageId:   delivery.MessageID,
+		PhoneNumber: delivery.PhoneNumber,
+		Status:      deliveryStatusToPb(delivery.Status),
+		ErrorCode:   delivery.ErrorCode,
+		UpdatedAt:   timestamppb.New(delivery.UpdatedAt),
+		Channel:     channelToPb(delivery.Channel),
+	}, nil
+}
+
+func deliveryStatusToPb(s string) pb.DeliveryStatus_Status {
+	switch s {
+	case store.DeliveryQueued:
+		return pb.DeliveryStatus_QUEUED
+	case store.DeliverySent:
+		return pb.DeliveryStatus_SENT
+	case store.DeliveryDelivered:
+		return pb.DeliveryStatus_DELIVERED
+	case store.DeliveryFailed:
+		return pb.DeliveryStatus_FAILED
+	}
+	return pb.DeliveryStatus_UNKNOWN
+}
+
+// LoginWithEmail sends an otp and a magic link to the email of a registered user
+func (s Server) LoginWithEmail(ctx context.Context, request *pb.LoginWithEmailRequest) (_ *emptypb.Empty, err error) {
+	event := otpSent("", request.Email, store.PurposeEmailLogin)
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	if request.Email == "" {
+		return empty, status.Error(codes.InvalidArgument, "email is empty")
+	}
+
+	user, err := s.store.GetUserByEmail(ctx, request.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		return empty, errorWithDetails(codes.NotFound, reasonNotFound, "email not registered", 0, map[string]string{"resource": "user"})
+	}
+	if err != nil {
+		return empty, storeError(err, "user", "could not fetch user")
+	}
+	event.UserID = user.ID
+	if err := userStatusError(user); err != nil {
+		return empty, err
+	}
+
+	err = s.allowOTPRequest(ctx, request.Email)
+	if err != nil {
+		return empty, err
+	}
+
+	otp := utils.GetRandomOTP()
+	err = s.store.SaveOTP(ctx, otp, request.Email)
+	if err != nil {
+		return nil, storeError(err, "otp", "could not save otp")
+	}
+
+	// create a single use token and embed it in the magic link
+	config := s.store.GetConfig()
+	expiry := time.Now().Add(config.Auth.MagicLinkExpiry)
+	token, id, err := generateMagicLinkToken(request.Email, expiry, s.store.GetJWTPrivateKey())
 	if err != nil {
 		logrus.Error(err)
-		return nil, status.Error(codes.Internal, "could not fetch user")
+		return nil, status.Error(codes.Internal, "could not generate magic link")
+	}
+	err = s.store.SaveMagicLink(ctx, id, request.Email, expiry)
+	if err != nil {
+		return nil, storeError(err, "magic link", "could not save magic link")
+	}
+
+	link, err := tokenLink(config.Auth.MagicLinkURL, token)
+	if err != nil {
+		logrus.Error(err)
+		return nil, status.Error(codes.Internal, "could not generate magic link")
+	}
+
+	// publish the otp and magic link on pubsub
+	err = s.store.PublishOTP(ctx, store.OTPMessage{
+		OTP:       otp,
+		Email:     request.Email,
+		MagicLink: link,
+		Channel:   store.ChannelEmail,
+		Purpose:   store.PurposeEmailLogin,
+		Locale:    request.Locale,
+	})
+	if err != nil {
+		return nil, storeError(err, "otp", "could not send otp")
 	}
+	return empty, nil
+}
+
+// ValidateEmailLogin takes otp sent to the email, verifies it and then creates a jwt auth token and returns it.
+func (s Server) ValidateEmailLogin(ctx context.Context, request *pb.VerifyEmailRequest) (_ *pb.Token, err error) {
+	event := store.AuthEvent{Type: store.EventLogin, Target: request.Email, Details: map[string]string{"method": "email"}}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	if user, err := s.store.GetUserByEmail(ctx, request.Email); err == nil {
+		event.UserID = user.ID
+	}
+	err = s.checkOTP(ctx, event.UserID, request.Email, request.Otp)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.emailLoginToken(ctx, request.Email, &event)
+}
 
-	userPb := pb.User{
-		Id:          user.ID,
-		Name:        user.Name,
-		PhoneNumber: user.PhoneNumber,
+// ValidateMagicLink takes the token carried in a magic link, verifies it and then creates a jwt auth token and returns it.
+// Each magic link can be used only once.
+func (s Server) ValidateMagicLink(ctx context.Context, request *pb.ValidateMagicLinkRequest) (_ *pb.Token, err error) {
+	event := store.AuthEvent{Type: store.EventLogin, Details: map[string]string{"method": "magic_link"}}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	claims, err := parseMagicLinkToken(request.Token, s.store.GetJWTPublicKey())
+	if err != nil {
+		logrus.Debug(err)
+		return nil, status.Error(codes.Unauthenticated, "invalid magic link")
 	}
+	event.Target = claims.Email
 
-	if !user.IsVerified {
-		//TODO notify user that profile is not verified
-		//return &userPb, status.Error(codes.PermissionDenied,"user not verified")
-		return &userPb, nil
+	err = s.store.UseMagicLink(ctx, claims.Id)
+	if errors.Is(err, store.ErrMagicLinkInvalid) {
+		return nil, errorWithDetails(codes.Unauthenticated, reasonMagicLinkInvalid, "magic link is expired or already used", 0, nil)
+	}
+	if err != nil {
+		return nil, storeError(err, "magic link", "could not use magic link")
 	}
 
-	return &userPb, nil
+	return s.emailLoginToken(ctx, claims.Email, &event)
+}
+
+// checkOTP checks the otp of a phone number or email and converts store errors to grpc errors.
+// The check is recorded as an otp_verified or otp_failed event of the user with the id, which is empty if it is not known.
+func (s Server) checkOTP(ctx context.Context, userID, key, otp string) (err error) {
+	defer func() {
+		event := store.AuthEvent{Type: store.EventOTPVerified, UserID: userID, Target: key}
+		if err != nil {
+			event.Type = store.EventOTPFailed
+		}
+		s.recordEvent(ctx, event, err)
+	}()
+
+	err = s.store.CheckOTP(ctx, key, otp)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, store.ErrOTPInvalid):
+		return errorWithDetails(codes.Unauthenticated, reasonOTPInvalid, "invalid otp", 0, nil)
+	case errors.Is(err, store.ErrOTPExpired):
+		return errorWithDetails(codes.Unauthenticated, reasonOTPExpired, "otp expired", 0, nil)
+	case errors.Is(err, store.ErrTooManyAttempts):
+		return errorWithDetails(codes.ResourceExhausted, reasonTooManyAttempts, "too many attempts, request a new otp", 0, nil)
+	}
+	return storeError(err, "otp", "could not get otp")
+}
+
+// allowOTPRequest returns error if too many otps were requested for the phone number or email
+func (s Server) allowOTPRequest(ctx context.Context, key string) error {
+	retryAfter, err := s.store.AllowOTPRequest(ctx, key)
+	if err != nil {
+		return storeError(err, "otp requests", "could not check otp requests")
+	}
+	if retryAfter > 0 {
+		msg := fmt.Sprintf("too many otps requested, try again in %v", retryAfter.Round(time.Second))
+		return errorWithDetails(codes.ResourceExhausted, reasonRateLimited, msg, retryAfter, nil)
+	}
+	return nil
+}
+
+// emailLoginToken creates an auth token for the user with the given email, it sets the user of the login event
+func (s Server) emailLoginToken(ctx context.Context, email string, event *store.AuthEvent) (*pb.Token, error) {
+	user, err := s.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+	event.UserID = user.ID
+	if err := userStatusError(user); err != nil {
+		return nil, err
+	}
+
+	return s.issueToken(ctx, user, store.EventTokenIssued)
+}
+
+func channelFromPb(c pb.Channel) string {
+	switch c {
+	case pb.Channel_VOICE:
+		return store.ChannelVoice
+	case pb.Channel_WHATSAPP:
+		return store.ChannelWhatsApp
+	}
+	return store.ChannelSMS
+}
+
+func channelToPb(c string) pb.Channel {
+	switch c {
+	case store.ChannelVoice:
+		return pb.Channel_VOICE
+	case store.ChannelWhatsApp:
+		return pb.Channel_WHATSAPP
+	}
+	return pb.Channel_SMS
 }
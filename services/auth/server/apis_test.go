@@ -2,24 +2,35 @@ package server
 
 import (
 	"context"
-	"database/sql"
 	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
 	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
 	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"reflect"
 	"testing"
+	"time"
 )
 
+// otpMessageTo matches otp messages sent to the phone number through the channel
+func otpMessageTo(phoneNumber, channel string) interface{} {
+	return mock.MatchedBy(func(msg store.OTPMessage) bool {
+		return msg.PhoneNumber == phoneNumber && msg.Channel == channel && len(msg.OTP) == 6
+	})
+}
+
 func TestServer_GetProfile(t *testing.T) {
 	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
 	privateKey := testutils.GetMockPrivateKey1()
 
 	mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
-	mockStore.On("GetUser", "someNumber").Return(&testutils.MockUser1, nil)
+	mockStore.On("GetUser", mock.Anything, "someNumber").Return(&testutils.MockUser1, nil)
 
 	type fields struct {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
@@ -95,19 +106,22 @@ func TestServer_GetProfile(t *testing.T) {
 			}
 
 			mockStore.AssertCalled(t, "GetJWTPublicKey")
-			mockStore.AssertCalled(t, "GetUser", "someNumber")
+			mockStore.AssertCalled(t, "GetUser", mock.Anything, "someNumber")
 		})
 	}
 }
 
 func TestServer_LoginWithPhoneNumber(t *testing.T) {
 	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
 
-	mockStore.On("SaveOTP", mock.AnythingOfType("string"), testutils.MockUser2.PhoneNumber).Return(nil)
-	mockStore.On("GetUser", "").Return(nil, sql.ErrNoRows)
+	mockStore.On("SaveOTP", mock.Anything, mock.AnythingOfType("string"), testutils.MockUser2.PhoneNumber).Return(nil)
+	mockStore.On("AllowOTPRequest", mock.Anything, testutils.MockUser2.PhoneNumber).Return(time.Duration(0), nil)
+	mockStore.On("GetUser", mock.Anything, "").Return(nil, store.ErrNotFound)
 
-	mockStore.On("GetUser", testutils.MockUser2.PhoneNumber).Return(&testutils.MockUser2, nil)
-	mockStore.On("PublishOTP", context.Background(), mock.AnythingOfType("string"), testutils.MockUser2.PhoneNumber).Return(nil)
+	mockStore.On("GetUser", mock.Anything, testutils.MockUser2.PhoneNumber).Return(&testutils.MockUser2, nil)
+	mockStore.On("PublishOTP", context.Background(), otpMessageTo(testutils.MockUser2.PhoneNumber, store.ChannelSMS)).Return(nil)
+	mockStore.On("PublishOTP", context.Background(), otpMessageTo(testutils.MockUser2.PhoneNumber, store.ChannelVoice)).Return(nil)
 
 	type fields struct {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
@@ -156,6 +170,23 @@ func TestServer_LoginWithPhoneNumber(t *testing.T) {
 			wantErr: false,
 		},
 
+		{
+			name: "should pass when otp is requested through voice call",
+			fields: fields{
+				UnimplementedAuthServiceServer: pb.UnimplementedAuthServiceServer{},
+				store:                          mockStore,
+			},
+			args: args{
+				ctx: context.Background(),
+				request: &pb.User{
+					PhoneNumber: testutils.MockUser2.PhoneNumber,
+					Channel:     pb.Channel_VOICE,
+				},
+			},
+			want:    &emptypb.Empty{},
+			wantErr: false,
+		},
+
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
@@ -175,15 +206,26 @@ func TestServer_LoginWithPhoneNumber(t *testing.T) {
 			}
 		})
 	}
+
+	mockStore.AssertCalled(t, "PublishOTP", context.Background(), otpMessageTo(testutils.MockUser2.PhoneNumber, store.ChannelVoice))
 }
 
 func TestServer_SignupWithPhoneNumber(t *testing.T) {
 	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	unverified := store.User{ID: "someID3", Name: "Unverified User", PhoneNumber: "21234567891"}
 
-	mockStore.On("SaveOTP", mock.AnythingOfType("string"), testutils.MockUser2.PhoneNumber).Return(nil)
+	mockStore.On("InTx", mock.Anything).Return(nil)
+	mockStore.On("GetUser", mock.Anything, testutils.MockUser2.PhoneNumber).Return(nil, store.ErrNotFound)
+	mockStore.On("GetUser", mock.Anything, unverified.PhoneNumber).Return(&unverified, nil)
+	mockStore.On("GetUser", mock.Anything, testutils.MockUser1.PhoneNumber).Return(&testutils.MockUser1, nil)
+	mockStore.On("AllowOTPRequest", mock.Anything, mock.Anything).Return(time.Duration(0), nil)
+	mockStore.On("SaveOTP", mock.Anything, mock.AnythingOfType("string"), testutils.MockUser2.PhoneNumber).Return(nil)
+	mockStore.On("SaveOTP", mock.Anything, mock.AnythingOfType("string"), unverified.PhoneNumber).Return(nil)
 
-	mockStore.On("CreateUser", mock.Anything).Return(nil)
-	mockStore.On("PublishOTP", context.Background(), mock.AnythingOfType("string"), testutils.MockUser2.PhoneNumber).Return(nil)
+	mockStore.On("CreateUser", mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("PublishOTP", context.Background(), otpMessageTo(testutils.MockUser2.PhoneNumber, store.ChannelSMS)).Return(nil)
+	mockStore.On("PublishOTP", context.Background(), otpMessageTo(unverified.PhoneNumber, store.ChannelSMS)).Return(nil)
 
 	type fields struct {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
@@ -232,6 +274,37 @@ func TestServer_SignupWithPhoneNumber(t *testing.T) {
 			want:    &emptypb.Empty{},
 			wantErr: false,
 		},
+		{
+			name: "should send a new otp when phone number is not verified",
+			fields: fields{
+				UnimplementedAuthServiceServer: pb.UnimplementedAuthServiceServer{},
+				store:                          mockStore,
+			},
+			args: args{
+				ctx: context.Background(),
+				request: &pb.User{
+					PhoneNumber: unverified.PhoneNumber,
+					Name:        unverified.Name,
+				},
+			},
+			want:    &emptypb.Empty{},
+			wantErr: false,
+		},
+		{
+			name: "should fail when phone number is already verified",
+			fields: fields{
+				UnimplementedAuthServiceServer: pb.UnimplementedAuthServiceServer{},
+				store:                          mockStore,
+			},
+			args: args{
+				ctx: context.Background(),
+				request: &pb.User{
+					PhoneNumber: testutils.MockUser1.PhoneNumber,
+				},
+			},
+			want:    &emptypb.Empty{},
+			wantErr: true,
+		},
 
 		// TODO: Add test cases.
 	}
@@ -251,17 +324,29 @@ func TestServer_SignupWithPhoneNumber(t *testing.T) {
 			}
 		})
 	}
+
+	// the unverified user is not created again
+	mockStore.AssertNumberOfCalls(t, "CreateUser", 1)
+	mockStore.AssertCalled(t, "PublishOTP", context.Background(), otpMessageTo(unverified.PhoneNumber, store.ChannelSMS))
 }
 
 func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 
 	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
 	privateKey := testutils.GetMockPrivateKey1()
 
 	mockStore.On("GetJWTPrivateKey").Return(privateKey)
-	mockStore.On("GetOTP", testutils.MockUser2.PhoneNumber).Return("123456", nil)
-	mockStore.On("GetOTP", "").Return("", sql.ErrNoRows)
-	mockStore.On("GetUser", testutils.MockUser2.PhoneNumber).Return(testutils.MockUser2)
+	mockStore.On("CheckOTP", mock.Anything, testutils.MockUser2.PhoneNumber, "123456").Return(nil)
+	mockStore.On("CheckOTP", mock.Anything, testutils.MockUser2.PhoneNumber, mock.Anything).Return(store.ErrOTPInvalid)
+	mockStore.On("CheckOTP", mock.Anything, "", mock.Anything).Return(store.ErrNotFound)
+	mockStore.On("GetUser", mock.Anything, testutils.MockUser2.PhoneNumber).Return(&testutils.MockUser2, nil)
+	mockStore.On("GetUser", mock.Anything, "").Return(nil, store.ErrNotFound)
+
+	suspendedUser := testutils.MockUser1
+	suspendedUser.Status = store.StatusSuspended
+	mockStore.On("CheckOTP", mock.Anything, suspendedUser.PhoneNumber, "123456").Return(nil)
+	mockStore.On("GetUser", mock.Anything, suspendedUser.PhoneNumber).Return(&suspendedUser, nil)
 
 	type fields struct {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
@@ -292,7 +377,7 @@ func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 				},
 			},
 			want:    nil,
-			wantErr: status.Error(codes.Unauthenticated, "invalid otp"),
+			wantErr: errorWithDetails(codes.Unauthenticated, reasonOTPInvalid, "invalid otp", 0, nil),
 		},
 		{
 			name: "should fail when phone number is empty",
@@ -308,7 +393,7 @@ func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 				},
 			},
 			want:    nil,
-			wantErr: status.Error(codes.Internal, "could not get otp"),
+			wantErr: errorWithDetails(codes.NotFound, reasonNotFound, "otp not found", 0, map[string]string{"resource": "otp"}),
 		},
 		{
 			name: "should fail when otp is different",
@@ -324,7 +409,7 @@ func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 				},
 			},
 			want:    nil,
-			wantErr: status.Error(codes.Unauthenticated, "invalid otp"),
+			wantErr: errorWithDetails(codes.Unauthenticated, reasonOTPInvalid, "invalid otp", 0, nil),
 		},
 		{
 			name: "should pass",
@@ -341,6 +426,21 @@ func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "should fail when account is suspended",
+			fields: fields{
+				UnimplementedAuthServiceServer: pb.UnimplementedAuthServiceServer{},
+				store:                          mockStore,
+			},
+			args: args{
+				ctx: context.Background(),
+				request: &pb.VerifyPhoneNumberRequest{
+					PhoneNumber: suspendedUser.PhoneNumber,
+					Otp:         "123456",
+				},
+			},
+			wantErr: userStatusError(&suspendedUser),
+		},
 
 		// TODO: Add test cases.
 	}
@@ -353,7 +453,7 @@ func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 			got, err := s.ValidatePhoneNumberLogin(tt.args.ctx, tt.args.request)
 
 			if err != nil {
-				if !reflect.DeepEqual(err, tt.wantErr) {
+				if !equalErrors(err, tt.wantErr) {
 					t.Errorf("ValidatePhoneNumberLogin() got = %v, want %v", got, tt.want)
 				}
 			} else {
@@ -371,9 +471,13 @@ func TestServer_ValidatePhoneNumberLogin(t *testing.T) {
 
 func TestServer_VerifyPhoneNumber(t *testing.T) {
 	mockStore := new(store.MockStore)
-	mockStore.On("GetOTP", testutils.MockUser2.PhoneNumber).Return("123456", nil)
-	mockStore.On("GetOTP", "").Return("", sql.ErrNoRows)
-	mockStore.On("VerifyUser", testutils.MockUser2.PhoneNumber).Return(nil).Times(1)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("CheckOTP", mock.Anything, testutils.MockUser2.PhoneNumber, "123456").Return(nil)
+	mockStore.On("CheckOTP", mock.Anything, testutils.MockUser2.PhoneNumber, mock.Anything).Return(store.ErrOTPInvalid)
+	mockStore.On("CheckOTP", mock.Anything, "", mock.Anything).Return(store.ErrNotFound)
+	mockStore.On("GetUser", mock.Anything, testutils.MockUser2.PhoneNumber).Return(&testutils.MockUser2, nil)
+	mockStore.On("GetUser", mock.Anything, "").Return(nil, store.ErrNotFound)
+	mockStore.On("VerifyUser", mock.Anything, testutils.MockUser2.PhoneNumber).Return(nil).Times(1)
 
 	type fields struct {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
@@ -405,7 +509,7 @@ func TestServer_VerifyPhoneNumber(t *testing.T) {
 				},
 			},
 			want:    empty,
-			wantErr: status.Error(codes.Unauthenticated, "invalid otp"),
+			wantErr: errorWithDetails(codes.Unauthenticated, reasonOTPInvalid, "invalid otp", 0, nil),
 		},
 		{
 			name: "should fail when phone number is empty",
@@ -421,7 +525,7 @@ func TestServer_VerifyPhoneNumber(t *testing.T) {
 				},
 			},
 			want:    empty,
-			wantErr: status.Error(codes.Internal, "could not get otp"),
+			wantErr: errorWithDetails(codes.NotFound, reasonNotFound, "otp not found", 0, map[string]string{"resource": "otp"}),
 		},
 		{
 			name: "should fail when otp is different",
@@ -437,7 +541,7 @@ func TestServer_VerifyPhoneNumber(t *testing.T) {
 				},
 			},
 			want:    empty,
-			wantErr: status.Error(codes.Unauthenticated, "invalid otp"),
+			wantErr: errorWithDetails(codes.Unauthenticated, reasonOTPInvalid, "invalid otp", 0, nil),
 		},
 		{
 			name: "should pass",
@@ -465,7 +569,7 @@ func TestServer_VerifyPhoneNumber(t *testing.T) {
 				store:                          tt.fields.store,
 			}
 			got, err := s.VerifyPhoneNumber(tt.args.ctx, tt.args.request)
-			if !reflect.DeepEqual(err, tt.wantErr) {
+			if !equalErrors(err, tt.wantErr) {
 				t.Errorf("VerifyPhoneNumber() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
@@ -475,3 +579,285 @@ func TestServer_VerifyPhoneNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_GetDeliveryStatus(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	config := utils.Config{}
+	config.Auth.OTPExpiry = 5 * time.Minute
+	config.Auth.DeliveryStatusLimit = 30
+	config.Auth.DeliveryStatusWindow = 15 * time.Minute
+	updatedAt := time.Now().Add(-time.Minute)
+	ownerCtx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	delivery := &store.Delivery{
+		MessageID:   "someMessageID",
+		PhoneNumber: testutils.MockUser1.PhoneNumber,
+		Provider:    "twilio",
+		ProviderSID: "SM123",
+		Status:      store.DeliveryFailed,
+		ErrorCode:   "30003",
+		CreatedAt:   updatedAt,
+		UpdatedAt:   updatedAt,
+	}
+	expired := *delivery
+	expired.CreatedAt = time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		request    *pb.DeliveryStatusRequest
+		retryAfter time.Duration
+		delivery   *store.Delivery
+		want       *pb.DeliveryStatus
+		wantErr    error
+	}{
+		{
+			name:    "should fail when phone number is empty",
+			ctx:     context.Background(),
+			request: &pb.DeliveryStatusRequest{},
+			wantErr: status.Error(codes.InvalidArgument, "phone number is empty"),
+		},
+		{
+			name:       "should fail when too many statuses were requested",
+			ctx:        context.Background(),
+			request:    &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber},
+			retryAfter: time.Minute,
+			wantErr:    errorWithDetails(codes.ResourceExhausted, reasonRateLimited, "too many delivery statuses requested, try again in 1m0s", time.Minute, nil),
+		},
+		{
+			name:     "should return only the status of an otp which can be used with its delivery id",
+			ctx:      context.Background(),
+			request:  &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber, DeliveryId: "someMessageID"},
+			delivery: delivery,
+			want:     &pb.DeliveryStatus{PhoneNumber: testutils.MockUser1.PhoneNumber, Status: pb.DeliveryStatus_FAILED},
+		},
+		{
+			name:     "should not tell if an otp was sent without delivery id",
+			ctx:      context.Background(),
+			request:  &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber},
+			delivery: delivery,
+			want:     &pb.DeliveryStatus{PhoneNumber: testutils.MockUser1.PhoneNumber, Status: pb.DeliveryStatus_UNKNOWN},
+		},
+		{
+			name:     "should not tell if an otp was sent with the delivery id of another otp",
+			ctx:      context.Background(),
+			request:  &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber, DeliveryId: "otherMessageID"},
+			delivery: delivery,
+			want:     &pb.DeliveryStatus{PhoneNumber: testutils.MockUser1.PhoneNumber, Status: pb.DeliveryStatus_UNKNOWN},
+		},
+		{
+			name:     "should not tell when older otps were sent with delivery id",
+			ctx:      context.Background(),
+			request:  &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber, DeliveryId: "someMessageID"},
+			delivery: &expired,
+			want:     &pb.DeliveryStatus{PhoneNumber: testutils.MockUser1.PhoneNumber, Status: pb.DeliveryStatus_UNKNOWN},
+		},
+		{
+			name:    "should not tell if an otp was sent without auth token",
+			ctx:     context.Background(),
+			request: &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber, DeliveryId: "someMessageID"},
+			want:    &pb.DeliveryStatus{PhoneNumber: testutils.MockUser1.PhoneNumber, Status: pb.DeliveryStatus_UNKNOWN},
+		},
+		{
+			name:    "should fail with auth token of another phone number",
+			ctx:     ownerCtx,
+			request: &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser2.PhoneNumber},
+			wantErr: status.Error(codes.PermissionDenied, "auth token is not of this phone number"),
+		},
+		{
+			name:    "should fail when no otp was sent to the owner",
+			ctx:     ownerCtx,
+			request: &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber},
+			wantErr: errorWithDetails(codes.NotFound, reasonNotFound, "no otp sent to this phone number", 0, map[string]string{"resource": "delivery"}),
+		},
+		{
+			name:     "should return status of latest otp to the owner",
+			ctx:      ownerCtx,
+			request:  &pb.DeliveryStatusRequest{PhoneNumber: testutils.MockUser1.PhoneNumber},
+			delivery: delivery,
+			want: &pb.DeliveryStatus{
+				MessageId:   "someMessageID",
+				PhoneNumber: testutils.MockUser1.PhoneNumber,
+				Status:      pb.DeliveryStatus_FAILED,
+				ErrorCode:   "30003",
+				UpdatedAt:   timestamppb.New(updatedAt),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			mockStore := new(store.MockStore)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, user.PhoneNumber).Return(&user, nil)
+			mockStore.On("AllowRequest", mock.Anything, "delivery-status:", 30, 15*time.Minute).Return(tt.retryAfter, nil)
+			if tt.delivery != nil {
+				mockStore.On("GetLatestDelivery", mock.Anything, tt.request.PhoneNumber).Return(tt.delivery, nil)
+			} else {
+				mockStore.On("GetLatestDelivery", mock.Anything, tt.request.PhoneNumber).Return(nil, store.ErrNotFound)
+			}
+
+			s := Server{store: mockStore}
+			got, err := s.GetDeliveryStatus(tt.ctx, tt.request)
+			if !equalErrors(err, tt.wantErr) {
+				t.Errorf("GetDeliveryStatus() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("GetDeliveryStatus() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ValidateMagicLink(t *testing.T) {
+	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	privateKey := testutils.GetMockPrivateKey1()
+	user := testutils.MockUser2
+	user.Email = "user2@example.com"
+
+	validToken, validID, _ := generateMagicLinkToken(user.Email, time.Now().Add(time.Minute), privateKey)
+	usedToken, usedID, _ := generateMagicLinkToken(user.Email, time.Now().Add(time.Minute), privateKey)
+
+	mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+	mockStore.On("GetJWTPrivateKey").Return(privateKey)
+	mockStore.On("UseMagicLink", mock.Anything, validID).Return(nil)
+	mockStore.On("UseMagicLink", mock.Anything, usedID).Return(store.ErrMagicLinkInvalid)
+	mockStore.On("GetUserByEmail", mock.Anything, user.Email).Return(&user, nil)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:    "should fail when token is invalid",
+			token:   testutils.MockToken1,
+			wantErr: status.Error(codes.Unauthenticated, "invalid magic link"),
+		},
+		{
+			name:    "should fail when magic link is already used",
+			token:   usedToken,
+			wantErr: errorWithDetails(codes.Unauthenticated, reasonMagicLinkInvalid, "magic link is expired or already used", 0, nil),
+		},
+		{
+			name:    "should pass",
+			token:   validToken,
+			wantErr: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{store: mockStore}
+			got, err := s.ValidateMagicLink(context.Background(), &pb.ValidateMagicLinkRequest{Token: tt.token})
+			if !equalErrors(err, tt.wantErr) {
+				t.Errorf("ValidateMagicLink() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			parsed, err := parseAuthToken(got.Token, &privateKey.PublicKey)
+			if err != nil {
+				t.Fatalf("parseAuthToken() error = %v", err)
+			}
+			if parsed.PhoneNumber != user.PhoneNumber {
+				t.Errorf("ValidateMagicLink() token phone number got = %v, want %v", parsed.PhoneNumber, user.PhoneNumber)
+			}
+		})
+	}
+}
+
+func TestServer_checkOTP(t *testing.T) {
+	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("CheckOTP", mock.Anything, "valid", "123456").Return(nil)
+	mockStore.On("CheckOTP", mock.Anything, "invalid", "123456").Return(store.ErrOTPInvalid)
+	mockStore.On("CheckOTP", mock.Anything, "expired", "123456").Return(store.ErrOTPExpired)
+	mockStore.On("CheckOTP", mock.Anything, "exhausted", "123456").Return(store.ErrTooManyAttempts)
+	mockStore.On("CheckOTP", mock.Anything, "missing", "123456").Return(store.ErrNotFound)
+
+	tests := []struct {
+		key  string
+		want codes.Code
+	}{
+		{key: "valid", want: codes.OK},
+		{key: "invalid", want: codes.Unauthenticated},
+		{key: "expired", want: codes.Unauthenticated},
+		{key: "exhausted", want: codes.ResourceExhausted},
+		{key: "missing", want: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			s := Server{store: mockStore}
+			if got := status.Code(s.checkOTP(context.Background(), "", tt.key, "123456")); got != tt.want {
+				t.Errorf("checkOTP() code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_LoginWithPhoneNumber_rateLimited(t *testing.T) {
+	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStore.On("GetUser", mock.Anything, testutils.MockUser2.PhoneNumber).Return(&testutils.MockUser2, nil)
+	mockStore.On("AllowOTPRequest", mock.Anything, testutils.MockUser2.PhoneNumber).Return(time.Minute, nil)
+
+	s := Server{store: mockStore}
+	_, err := s.LoginWithPhoneNumber(context.Background(), &pb.User{PhoneNumber: testutils.MockUser2.PhoneNumber})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("LoginWithPhoneNumber() error = %v, want %v", err, codes.ResourceExhausted)
+	}
+	if info, retry := errorDetails(err); info.GetReason() != reasonRateLimited || retry.GetRetryDelay().AsDuration() != time.Minute {
+		t.Errorf("LoginWithPhoneNumber() error details = %v %v, want %s and retry after %v", info, retry, reasonRateLimited, time.Minute)
+	}
+	mockStore.AssertNotCalled(t, "SaveOTP", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestServer_userStatus(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+
+	tests := []struct {
+		status     string
+		wantReason string
+	}{
+		{status: store.StatusPending, wantReason: reasonAccountPending},
+		{status: store.StatusSuspended, wantReason: reasonAccountSuspended},
+		{status: store.StatusLocked, wantReason: reasonAccountLocked},
+		{status: store.StatusActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			user := testutils.MockUser1
+			user.Status = tt.status
+
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, user.PhoneNumber).Return(&user, nil)
+			mockStore.On("AllowOTPRequest", mock.Anything, user.PhoneNumber).Return(time.Duration(0), nil)
+			mockStore.On("SaveOTP", mock.Anything, mock.Anything, user.PhoneNumber).Return(nil)
+			mockStore.On("PublishOTP", mock.Anything, mock.Anything).Return(nil)
+			s := Server{store: mockStore}
+
+			_, loginErr := s.LoginWithPhoneNumber(context.Background(), &pb.User{PhoneNumber: user.PhoneNumber})
+			_, profileErr := s.GetProfile(testutils.GetContextWithAuthToken(testutils.MockToken1), empty)
+			for name, err := range map[string]error{"LoginWithPhoneNumber": loginErr, "GetProfile": profileErr} {
+				if tt.wantReason == "" {
+					if err != nil {
+						t.Errorf("%s() error = %v", name, err)
+					}
+					continue
+				}
+				if info, _ := errorDetails(err); status.Code(err) != codes.PermissionDenied || info.GetReason() != tt.wantReason {
+					t.Errorf("%s() error = %v, want %v with reason %v", name, err, codes.PermissionDenied, tt.wantReason)
+				}
+			}
+			if tt.wantReason != "" {
+				mockStore.AssertNotCalled(t, "SaveOTP", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
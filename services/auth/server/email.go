@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+// validateEmail returns error if the email is not a plain address like "user@example.com", empty emails are valid
+func validateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > maxEmailLength {
+		return status.Error(codes.InvalidArgument, "invalid email")
+	}
+	return nil
+}
+
+// tokenLink adds the token as "token" query parameter to the url of an app page
+func tokenLink(pageURL, token string) (string, error) {
+	link, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// sendEmailVerification sends a single use link to the email, which makes it the email of the user once it is opened.
+// The link is saved and published with tx, so that it is sent only if the email is saved as pending email of the user.
+func (s Server) sendEmailVerification(ctx context.Context, tx store.GenericStore, userID, email, locale string) error {
+	config := s.store.GetConfig()
+	expiry := time.Now().Add(config.Auth.EmailVerificationExpiry)
+	token, id, err := generateEmailVerificationToken(userID, email, expiry, s.store.GetJWTPrivateKey())
+	if err != nil {
+		logrus.Error(err)
+		return status.Error(codes.Internal, "could not generate email verification link")
+	}
+	link, err := tokenLink(config.Auth.EmailVerificationURL, token)
+	if err != nil {
+		logrus.Error(err)
+		return status.Error(codes.Internal, "could not generate email verification link")
+	}
+
+	err = tx.SaveMagicLink(ctx, id, email, expiry)
+	if err != nil {
+		return storeError(err, "magic link", "could not save email verification link")
+	}
+	err = tx.PublishOTP(ctx, store.OTPMessage{
+		Email:     email,
+		MagicLink: link,
+		Channel:   store.ChannelEmail,
+		Purpose:   store.PurposeEmailVerification,
+		Locale:    locale,
+	})
+	if err != nil {
+		return storeError(err, "otp", "could not send email verification link")
+	}
+	return nil
+}
+
+// ConfirmEmail takes the token of an email verification link and makes the email in it the email of the user,
+// if the user did not change its pending email since the link was sent
+func (s Server) ConfirmEmail(ctx context.Context, request *pb.ConfirmEmailRequest) (_ *emptypb.Empty, err error) {
+	event := store.AuthEvent{Type: store.EventEmailConfirmed}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	claims, err := parseEmailVerificationToken(request.Token, s.store.GetJWTPublicKey())
+	if err != nil {
+		logrus.Debug(err)
+		return empty, status.Error(codes.Unauthenticated, "invalid email verification link")
+	}
+	event.UserID, event.Target = claims.Subject, claims.Email
+
+	// the link is used only if the email is confirmed, so that it can be opened again after a failure
+	err = s.store.InTx(ctx, func(tx store.GenericStore) error {
+		err := tx.UseMagicLink(ctx, claims.Id)
+		if errors.Is(err, store.ErrMagicLinkInvalid) {
+			return errorWithDetails(codes.Unauthenticated, reasonMagicLinkInvalid, "email verification link is expired or already used", 0, nil)
+		}
+		if err != nil {
+			return storeError(err, "magic link", "could not use email verification link")
+		}
+
+		err = tx.ConfirmEmail(ctx, claims.Subject, claims.Email)
+		if errors.Is(err, store.ErrNotFound) {
+			return status.Error(codes.FailedPrecondition, "the email was changed since the link was sent")
+		}
+		if errors.Is(err, store.ErrAlreadyExists) {
+			return errorWithDetails(codes.AlreadyExists, reasonAlreadyExists, "email already registered", 0, map[string]string{"resource": "user"})
+		}
+		if err != nil {
+			return storeError(err, "user", "could not confirm email")
+		}
+		return nil
+	})
+	if _, ok := status.FromError(err); !ok {
+		// the transaction itself failed
+		return empty, storeError(err, "user", "could not confirm email")
+	}
+	return empty, err
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestServer_SignupWithEmail(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	config := utils.Config{}
+	config.Auth.EmailVerificationURL = "https://app.example.com/email/confirm"
+	config.Auth.EmailVerificationExpiry = time.Hour
+	phone := testutils.MockUser2.PhoneNumber
+
+	tests := []struct {
+		name     string
+		email    string
+		wantErr  error
+		wantLink bool
+	}{
+		{
+			name:    "should fail when email is invalid",
+			email:   "Someone <someone@example.com>",
+			wantErr: status.Error(codes.InvalidArgument, "invalid email"),
+		},
+		{
+			name: "should not send a link without email",
+		},
+		{
+			name:     "should keep the email pending and send a link to confirm it",
+			email:    "someone@example.com",
+			wantLink: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var link string
+			verification := mock.MatchedBy(func(msg store.OTPMessage) bool {
+				return msg.Purpose == store.PurposeEmailVerification && msg.Email == tt.email && msg.OTP == "" && msg.Channel == store.ChannelEmail
+			})
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetJWTPrivateKey").Return(privateKey)
+			mockStore.On("InTx", mock.Anything).Return(nil)
+			mockStore.On("GetUser", mock.Anything, phone).Return(nil, store.ErrNotFound)
+			mockStore.On("AllowOTPRequest", mock.Anything, mock.Anything).Return(time.Duration(0), nil)
+			mockStore.On("CreateUser", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("SaveOTP", mock.Anything, mock.Anything, phone).Return(nil)
+			mockStore.On("SaveMagicLink", mock.Anything, mock.Anything, tt.email, mock.Anything).Return(nil)
+			mockStore.On("PublishOTP", mock.Anything, otpMessageTo(phone, store.ChannelSMS)).Return(nil)
+			mockStore.On("PublishOTP", mock.Anything, verification).Return(nil).Run(func(args mock.Arguments) {
+				link = args.Get(1).(store.OTPMessage).MagicLink
+			})
+
+			s := Server{store: mockStore}
+			_, err := s.SignupWithPhoneNumber(context.Background(), &pb.User{PhoneNumber: phone, Email: tt.email})
+			if !equalErrors(err, tt.wantErr) {
+				t.Fatalf("SignupWithPhoneNumber() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				mockStore.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+				return
+			}
+
+			mockStore.AssertCalled(t, "CreateUser", mock.Anything, mock.MatchedBy(func(user *store.User) bool {
+				return user.Email == "" && user.PendingEmail == tt.email
+			}))
+			if !tt.wantLink {
+				mockStore.AssertNotCalled(t, "PublishOTP", mock.Anything, verification)
+				return
+			}
+			parsed, err := url.Parse(link)
+			if err != nil {
+				t.Fatalf("SignupWithPhoneNumber() sent link %q: %v", link, err)
+			}
+			claims, err := parseEmailVerificationToken(parsed.Query().Get("token"), &privateKey.PublicKey)
+			if err != nil || claims.Email != tt.email || claims.Subject == "" {
+				t.Errorf("SignupWithPhoneNumber() sent link token %+v, %v, want token of %s", claims, err, tt.email)
+			}
+		})
+	}
+}
+
+func TestServer_ConfirmEmail(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	expiry := time.Now().Add(time.Minute)
+	token := func(userID, email string) (string, string) {
+		token, id, err := generateEmailVerificationToken(userID, email, expiry, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token, id
+	}
+	magicLink, _, _ := generateMagicLinkToken("someone@example.com", expiry, privateKey)
+
+	tests := []struct {
+		name       string
+		token      string
+		useErr     error
+		confirmErr error
+		wantErr    error
+	}{
+		{
+			name:    "should fail with magic link token",
+			token:   magicLink,
+			wantErr: status.Error(codes.Unauthenticated, "invalid email verification link"),
+		},
+		{
+			name:    "should fail when link is already used",
+			useErr:  store.ErrMagicLinkInvalid,
+			wantErr: errorWithDetails(codes.Unauthenticated, reasonMagicLinkInvalid, "email verification link is expired or already used", 0, nil),
+		},
+		{
+			name:       "should fail when pending email was changed",
+			confirmErr: store.ErrNotFound,
+			wantErr:    status.Error(codes.FailedPrecondition, "the email was changed since the link was sent"),
+		},
+		{
+			name:       "should fail when another user has the email",
+			confirmErr: store.ErrAlreadyExists,
+			wantErr:    errorWithDetails(codes.AlreadyExists, reasonAlreadyExists, "email already registered", 0, map[string]string{"resource": "user"}),
+		},
+		{
+			name: "should confirm email",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, id := token("someID", "someone@example.com")
+			if tt.token != "" {
+				request = tt.token
+			}
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("InTx", mock.Anything).Return(nil)
+			mockStore.On("UseMagicLink", mock.Anything, id).Return(tt.useErr)
+			mockStore.On("ConfirmEmail", mock.Anything, "someID", "someone@example.com").Return(tt.confirmErr)
+
+			s := Server{store: mockStore}
+			_, err := s.ConfirmEmail(context.Background(), &pb.ConfirmEmailRequest{Token: request})
+			if !equalErrors(err, tt.wantErr) {
+				t.Errorf("ConfirmEmail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
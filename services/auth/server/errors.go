@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"time"
+)
+
+// errorDomain is the domain of the ErrorInfo details of errors
+const errorDomain = "auth.flahmingo"
+
+// reasons of the ErrorInfo details of errors. Unlike messages they do not change, so clients can rely on them.
+const (
+	reasonNotFound         = "NOT_FOUND"
+	reasonAlreadyExists    = "ALREADY_EXISTS"
+	reasonExpired          = "EXPIRED"
+	reasonConflict         = "CONFLICT"
+	reasonTimeout          = "TIMEOUT"
+	reasonCanceled         = "CANCELED"
+	reasonInternal         = "INTERNAL"
+	reasonOTPInvalid       = "OTP_INVALID"
+	reasonOTPExpired       = "OTP_EXPIRED"
+	reasonTooManyAttempts  = "TOO_MANY_ATTEMPTS"
+	reasonRateLimited      = "RATE_LIMITED"
+	reasonMagicLinkInvalid = "MAGIC_LINK_INVALID"
+	reasonVersionMismatch  = "VERSION_MISMATCH"
+	reasonTokenRevoked     = "TOKEN_REVOKED"
+	reasonAccountSuspended = "ACCOUNT_SUSPENDED"
+	reasonAccountLocked    = "ACCOUNT_LOCKED"
+	reasonAccountPending   = "ACCOUNT_NOT_VERIFIED"
+	reasonAccountDeleted   = "ACCOUNT_DELETED"
+	reasonRoleRequired     = "ROLE_REQUIRED"
+)
+
+// how long clients should wait before retrying requests which conflicted with another one or timed out
+const (
+	conflictRetryDelay = 100 * time.Millisecond
+	timeoutRetryDelay  = time.Second
+)
+
+// errorWithDetails creates a grpc error with ErrorInfo details, and RetryInfo details if retryAfter is not 0
+func errorWithDetails(code codes.Code, reason, msg string, retryAfter time.Duration, metadata map[string]string) error {
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata})
+	if err == nil && retryAfter > 0 {
+		st, err = st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	}
+	if err != nil {
+		logrus.Error(err)
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// storeError converts an error of the store to a grpc error the same way for every api.
+// resource is what the store was asked for, msg is returned if the store itself failed.
+func storeError(err error, resource, msg string) error {
+	metadata := map[string]string{"resource": resource}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return errorWithDetails(codes.NotFound, reasonNotFound, resource+" not found", 0, metadata)
+	case errors.Is(err, store.ErrAlreadyExists):
+		return errorWithDetails(codes.AlreadyExists, reasonAlreadyExists, resource+" already exists", 0, metadata)
+	case errors.Is(err, store.ErrExpired):
+		return errorWithDetails(codes.FailedPrecondition, reasonExpired, resource+" expired", 0, metadata)
+	case errors.Is(err, store.ErrConflict):
+		logrus.Warn(err)
+		return errorWithDetails(codes.Aborted, reasonConflict, msg+", try again", conflictRetryDelay, metadata)
+	case errors.Is(err, context.DeadlineExceeded):
+		logrus.Warn(err)
+		return errorWithDetails(codes.DeadlineExceeded, reasonTimeout, msg+", try again", timeoutRetryDelay, metadata)
+	case errors.Is(err, context.Canceled):
+		return errorWithDetails(codes.Canceled, reasonCanceled, "request canceled", 0, metadata)
+	}
+	logrus.Error(err)
+	return errorWithDetails(codes.Internal, reasonInternal, msg, 0, metadata)
+}
+
+// phoneNumberRegisteredError is returned when a phone number is used by another user
+func phoneNumberRegisteredError() error {
+	return errorWithDetails(codes.AlreadyExists, reasonAlreadyExists, "phone number already registered", 0, map[string]string{"resource": "user"})
+}
+
+// userStatusError returns an error if the user can not login or use its account because of its status
+func userStatusError(user *store.User) error {
+	switch user.Status {
+	case store.StatusActive:
+		return nil
+	case store.StatusPending:
+		return errorWithDetails(codes.PermissionDenied, reasonAccountPending, "phone number not verified", 0, nil)
+	case store.StatusSuspended:
+		return errorWithDetails(codes.PermissionDenied, reasonAccountSuspended, "account suspended", 0, nil)
+	case store.StatusLocked:
+		return errorWithDetails(codes.PermissionDenied, reasonAccountLocked, "account locked", 0, nil)
+	case store.StatusDeleted:
+		return errorWithDetails(codes.PermissionDenied, reasonAccountDeleted, "account deleted", 0, nil)
+	}
+	logrus.Errorf("user %v has unknown status %q", user.ID, user.Status)
+	return errorWithDetails(codes.Internal, reasonInternal, "could not check account status", 0, nil)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+// equalErrors compares grpc errors with their details
+func equalErrors(got, want error) bool {
+	if got == nil || want == nil {
+		return got == want
+	}
+	return proto.Equal(status.Convert(got).Proto(), status.Convert(want).Proto())
+}
+
+// errorDetails returns the ErrorInfo and RetryInfo details of a grpc error
+func errorDetails(err error) (*errdetails.ErrorInfo, *errdetails.RetryInfo) {
+	var info *errdetails.ErrorInfo
+	var retry *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.RetryInfo:
+			retry = d
+		}
+	}
+	return info, retry
+}
+
+func TestStoreError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string
+		wantRetry  time.Duration
+	}{
+		{name: "not found", err: store.ErrNotFound, wantCode: codes.NotFound, wantReason: reasonNotFound},
+		{name: "already exists", err: fmt.Errorf("%w: duplicate key", store.ErrAlreadyExists), wantCode: codes.AlreadyExists, wantReason: reasonAlreadyExists},
+		{name: "expired", err: store.ErrMagicLinkInvalid, wantCode: codes.FailedPrecondition, wantReason: reasonExpired},
+		{name: "conflict", err: fmt.Errorf("%w: deadlock detected", store.ErrConflict), wantCode: codes.Aborted, wantReason: reasonConflict, wantRetry: conflictRetryDelay},
+		{name: "timeout", err: context.DeadlineExceeded, wantCode: codes.DeadlineExceeded, wantReason: reasonTimeout, wantRetry: timeoutRetryDelay},
+		{name: "canceled", err: context.Canceled, wantCode: codes.Canceled, wantReason: reasonCanceled},
+		{name: "other", err: errors.New("connection refused"), wantCode: codes.Internal, wantReason: reasonInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := storeError(tt.err, "user", "could not fetch user")
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("storeError() code = %v, want %v", code, tt.wantCode)
+			}
+
+			info, retry := errorDetails(err)
+			if info == nil || info.Reason != tt.wantReason || info.Domain != errorDomain || info.Metadata["resource"] != "user" {
+				t.Errorf("storeError() error info = %v, want reason %s", info, tt.wantReason)
+			}
+			switch {
+			case tt.wantRetry == 0 && retry != nil:
+				t.Errorf("storeError() retry info = %v, want none", retry)
+			case tt.wantRetry != 0 && (retry == nil || retry.RetryDelay.AsDuration() != tt.wantRetry):
+				t.Errorf("storeError() retry info = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"strconv"
+)
+
+// recordEvent appends the event to the audit log with the address and user agent of the client.
+// A request which failed with err is recorded as a failure with the reason of the error.
+// Events are recorded on a best effort basis, the request does not fail if the event could not be recorded.
+func recordEvent(ctx context.Context, audit store.AuditStore, event store.AuthEvent, err error) {
+	event.IP, event.UserAgent = clientInfo(ctx)
+	event.Outcome = store.OutcomeSuccess
+	if err != nil {
+		event.Outcome = store.OutcomeFailure
+		details := map[string]string{"reason": errorReason(err)}
+		for k, v := range event.Details {
+			details[k] = v
+		}
+		event.Details = details
+	}
+	if err := audit.RecordAuthEvent(ctx, &event); err != nil {
+		logrus.Errorf("could not record %s event of %q: %v", event.Type, event.Target, err)
+	}
+}
+
+// recordEvent appends the event of a request to the audit log
+func (s Server) recordEvent(ctx context.Context, event store.AuthEvent, err error) {
+	recordEvent(ctx, s.store, event, err)
+}
+
+// maxUserAgentLength is the length user agents are cut to
+const maxUserAgentLength = 500
+
+// clientInfo returns the address and the user agent of the client making the request
+func clientInfo(ctx context.Context) (ip, userAgent string) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		userAgent = ua[0]
+	}
+	// user agents are kept in columns of at most maxUserAgentLength characters
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return ip, userAgent
+}
+
+// errorReason returns the reason of the ErrorInfo details of a grpc error, or its code if it has none
+func errorReason(err error) string {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return st.Code().String()
+}
+
+// otpSent is the event of an otp sent to the target for the purpose
+func otpSent(userID, target, purpose string) store.AuthEvent {
+	return store.AuthEvent{Type: store.EventOTPSent, UserID: userID, Target: target, Details: map[string]string{"purpose": purpose}}
+}
+
+// tokenRevoked is the event of all auth tokens of a user being revoked by the actor, the cause is the action which revoked them
+func tokenRevoked(actor, userID, cause string) store.AuthEvent {
+	return store.AuthEvent{Type: store.EventTokenRevoked, UserID: userID, Actor: actor, Target: userID, Details: map[string]string{"cause": cause}}
+}
+
+// issueToken generates an auth token for the user and records it as an event of eventType
+func (s Server) issueToken(ctx context.Context, user *store.User, eventType string) (_ *pb.Token, err error) {
+	event := store.AuthEvent{Type: eventType, UserID: user.ID, Target: user.ID, Details: map[string]string{"tokenVersion": strconv.FormatInt(user.TokenVersion, 10)}}
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	token, err := generateAuthToken(user, s.store.GetJWTPrivateKey())
+	if err != nil {
+		logrus.Error(err)
+		return nil, status.Error(codes.Internal, "could not generate token")
+	}
+	return &pb.Token{Token: token}, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// recordedEvents returns a mock store which keeps the events recorded with it in events
+func recordedEvents(events *[]store.AuthEvent) *store.MockStore {
+	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		*events = append(*events, *args.Get(1).(*store.AuthEvent))
+	})
+	return mockStore
+}
+
+func TestServer_recordEvent(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user-agent", "someAgent"))
+	phone := testutils.MockUser2.PhoneNumber
+
+	tests := []struct {
+		name string
+		otp  string
+		want []store.AuthEvent
+	}{
+		{
+			name: "should record failed otp and login with the user",
+			otp:  "000000",
+			want: []store.AuthEvent{
+				{Type: store.EventOTPFailed, Outcome: store.OutcomeFailure, UserID: "someID2", Target: phone, Details: map[string]string{"reason": reasonOTPInvalid}},
+				{Type: store.EventLogin, Outcome: store.OutcomeFailure, UserID: "someID2", Target: phone, Details: map[string]string{"method": "phone", "reason": reasonOTPInvalid}},
+			},
+		},
+		{
+			name: "should record verified otp, issued token and login",
+			otp:  "123456",
+			want: []store.AuthEvent{
+				{Type: store.EventOTPVerified, Outcome: store.OutcomeSuccess, UserID: "someID2", Target: phone},
+				{Type: store.EventTokenIssued, Outcome: store.OutcomeSuccess, UserID: "someID2", Target: "someID2", Details: map[string]string{"tokenVersion": "0"}},
+				{Type: store.EventLogin, Outcome: store.OutcomeSuccess, UserID: "someID2", Target: phone, Details: map[string]string{"method": "phone"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []store.AuthEvent
+			mockStore := recordedEvents(&events)
+			mockStore.On("GetJWTPrivateKey").Return(privateKey)
+			mockStore.On("CheckOTP", mock.Anything, phone, "123456").Return(nil)
+			mockStore.On("CheckOTP", mock.Anything, phone, mock.Anything).Return(store.ErrOTPInvalid)
+			mockStore.On("GetUser", mock.Anything, phone).Return(&testutils.MockUser2, nil)
+			mockStore.On("InTx", mock.Anything).Return(nil)
+			mockStore.On("SaveDevice", mock.Anything, mock.Anything).Return(false, nil)
+
+			s := Server{store: mockStore}
+			_, _ = s.ValidatePhoneNumberLogin(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phone, Otp: tt.otp})
+
+			for i := range tt.want {
+				tt.want[i].IP, tt.want[i].UserAgent = "10.0.0.1", "someAgent"
+			}
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("ValidatePhoneNumberLogin() recorded %+v, want %+v", events, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_recordEventFailure(t *testing.T) {
+	mockStore := new(store.MockStore)
+	mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(store.ErrNotFound)
+	mockStore.On("CheckOTP", mock.Anything, "someNumber", "123456").Return(nil)
+
+	// the otp check must not fail because the event could not be recorded
+	s := Server{store: mockStore}
+	if err := s.checkOTP(context.Background(), "someID", "someNumber", "123456"); err != nil {
+		t.Errorf("checkOTP() error = %v, want nil", err)
+	}
+}
@@ -4,18 +4,22 @@ import (
 	"crypto/rsa"
 	"errors"
 	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"time"
 )
 
-// generateAuthToken generates JWT auth token with phone number embedded in it
-func generateAuthToken(phoneNumber string, privateKey *rsa.PrivateKey) (string, error) {
+// generateAuthToken generates JWT auth token with user id, phone number and token version embedded in it
+func generateAuthToken(user *store.User, privateKey *rsa.PrivateKey) (string, error) {
 
 	// Create the Claims
 	claims := JWTToken{
-		PhoneNumber: phoneNumber,
+		PhoneNumber:  user.PhoneNumber,
+		TokenVersion: user.TokenVersion,
 		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
 			ExpiresAt: int64(time.Hour * 24 * 7),
 			IssuedAt:  time.Now().Unix(),
 		},
@@ -46,12 +50,96 @@ func parseAuthToken(signedData string, publicKey *rsa.PublicKey) (*JWTToken, err
 		return nil, fmt.Errorf("could not parse signed data: %v", err)
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		tokenStruct.PhoneNumber = claims["phoneNumber"].(string)
-		tokenStruct.ExpiresAt = int64(claims["exp"].(float64))
-	} else {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	// tokens of other kinds, like magic link tokens, have no phone number
+	phoneNumber, ok := claims["phoneNumber"].(string)
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	tokenStruct.PhoneNumber = phoneNumber
+	tokenStruct.ExpiresAt = int64(claims["exp"].(float64))
+	// older tokens have no user id and token version
+	tokenStruct.Subject, _ = claims["sub"].(string)
+	if tokenVersion, ok := claims["tokenVersion"].(float64); ok {
+		tokenStruct.TokenVersion = int64(tokenVersion)
+	}
 
 	return &tokenStruct, nil
 }
+
+// generateMagicLinkToken generates a single use JWT token with email embedded in it.
+// The token id must be saved so that the token can be used only once.
+func generateMagicLinkToken(email string, expiry time.Time, privateKey *rsa.PrivateKey) (string, string, error) {
+	return generateLinkToken(magicLinkAudience, "", email, expiry, privateKey)
+}
+
+// generateEmailVerificationToken generates a single use JWT token with the user id and the email to confirm embedded in it.
+// The token id must be saved so that the token can be used only once.
+func generateEmailVerificationToken(userID, email string, expiry time.Time, privateKey *rsa.PrivateKey) (string, string, error) {
+	return generateLinkToken(emailVerificationAudience, userID, email, expiry, privateKey)
+}
+
+// generateLinkToken generates a token for links sent by email, the audience tells what the link is for
+func generateLinkToken(audience, subject, email string, expiry time.Time, privateKey *rsa.PrivateKey) (string, string, error) {
+	claims := MagicLinkToken{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   subject,
+			Audience:  audience,
+			ExpiresAt: expiry.Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+
+	signed, err := token.SignedString(privateKey)
+	if err != nil {
+		logrus.Error("could not sign: ", err)
+		return "", "", err
+	}
+
+	return signed, claims.Id, nil
+}
+
+// parseMagicLinkToken parses and validates the given magic link token
+func parseMagicLinkToken(signedData string, publicKey *rsa.PublicKey) (*MagicLinkToken, error) {
+	return parseLinkToken(signedData, magicLinkAudience, publicKey)
+}
+
+// parseEmailVerificationToken parses and validates the given email verification token
+func parseEmailVerificationToken(signedData string, publicKey *rsa.PublicKey) (*MagicLinkToken, error) {
+	claims, err := parseLinkToken(signedData, emailVerificationAudience, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// parseLinkToken parses and validates a token of a link sent by email, which must be for the audience
+func parseLinkToken(signedData, audience string, publicKey *rsa.PublicKey) (*MagicLinkToken, error) {
+	var claims MagicLinkToken
+
+	token, err := jwt.ParseWithClaims(signedData, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signed data: %v", err)
+	}
+
+	if !token.Valid || !claims.VerifyAudience(audience, true) || claims.Id == "" || claims.Email == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	return &claims, nil
+}
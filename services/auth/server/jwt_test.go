@@ -3,12 +3,13 @@ package server
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
 	"testing"
 	"time"
 )
 
 func Test_generateAuthToken(t *testing.T) {
-	phoneNumber := "someNumber"
+	user := &store.User{ID: "someID", PhoneNumber: "someNumber", TokenVersion: 2}
 
 	key, errGen := rsa.GenerateKey(rand.Reader, 2048)
 	if errGen != nil {
@@ -16,7 +17,7 @@ func Test_generateAuthToken(t *testing.T) {
 		return
 	}
 
-	signedData, err := generateAuthToken(phoneNumber, key)
+	signedData, err := generateAuthToken(user, key)
 	if err != nil {
 		t.Errorf("generateAuthToken() error = %v", err)
 		return
@@ -28,8 +29,8 @@ func Test_generateAuthToken(t *testing.T) {
 		return
 	}
 
-	if parsed.PhoneNumber != phoneNumber {
-		t.Errorf("parsed.PhoneNumber got = %v, want %v", parsed.PhoneNumber, phoneNumber)
+	if parsed.PhoneNumber != user.PhoneNumber || parsed.Subject != user.ID || parsed.TokenVersion != user.TokenVersion {
+		t.Errorf("parseAuthToken() got = %+v, want phone number, user id and token version of %+v", parsed, user)
 		return
 	}
 
@@ -40,14 +41,14 @@ func Test_generateAuthToken(t *testing.T) {
 }
 
 func Test_generateAuthTokenWithDifferentKey(t *testing.T) {
-	phoneNumber := "someNumber"
+	user := &store.User{ID: "someID", PhoneNumber: "someNumber"}
 
 	key, errGen := rsa.GenerateKey(rand.Reader, 2048)
 	if errGen != nil {
 		t.Errorf("could not generate private key file: %v", errGen)
 		return
 	}
-	signedData, err := generateAuthToken(phoneNumber, key)
+	signedData, err := generateAuthToken(user, key)
 	if err != nil {
 		t.Errorf("generateAuthToken() error = %v", err)
 		return
@@ -66,3 +67,42 @@ func Test_generateAuthTokenWithDifferentKey(t *testing.T) {
 	}
 
 }
+
+func Test_generateMagicLinkToken(t *testing.T) {
+	email := "user@example.com"
+
+	key, errGen := rsa.GenerateKey(rand.Reader, 2048)
+	if errGen != nil {
+		t.Errorf("could not generate private key file: %v", errGen)
+		return
+	}
+
+	signedData, id, err := generateMagicLinkToken(email, time.Now().Add(time.Minute), key)
+	if err != nil {
+		t.Errorf("generateMagicLinkToken() error = %v", err)
+		return
+	}
+
+	parsed, err := parseMagicLinkToken(signedData, &key.PublicKey)
+	if err != nil {
+		t.Errorf("parseMagicLinkToken() error = %v", err)
+		return
+	}
+	if parsed.Email != email || parsed.Id != id {
+		t.Errorf("parseMagicLinkToken() got = %v, want email %v and id %v", parsed, email, id)
+	}
+
+	// magic link tokens must not be usable as auth tokens and vice versa
+	if _, err := parseAuthToken(signedData, &key.PublicKey); err == nil {
+		t.Error("parseAuthToken() wanted not nil error for magic link token")
+	}
+	authToken, _ := generateAuthToken(&store.User{PhoneNumber: "someNumber"}, key)
+	if _, err := parseMagicLinkToken(authToken, &key.PublicKey); err == nil {
+		t.Error("parseMagicLinkToken() wanted not nil error for auth token")
+	}
+
+	expired, _, _ := generateMagicLinkToken(email, time.Now().Add(-time.Minute), key)
+	if _, err := parseMagicLinkToken(expired, &key.PublicKey); err == nil {
+		t.Error("parseMagicLinkToken() wanted not nil error for expired token")
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GetLoginHistory returns the logins of the user in the auth token from the audit log, newest first.
+// Failed logins are included if the user could be told apart, like wrong otps for its phone number or logins of a suspended user.
+func (s Server) GetLoginHistory(ctx context.Context, request *pb.LoginHistoryRequest) (*pb.LoginHistory, error) {
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := requestPageSize(request.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	var before *store.Cursor
+	if request.PageToken != "" {
+		if before, err = decodePageToken(request.PageToken); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+
+	// one more event is fetched to know if there is a next page
+	filter := store.AuthEventFilter{Types: []string{store.EventLogin}, UserID: user.ID}
+	events, err := s.store.QueryAuthEvents(ctx, filter, before, pageSize+1)
+	if err != nil {
+		return nil, storeError(err, "login history", "could not fetch login history")
+	}
+
+	history := &pb.LoginHistory{}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		last := events[pageSize-1]
+		history.NextPageToken = encodePageToken(store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	for _, event := range events {
+		history.Logins = append(history.Logins, &pb.LoginAttempt{
+			Time:          timestamppb.New(event.CreatedAt),
+			Method:        event.Details["method"],
+			Success:       event.Outcome == store.OutcomeSuccess,
+			FailureReason: event.Details["reason"],
+			Ip:            event.IP,
+			UserAgent:     event.UserAgent,
+			NewDevice:     event.Details["newDevice"] == "true",
+		})
+	}
+	return history, nil
+}
+
+// deviceFingerprint identifies the device of the client by the "device-id" metadata, or by its user agent if it
+// does not send one. It returns an empty string if the client sends neither.
+func deviceFingerprint(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var device string
+	if ids := md.Get("device-id"); len(ids) > 0 && ids[0] != "" {
+		device = "device-id:" + ids[0]
+	} else if _, userAgent := clientInfo(ctx); userAgent != "" {
+		device = "user-agent:" + userAgent
+	}
+	if device == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(device))
+	return hex.EncodeToString(sum[:])
+}
+
+// alertNewDevice saves the device of the client as a device of the user, and sends an sms alert to the user if
+// it logged in from other devices before, but never from this one. It returns true if the alert was sent.
+// Failures are only logged, the login does not fail because of them.
+func (s Server) alertNewDevice(ctx context.Context, user *store.User) bool {
+	fingerprint := deviceFingerprint(ctx)
+	if fingerprint == "" {
+		return false
+	}
+	_, userAgent := clientInfo(ctx)
+
+	// the device is saved and the alert is published together, so that a failed alert is sent at the next login
+	var newDevice bool
+	err := s.store.InTx(ctx, func(tx store.GenericStore) error {
+		var err error
+		newDevice, err = tx.SaveDevice(ctx, &store.Device{UserID: user.ID, Fingerprint: fingerprint, UserAgent: userAgent})
+		if err != nil || !newDevice {
+			return err
+		}
+		return tx.PublishOTP(ctx, store.OTPMessage{
+			PhoneNumber: user.PhoneNumber,
+			Channel:     store.ChannelSMS,
+			Purpose:     store.PurposeNewLogin,
+			Locale:      user.Locale,
+		})
+	})
+	if err != nil {
+		logrus.Errorf("could not check the device of user %s: %v", user.ID, err)
+		return false
+	}
+	if newDevice {
+		logrus.Infof("user %s logged in from a new device", user.ID)
+	}
+	return newDevice
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+)
+
+func TestServer_GetLoginHistory(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	created := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	events := []store.AuthEvent{
+		{ID: "b", Type: store.EventLogin, Outcome: store.OutcomeSuccess, UserID: "someID", IP: "10.0.0.1", UserAgent: "someAgent",
+			Details: map[string]string{"method": "phone", "newDevice": "true"}, CreatedAt: created.Add(time.Second)},
+		{ID: "a", Type: store.EventLogin, Outcome: store.OutcomeFailure, UserID: "someID",
+			Details: map[string]string{"method": "email", "reason": reasonAccountSuspended}, CreatedAt: created},
+	}
+	filter := store.AuthEventFilter{Types: []string{store.EventLogin}, UserID: "someID"}
+	afterB := encodePageToken(store.Cursor{CreatedAt: events[0].CreatedAt, ID: "b"})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		request  *pb.LoginHistoryRequest
+		before   *store.Cursor
+		limit    int
+		found    []store.AuthEvent
+		want     *pb.LoginHistory
+		wantCode codes.Code
+	}{
+		{
+			name:     "should fail without auth token",
+			ctx:      context.Background(),
+			request:  &pb.LoginHistoryRequest{},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with invalid page token",
+			ctx:      ctx,
+			request:  &pb.LoginHistoryRequest{PageToken: "not a token"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:    "should return first page with next page token",
+			ctx:     ctx,
+			request: &pb.LoginHistoryRequest{PageSize: 1},
+			limit:   2,
+			found:   events,
+			want: &pb.LoginHistory{
+				Logins: []*pb.LoginAttempt{{Time: timestamppb.New(events[0].CreatedAt), Method: "phone", Success: true, Ip: "10.0.0.1",
+					UserAgent: "someAgent", NewDevice: true}},
+				NextPageToken: afterB,
+			},
+		},
+		{
+			name:    "should return failed logins with their reason",
+			ctx:     ctx,
+			request: &pb.LoginHistoryRequest{PageSize: 1, PageToken: afterB},
+			before:  &store.Cursor{CreatedAt: events[0].CreatedAt, ID: "b"},
+			limit:   2,
+			found:   events[1:],
+			want: &pb.LoginHistory{
+				Logins: []*pb.LoginAttempt{{Time: timestamppb.New(created), Method: "email", FailureReason: reasonAccountSuspended}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			mockStore := new(store.MockStore)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, "someNumber").Return(&user, nil)
+			mockStore.On("QueryAuthEvents", mock.Anything, filter, tt.before, tt.limit).Return(tt.found, nil)
+
+			s := Server{store: mockStore}
+			got, err := s.GetLoginHistory(tt.ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("GetLoginHistory() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				mockStore.AssertNotCalled(t, "QueryAuthEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			if got.NextPageToken != tt.want.NextPageToken || len(got.Logins) != len(tt.want.Logins) {
+				t.Fatalf("GetLoginHistory() got = %v, want %v", got, tt.want)
+			}
+			for i := range got.Logins {
+				if got.Logins[i].String() != tt.want.Logins[i].String() {
+					t.Errorf("GetLoginHistory() login %d = %v, want %v", i, got.Logins[i], tt.want.Logins[i])
+				}
+			}
+		})
+	}
+}
+
+func TestServer_alertNewDevice(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	phone := testutils.MockUser2.PhoneNumber
+	alert := mock.MatchedBy(func(msg store.OTPMessage) bool {
+		return msg.PhoneNumber == phone && msg.Channel == store.ChannelSMS && msg.Purpose == store.PurposeNewLogin && msg.OTP == ""
+	})
+
+	tests := []struct {
+		name          string
+		md            metadata.MD
+		userAgent     string
+		newDevice     bool
+		wantSave      bool
+		wantAlert     bool
+		wantNewDevice string
+	}{
+		{
+			name: "should not track devices of clients without device id or user agent",
+			md:   metadata.MD{},
+		},
+		{
+			name:     "should not alert on known devices",
+			md:       metadata.Pairs("device-id", "someDevice"),
+			wantSave: true,
+		},
+		{
+			name:          "should alert on new devices",
+			md:            metadata.Pairs("user-agent", "someAgent"),
+			userAgent:     "someAgent",
+			newDevice:     true,
+			wantSave:      true,
+			wantAlert:     true,
+			wantNewDevice: "true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []store.AuthEvent
+			mockStore := recordedEvents(&events)
+			mockStore.On("GetJWTPrivateKey").Return(privateKey)
+			mockStore.On("CheckOTP", mock.Anything, phone, "123456").Return(nil)
+			mockStore.On("GetUser", mock.Anything, phone).Return(&testutils.MockUser2, nil)
+			mockStore.On("InTx", mock.Anything).Return(nil)
+			mockStore.On("SaveDevice", mock.Anything, mock.Anything).Return(tt.newDevice, nil)
+			mockStore.On("PublishOTP", mock.Anything, alert).Return(nil)
+
+			s := Server{store: mockStore}
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			if _, err := s.ValidatePhoneNumberLogin(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phone, Otp: "123456"}); err != nil {
+				t.Fatalf("ValidatePhoneNumberLogin() error = %v", err)
+			}
+
+			if tt.wantSave {
+				mockStore.AssertCalled(t, "SaveDevice", mock.Anything, &store.Device{UserID: "someID2", Fingerprint: deviceFingerprint(ctx), UserAgent: tt.userAgent})
+			} else {
+				mockStore.AssertNotCalled(t, "SaveDevice", mock.Anything, mock.Anything)
+			}
+			if tt.wantAlert {
+				mockStore.AssertCalled(t, "PublishOTP", mock.Anything, alert)
+			} else {
+				mockStore.AssertNotCalled(t, "PublishOTP", mock.Anything, mock.Anything)
+			}
+			login := events[len(events)-1]
+			if login.Type != store.EventLogin || login.Details["newDevice"] != tt.wantNewDevice {
+				t.Errorf("ValidatePhoneNumberLogin() recorded login %+v, want newDevice %q", login, tt.wantNewDevice)
+			}
+		})
+	}
+}
+
+func TestDeviceFingerprint(t *testing.T) {
+	fingerprint := func(kv ...string) string {
+		return deviceFingerprint(metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...)))
+	}
+
+	if got := fingerprint(); got != "" {
+		t.Errorf("deviceFingerprint() without device id and user agent = %q, want empty", got)
+	}
+	byID := fingerprint("device-id", "someDevice", "user-agent", "someAgent")
+	if len(byID) != 64 || byID != fingerprint("device-id", "someDevice", "user-agent", "otherAgent") {
+		t.Errorf("deviceFingerprint() = %q, want a sha256 of the device id which does not change with the user agent", byID)
+	}
+	if byAgent := fingerprint("user-agent", "someAgent"); byAgent == "" || byAgent == byID || byAgent != fingerprint("user-agent", "someAgent") {
+		t.Errorf("deviceFingerprint() of user agent = %q, want a stable fingerprint other than the one of the device id", byAgent)
+	}
+}
@@ -2,7 +2,23 @@ package server
 
 import "github.com/golang-jwt/jwt"
 
+// JWTToken is the auth token, its subject is the user id.
+// Tokens issued before user ids were added only have the phone number.
 type JWTToken struct {
 	jwt.StandardClaims
 	PhoneNumber string `json:"phoneNumber"`
+	// TokenVersion must match the token version of the user, it is increased to revoke tokens
+	TokenVersion int64 `json:"tokenVersion"`
 }
+
+// MagicLinkToken is the token carried in magic links and email verification links sent by email
+type MagicLinkToken struct {
+	jwt.StandardClaims
+	Email string `json:"email"`
+}
+
+// magicLinkAudience distinguishes magic link tokens from auth tokens, as both are signed with the same key
+const magicLinkAudience = "magic_link"
+
+// emailVerificationAudience distinguishes email verification tokens, which carry the user id in the subject
+const emailVerificationAudience = "email_verification"
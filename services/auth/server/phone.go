@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// StartPhoneNumberChange sends an otp to the new phone number of the user in the auth token.
+// If auth.verifyOldPhoneNumber is set, an otp is sent to the current phone number too.
+func (s Server) StartPhoneNumberChange(ctx context.Context, request *pb.StartPhoneNumberChangeRequest) (_ *emptypb.Empty, err error) {
+	event := otpSent("", request.PhoneNumber, store.PurposePhoneChange)
+	defer func() { s.recordEvent(ctx, event, err) }()
+
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	event.UserID = user.ID
+	if !e164Pattern.MatchString(request.PhoneNumber) {
+		return nil, status.Error(codes.InvalidArgument, "phone number must be in E.164 format")
+	}
+	if request.PhoneNumber == user.PhoneNumber {
+		return nil, status.Error(codes.InvalidArgument, "phone number is already the current one")
+	}
+
+	_, err = s.store.GetUser(ctx, request.PhoneNumber)
+	if err == nil {
+		return nil, phoneNumberRegisteredError()
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, storeError(err, "user", "could not fetch user")
+	}
+
+	verifyOld := s.store.GetConfig().Auth.VerifyOldPhoneNumber
+	err = s.allowOTPRequest(ctx, request.PhoneNumber)
+	if err == nil && verifyOld {
+		err = s.allowOTPRequest(ctx, user.PhoneNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the otps are saved and published together, so that the change is started with both or none of them
+	err = s.store.InTx(ctx, func(tx store.GenericStore) error {
+		otp, currentOTP := utils.GetRandomOTP(), ""
+		if verifyOld {
+			currentOTP = utils.GetRandomOTP()
+		}
+		err := tx.SaveOTP(ctx, phoneChangeCode(otp, currentOTP), store.PhoneChangeKey(user.ID, request.PhoneNumber))
+		if err != nil {
+			return storeError(err, "otp", "could not save otp")
+		}
+		err = publishPhoneChangeOTP(ctx, tx, otp, request.PhoneNumber, channelFromPb(request.Channel), request.Locale)
+		if err == nil && verifyOld {
+			err = publishPhoneChangeOTP(ctx, tx, currentOTP, user.PhoneNumber, store.ChannelSMS, request.Locale)
+		}
+		return err
+	})
+	if _, ok := status.FromError(err); !ok {
+		return nil, storeError(err, "otp", "could not send otp")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if verifyOld {
+		s.recordEvent(ctx, otpSent(user.ID, user.PhoneNumber, store.PurposePhoneChange), nil)
+	}
+	return empty, nil
+}
+
+// phoneChangeCode is the otp saved for a phone number change. With auth.verifyOldPhoneNumber the otps of both numbers
+// are saved as one, so that a single attempt checks both and neither can be used up while the other one is wrong.
+func phoneChangeCode(otp, currentOTP string) string {
+	if currentOTP == "" {
+		return otp
+	}
+	return otp + ":" + currentOTP
+}
+
+// publishPhoneChangeOTP publishes an otp to verify the phone number in a phone number change
+func publishPhoneChangeOTP(ctx context.Context, tx store.GenericStore, otp, phoneNumber, channel, locale string) error {
+	err := tx.PublishOTP(ctx, store.OTPMessage{
+		OTP:         otp,
+		PhoneNumber: phoneNumber,
+		Channel:     channel,
+		Purpose:     store.PurposePhoneChange,
+		Locale:      locale,
+	})
+	if err != nil {
+		return storeError(err, "otp", "could not send otp")
+	}
+	return nil
+}
+
+// ConfirmPhoneNumberChange changes the phone number of the user in the auth token if the otps are correct.
+// All auth tokens of the user are revoked and a new one is returned.
+func (s Server) ConfirmPhoneNumberChange(ctx context.Context, request *pb.ConfirmPhoneNumberChangeRequest) (*pb.Token, error) {
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if request.PhoneNumber == "" {
+		return nil, status.Error(codes.InvalidArgument, "phone number is empty")
+	}
+
+	// the otp is checked on its own, as rolling the check back would also roll back the counted attempt
+	currentOTP := ""
+	if s.store.GetConfig().Auth.VerifyOldPhoneNumber {
+		if request.CurrentOtp == "" {
+			return nil, status.Error(codes.InvalidArgument, "otp of the current phone number is empty")
+		}
+		currentOTP = request.CurrentOtp
+	}
+	code := phoneChangeCode(request.Otp, currentOTP)
+	if err := s.checkOTP(ctx, user.ID, store.PhoneChangeKey(user.ID, request.PhoneNumber), code); err != nil {
+		return nil, err
+	}
+
+	// the number is changed and the tokens are revoked in a single update
+	err = s.store.ChangePhoneNumber(ctx, user.ID, request.PhoneNumber)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		return nil, phoneNumberRegisteredError()
+	}
+	if err != nil {
+		return nil, storeError(err, "user", "could not change phone number")
+	}
+	logrus.Infof("phone number of user %s changed", user.ID)
+	s.recordEvent(ctx, tokenRevoked(user.ID, user.ID, "phone_change"), nil)
+
+	user.PhoneNumber = request.PhoneNumber
+	user.TokenVersion++
+	return s.issueToken(ctx, user, store.EventTokenRefreshed)
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_StartPhoneNumberChange(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	newNumber := "+9779841000000"
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		request    *pb.StartPhoneNumberChangeRequest
+		verifyOld  bool
+		tokenVer   int64
+		existing   *store.User
+		wantCode   codes.Code
+		wantReason string
+		wantSentTo []string
+	}{
+		{
+			name:     "should fail without auth token",
+			ctx:      context.Background(),
+			request:  &pb.StartPhoneNumberChangeRequest{PhoneNumber: newNumber},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:       "should fail with revoked auth token",
+			ctx:        ctx,
+			request:    &pb.StartPhoneNumberChangeRequest{PhoneNumber: newNumber},
+			tokenVer:   1,
+			wantCode:   codes.Unauthenticated,
+			wantReason: reasonTokenRevoked,
+		},
+		{
+			name:     "should fail with invalid phone number",
+			ctx:      ctx,
+			request:  &pb.StartPhoneNumberChangeRequest{PhoneNumber: "9841000000"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:       "should fail when the number is registered",
+			ctx:        ctx,
+			request:    &pb.StartPhoneNumberChangeRequest{PhoneNumber: newNumber},
+			existing:   &store.User{ID: "otherID", PhoneNumber: newNumber},
+			wantCode:   codes.AlreadyExists,
+			wantReason: reasonAlreadyExists,
+		},
+		{
+			name:       "should send otp to the new number",
+			ctx:        ctx,
+			request:    &pb.StartPhoneNumberChangeRequest{PhoneNumber: newNumber},
+			wantSentTo: []string{newNumber},
+		},
+		{
+			name:       "should send otps to both numbers",
+			ctx:        ctx,
+			request:    &pb.StartPhoneNumberChangeRequest{PhoneNumber: newNumber},
+			verifyOld:  true,
+			wantSentTo: []string{newNumber, "someNumber"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			user.TokenVersion = tt.tokenVer
+			var config utils.Config
+			config.Auth.VerifyOldPhoneNumber = tt.verifyOld
+
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, "someNumber").Return(&user, nil)
+			if tt.existing != nil {
+				mockStore.On("GetUser", mock.Anything, newNumber).Return(tt.existing, nil)
+			} else {
+				mockStore.On("GetUser", mock.Anything, newNumber).Return(nil, store.ErrNotFound)
+			}
+			mockStore.On("AllowOTPRequest", mock.Anything, mock.Anything).Return(time.Duration(0), nil)
+			mockStore.On("InTx", mock.Anything).Return(nil)
+			mockStore.On("SaveOTP", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("PublishOTP", mock.Anything, mock.Anything).Return(nil)
+
+			s := Server{store: mockStore}
+			_, err := s.StartPhoneNumberChange(tt.ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("StartPhoneNumberChange() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantReason != "" {
+				if info, _ := errorDetails(err); info == nil || info.Reason != tt.wantReason {
+					t.Errorf("StartPhoneNumberChange() error details = %v, want reason %v", info, tt.wantReason)
+				}
+			}
+
+			if len(tt.wantSentTo) == 0 {
+				mockStore.AssertNotCalled(t, "PublishOTP", mock.Anything, mock.Anything)
+				mockStore.AssertNotCalled(t, "SaveOTP", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			// otps of phone number changes must not be usable to login or by other users,
+			// the otps of both numbers are saved as one
+			var otps []string
+			for _, to := range tt.wantSentTo {
+				mockStore.AssertCalled(t, "PublishOTP", mock.Anything, mock.MatchedBy(func(msg store.OTPMessage) bool {
+					if msg.PhoneNumber == to && msg.Purpose == store.PurposePhoneChange {
+						otps = append(otps, msg.OTP)
+						return true
+					}
+					return false
+				}))
+			}
+			mockStore.AssertNumberOfCalls(t, "SaveOTP", 1)
+			mockStore.AssertCalled(t, "SaveOTP", mock.Anything, strings.Join(otps, ":"), store.PhoneChangeKey("someID", newNumber))
+		})
+	}
+}
+
+func TestServer_ConfirmPhoneNumberChange(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	newNumber := "+9779841000000"
+
+	tests := []struct {
+		name       string
+		request    *pb.ConfirmPhoneNumberChangeRequest
+		verifyOld  bool
+		changeErr  error
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{
+			name:     "should fail without phone number",
+			request:  &pb.ConfirmPhoneNumberChangeRequest{Otp: "123456"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:       "should fail with wrong otp",
+			request:    &pb.ConfirmPhoneNumberChangeRequest{PhoneNumber: newNumber, Otp: "000000"},
+			wantCode:   codes.Unauthenticated,
+			wantReason: reasonOTPInvalid,
+		},
+		{
+			name:       "should fail with wrong otp of current number",
+			request:    &pb.ConfirmPhoneNumberChangeRequest{PhoneNumber: newNumber, Otp: "123456", CurrentOtp: "000000"},
+			verifyOld:  true,
+			wantCode:   codes.Unauthenticated,
+			wantReason: reasonOTPInvalid,
+		},
+		{
+			name:      "should fail without otp of current number",
+			request:   &pb.ConfirmPhoneNumberChangeRequest{PhoneNumber: newNumber, Otp: "123456"},
+			verifyOld: true,
+			wantCode:  codes.InvalidArgument,
+		},
+		{
+			name:       "should fail when the number was registered meanwhile",
+			request:    &pb.ConfirmPhoneNumberChangeRequest{PhoneNumber: newNumber, Otp: "123456"},
+			changeErr:  store.ErrAlreadyExists,
+			wantCode:   codes.AlreadyExists,
+			wantReason: reasonAlreadyExists,
+		},
+		{
+			name:    "should change phone number",
+			request: &pb.ConfirmPhoneNumberChangeRequest{PhoneNumber: newNumber, Otp: "123456"},
+		},
+		{
+			name:      "should change phone number with otps of both numbers",
+			request:   &pb.ConfirmPhoneNumberChangeRequest{PhoneNumber: newNumber, Otp: "123456", CurrentOtp: "654321"},
+			verifyOld: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			var config utils.Config
+			config.Auth.VerifyOldPhoneNumber = tt.verifyOld
+
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(config)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetJWTPrivateKey").Return(privateKey)
+			mockStore.On("GetUser", mock.Anything, "someNumber").Return(&user, nil)
+			code := "123456"
+			if tt.verifyOld {
+				code = "123456:654321"
+			}
+			mockStore.On("CheckOTP", mock.Anything, store.PhoneChangeKey("someID", newNumber), code).Return(nil)
+			mockStore.On("CheckOTP", mock.Anything, mock.Anything, mock.Anything).Return(store.ErrOTPInvalid)
+			mockStore.On("ChangePhoneNumber", mock.Anything, "someID", newNumber).Return(tt.changeErr)
+
+			s := Server{store: mockStore}
+			got, err := s.ConfirmPhoneNumberChange(ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("ConfirmPhoneNumberChange() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantReason != "" {
+				if info, _ := errorDetails(err); info == nil || info.Reason != tt.wantReason {
+					t.Errorf("ConfirmPhoneNumberChange() error details = %v, want reason %v", info, tt.wantReason)
+				}
+			}
+			if tt.wantCode != codes.OK {
+				if tt.changeErr == nil {
+					mockStore.AssertNotCalled(t, "ChangePhoneNumber", mock.Anything, mock.Anything, mock.Anything)
+				}
+				return
+			}
+			// both otps are checked in a single attempt
+			mockStore.AssertNumberOfCalls(t, "CheckOTP", 1)
+
+			// the new token must carry the new number and the bumped token version
+			token, err := parseAuthToken(got.Token, &privateKey.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.PhoneNumber != newNumber || token.Subject != "someID" || token.TokenVersion != 1 {
+				t.Errorf("ConfirmPhoneNumberChange() token = %+v", token)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/url"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// limits of profile fields
+const (
+	maxNameLength        = 50
+	maxDisplayNameLength = 100
+	maxEmailLength       = 255
+	maxAvatarURLLength   = 2048
+	maxMetadataEntries   = 20
+	maxMetadataKeyLength = 64
+	maxMetadataValueSize = 512
+)
+
+// localePattern matches BCP 47 language tags like "en", "pt-BR" or "zh-Hant-TW"
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// UpdateProfile changes the fields in the update mask of the profile of the user in the auth token.
+// If the request has a version, the update fails with ABORTED when the profile was changed since that version.
+// A new email becomes the pending email of the user and is sent a link, it becomes the email once ConfirmEmail takes the link.
+func (s Server) UpdateProfile(ctx context.Context, request *pb.UpdateProfileRequest) (*pb.User, error) {
+	user, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if request.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is empty")
+	}
+	if len(request.UpdateMask.GetPaths()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "update mask is empty")
+	}
+
+	if request.User.Version != 0 && request.User.Version != user.Version {
+		return nil, versionMismatchError()
+	}
+
+	pendingEmail := user.PendingEmail
+	for _, path := range request.UpdateMask.Paths {
+		if err := updateProfileField(user, request.User, path); err != nil {
+			return nil, err
+		}
+	}
+	// a new email gets a link which makes it the email of the user once it is opened
+	verifyEmail := user.PendingEmail != "" && user.PendingEmail != pendingEmail
+	if verifyEmail {
+		if err := s.allowOTPRequest(ctx, user.PendingEmail); err != nil {
+			return nil, err
+		}
+	}
+
+	// the link is sent only if the profile is saved
+	err = s.store.InTx(ctx, func(tx store.GenericStore) error {
+		err := tx.UpdateUser(ctx, user)
+		if errors.Is(err, store.ErrVersionMismatch) {
+			return versionMismatchError()
+		}
+		if err != nil {
+			return storeError(err, "user", "could not update user")
+		}
+		if verifyEmail {
+			return s.sendEmailVerification(ctx, tx, user.ID, user.PendingEmail, user.Locale)
+		}
+		return nil
+	})
+	if _, ok := status.FromError(err); !ok {
+		// the transaction itself failed
+		return nil, storeError(err, "user", "could not update user")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return userToPb(user), nil
+}
+
+// updateProfileField validates a field of the request and copies it to the user
+func updateProfileField(user *store.User, request *pb.User, path string) error {
+	switch path {
+	case "name":
+		if utf8.RuneCountInString(request.Name) > maxNameLength {
+			return status.Errorf(codes.InvalidArgument, "name must have up to %d characters", maxNameLength)
+		}
+		user.Name = request.Name
+	case "displayName":
+		if utf8.RuneCountInString(request.DisplayName) > maxDisplayNameLength {
+			return status.Errorf(codes.InvalidArgument, "display name must have up to %d characters", maxDisplayNameLength)
+		}
+		user.DisplayName = request.DisplayName
+	case "email":
+		if err := validateEmail(request.Email); err != nil {
+			return err
+		}
+		// a new email is kept pending until it is confirmed, removing the email or keeping the current one cancels it
+		switch request.Email {
+		case "":
+			user.Email, user.PendingEmail = "", ""
+		case user.Email:
+			user.PendingEmail = ""
+		default:
+			user.PendingEmail = request.Email
+		}
+	case "avatarUrl":
+		if request.AvatarUrl != "" {
+			avatarURL, err := url.Parse(request.AvatarUrl)
+			if err != nil || avatarURL.Scheme != "https" || avatarURL.Host == "" || len(request.AvatarUrl) > maxAvatarURLLength {
+				return status.Error(codes.InvalidArgument, "avatar url must be an https url")
+			}
+		}
+		user.AvatarURL = request.AvatarUrl
+	case "locale":
+		if request.Locale != "" && !localePattern.MatchString(request.Locale) {
+			return status.Error(codes.InvalidArgument, "locale must be a BCP 47 language tag")
+		}
+		user.Locale = request.Locale
+	case "timezone":
+		if request.Timezone != "" {
+			if _, err := time.LoadLocation(request.Timezone); err != nil || request.Timezone == "Local" {
+				return status.Error(codes.InvalidArgument, "timezone must be an IANA time zone")
+			}
+		}
+		user.Timezone = request.Timezone
+	case "metadata":
+		if len(request.Metadata) > maxMetadataEntries {
+			return status.Errorf(codes.InvalidArgument, "metadata must have up to %d entries", maxMetadataEntries)
+		}
+		for k, v := range request.Metadata {
+			if k == "" || len(k) > maxMetadataKeyLength || len(v) > maxMetadataValueSize {
+				return status.Errorf(codes.InvalidArgument, "metadata keys must have 1 to %d bytes and values up to %d bytes",
+					maxMetadataKeyLength, maxMetadataValueSize)
+			}
+		}
+		user.Metadata = request.Metadata
+	default:
+		return status.Errorf(codes.InvalidArgument, "field %q can not be updated", path)
+	}
+	return nil
+}
+
+func versionMismatchError() error {
+	return errorWithDetails(codes.Aborted, reasonVersionMismatch, "profile was changed, get it and try again", 0,
+		map[string]string{"resource": "user"})
+}
+
+func userToPb(user *store.User) *pb.User {
+	return &pb.User{
+		Id:           user.ID,
+		Name:         user.Name,
+		PhoneNumber:  user.PhoneNumber,
+		Email:        user.Email,
+		PendingEmail: user.PendingEmail,
+		Locale:       user.Locale,
+		DisplayName:  user.DisplayName,
+		AvatarUrl:    user.AvatarURL,
+		Timezone:     user.Timezone,
+		CreatedAt:    timestampToPb(user.CreatedAt),
+		UpdatedAt:    timestampToPb(user.UpdatedAt),
+		Metadata:     user.Metadata,
+		Version:      user.Version,
+	}
+}
+
+// timestampToPb converts the time, or returns nil if it is not set
+func timestampToPb(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
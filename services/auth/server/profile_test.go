@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	pb "github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+	"time"
+)
+
+func TestServer_UpdateProfile(t *testing.T) {
+	privateKey := testutils.GetMockPrivateKey1()
+	ctx := testutils.GetContextWithAuthToken(testutils.MockToken1)
+	mask := func(paths ...string) *fieldmaskpb.FieldMask {
+		return &fieldmaskpb.FieldMask{Paths: paths}
+	}
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		request   *pb.UpdateProfileRequest
+		updateErr error
+		want      *pb.User
+		wantCode  codes.Code
+		wantLink  string
+	}{
+		{
+			name:     "should fail without auth token",
+			ctx:      context.Background(),
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Name: "New Name"}, UpdateMask: mask("name")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail without update mask",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Name: "New Name"}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail to update phone number",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{PhoneNumber: "+9779841000000"}, UpdateMask: mask("phoneNumber")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with invalid email",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Email: "Some User <user@example.com>"}, UpdateMask: mask("email")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with http avatar url",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{AvatarUrl: "http://example.com/avatar.png"}, UpdateMask: mask("avatarUrl")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with invalid locale",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Locale: "english please"}, UpdateMask: mask("locale")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with unknown timezone",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Timezone: "Mars/Olympus_Mons"}, UpdateMask: mask("timezone")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "should fail with old version",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Name: "New Name", Version: 1}, UpdateMask: mask("name")},
+			wantCode: codes.Aborted,
+		},
+		{
+			name:      "should fail when changed at the same time",
+			ctx:       ctx,
+			request:   &pb.UpdateProfileRequest{User: &pb.User{Name: "New Name"}, UpdateMask: mask("name")},
+			updateErr: store.ErrVersionMismatch,
+			wantCode:  codes.Aborted,
+		},
+		{
+			name: "should update only the fields in the mask",
+			ctx:  ctx,
+			request: &pb.UpdateProfileRequest{
+				User: &pb.User{
+					Name:        "Ignored",
+					DisplayName: "Someone",
+					AvatarUrl:   "https://example.com/avatar.png",
+					Locale:      "pt-BR",
+					Timezone:    "Asia/Kathmandu",
+					Metadata:    map[string]string{"theme": "dark"},
+					Version:     3,
+				},
+				UpdateMask: mask("displayName", "avatarUrl", "locale", "timezone", "metadata"),
+			},
+			want: &pb.User{
+				Id:           "someID",
+				Name:         "Some User",
+				PhoneNumber:  "someNumber",
+				Email:        "current@example.com",
+				PendingEmail: "old-pending@example.com",
+				DisplayName:  "Someone",
+				AvatarUrl:    "https://example.com/avatar.png",
+				Locale:       "pt-BR",
+				Timezone:     "Asia/Kathmandu",
+				Metadata:     map[string]string{"theme": "dark"},
+				Version:      3,
+			},
+		},
+		{
+			name:     "should keep a new email pending and send a link to it",
+			ctx:      ctx,
+			request:  &pb.UpdateProfileRequest{User: &pb.User{Email: "new@example.com"}, UpdateMask: mask("email")},
+			want:     &pb.User{Id: "someID", Name: "Some User", PhoneNumber: "someNumber", Email: "current@example.com", PendingEmail: "new@example.com", Version: 3},
+			wantLink: "new@example.com",
+		},
+		{
+			name:    "should cancel the pending email when the current one is kept",
+			ctx:     ctx,
+			request: &pb.UpdateProfileRequest{User: &pb.User{Email: "current@example.com"}, UpdateMask: mask("email")},
+			want:    &pb.User{Id: "someID", Name: "Some User", PhoneNumber: "someNumber", Email: "current@example.com", Version: 3},
+		},
+		{
+			name:    "should remove the email without confirmation",
+			ctx:     ctx,
+			request: &pb.UpdateProfileRequest{User: &pb.User{}, UpdateMask: mask("email")},
+			want:    &pb.User{Id: "someID", Name: "Some User", PhoneNumber: "someNumber", Version: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testutils.MockUser1
+			user.Version = 3
+			user.Email, user.PendingEmail = "current@example.com", "old-pending@example.com"
+			mockStore := new(store.MockStore)
+			mockStore.On("RecordAuthEvent", mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("GetJWTPublicKey").Return(&privateKey.PublicKey)
+			mockStore.On("GetUser", mock.Anything, "someNumber").Return(&user, nil)
+			mockStore.On("UpdateUser", mock.Anything, mock.Anything).Return(tt.updateErr)
+			mockStore.On("InTx", mock.Anything).Return(nil)
+			mockStore.On("GetConfig").Return(utils.Config{})
+			mockStore.On("GetJWTPrivateKey").Return(privateKey)
+			mockStore.On("AllowOTPRequest", mock.Anything, mock.Anything).Return(time.Duration(0), nil)
+			mockStore.On("SaveMagicLink", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			mockStore.On("PublishOTP", mock.Anything, mock.Anything).Return(nil)
+
+			s := Server{store: mockStore}
+			got, err := s.UpdateProfile(tt.ctx, tt.request)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("UpdateProfile() error = %v, want code %v", err, tt.wantCode)
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("UpdateProfile() got = %v, want %v", got, tt.want)
+			}
+			if tt.wantCode != codes.OK && tt.updateErr == nil {
+				mockStore.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+			}
+			if tt.wantLink == "" {
+				mockStore.AssertNotCalled(t, "PublishOTP", mock.Anything, mock.Anything)
+				return
+			}
+			mockStore.AssertCalled(t, "SaveMagicLink", mock.Anything, mock.Anything, tt.wantLink, mock.Anything)
+			mockStore.AssertCalled(t, "PublishOTP", mock.Anything, mock.MatchedBy(func(msg store.OTPMessage) bool {
+				return msg.Purpose == store.PurposeEmailVerification && msg.Email == tt.wantLink
+			}))
+		})
+	}
+}
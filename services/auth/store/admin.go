@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ListUsers returns up to limit users matching the filter which come after the cursor, ordered by creation time and id.
+// It starts from the first user if after is nil. Deleted users are not listed.
+func (s Store) ListUsers(ctx context.Context, filter UserFilter, after *Cursor, limit int) ([]User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NULL`
+	var args []interface{}
+	// arg adds an argument and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.Verified != nil {
+		query += ` AND is_verified=` + arg(*filter.Verified)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query += ` AND created_at >= ` + arg(filter.CreatedAfter.UTC())
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query += ` AND created_at < ` + arg(filter.CreatedBefore.UTC())
+	}
+	if filter.PhonePrefix != "" {
+		// prefixes are digits with an optional leading +, which have no special meaning in LIKE patterns
+		query += ` AND phone_number LIKE ` + arg(filter.PhonePrefix+"%")
+	}
+	if after != nil {
+		createdAt := arg(after.CreatedAt.UTC())
+		query += ` AND (created_at > ` + createdAt + ` OR (created_at = ` + createdAt + ` AND id > ` + arg(after.ID) + `))`
+	}
+	query += ` ORDER BY created_at, id LIMIT ` + arg(limit)
+
+	var users []User
+	err := s.lookup(ctx, func(db dbtx) error {
+		users = nil
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, *user)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return users, nil
+}
+
+// SetUserStatus changes the status of the user with the reason of the change.
+// Suspending or locking the user revokes its auth tokens. It returns ErrNotFound if the user does not exist.
+func (s Store) SetUserStatus(ctx context.Context, id, status, reason string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	revoke := 0
+	if status == StatusSuspended || status == StatusLocked {
+		revoke = 1
+	}
+	return s.updateUser(ctx, `UPDATE users SET status=$1, status_reason=$2, status_changed_at=$3, token_version=token_version+$4,
+		version=version+1, updated_at=$3 WHERE id=$5 AND deleted_at IS NULL`, status, reason, now, revoke, id)
+}
+
+// SetUserRole changes the role of the user. It returns ErrNotFound if the user does not exist.
+func (s Store) SetUserRole(ctx context.Context, id, role string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	return s.updateUser(ctx, `UPDATE users SET role=$1, version=version+1, updated_at=$2 WHERE id=$3 AND deleted_at IS NULL`, role, now, id)
+}
+
+// updateUser runs an update of a single user and returns ErrNotFound if it did not change any row
+func (s Store) updateUser(ctx context.Context, query string, args ...interface{}) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if rowsAffected < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
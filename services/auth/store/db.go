@@ -1,63 +1,370 @@
 package store
 
 import (
-	"errors"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"strings"
 	"time"
 )
 
-// CreateUser inserts new user profile into database
-func (s Store) CreateUser(user *User) error {
-	_, err := s.db.Exec(`INSERT INTO users (id,name,phone_number) VALUES ($1,$2,$3)`, user.ID, user.Name, user.PhoneNumber)
-	return err
+// userColumns are the columns read by scanUser
+const userColumns = `id,phone_number,COALESCE(email,''),pending_email,name,is_verified,display_name,avatar_url,locale,timezone,metadata,created_at,updated_at,version,token_version,deleted_at,role,
+	status,status_reason,status_changed_at`
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// GetUser returns user profile based on phone number
-func (s Store) GetUser(phoneNumber string) (*User, error) {
+// scanUser reads a row of userColumns
+func scanUser(row scanner) (*User, error) {
 	var user User
-	err := s.db.QueryRow(`SELECT id,phone_number,name,is_verified FROM users WHERE phone_number= $1 `, phoneNumber).
-		Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.IsVerified)
+	var metadata string
+	var deletedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.PhoneNumber, &user.Email, &user.PendingEmail, &user.Name, &user.IsVerified, &user.DisplayName,
+		&user.AvatarURL, &user.Locale, &user.Timezone, &metadata, &user.CreatedAt, &user.UpdatedAt, &user.Version, &user.TokenVersion,
+		&deletedAt, &user.Role, &user.Status, &user.StatusReason, &user.StatusChangedAt)
 	if err != nil {
 		return nil, err
 	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	if err := json.Unmarshal([]byte(metadata), &user.Metadata); err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
 
-// VerifyUser marks user as verified
-func (s Store) VerifyUser(phoneNumber string) error {
-	_, err := s.db.Exec(`UPDATE users SET is_verified=true WHERE phone_number=$1`, phoneNumber)
-	return err
+// CreateUser inserts new user profile into database, it sets the creation time, the first version, the role and the pending status of the user
+func (s Store) CreateUser(ctx context.Context, user *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	metadata, err := json.Marshal(user.Metadata)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	_, err = s.db.ExecContext(ctx, `INSERT INTO users (id,name,phone_number,email,pending_email,display_name,avatar_url,locale,timezone,metadata,created_at,
+		updated_at,version,status,status_changed_at) VALUES ($1,$2,$3,NULLIF($4,''),$5,$6,$7,$8,$9,$10,$11,$11,1,$12,$11)`, user.ID, user.Name, user.PhoneNumber,
+		user.Email, user.PendingEmail, user.DisplayName, user.AvatarURL, user.Locale, user.Timezone, string(metadata), now, StatusPending)
+	if err != nil {
+		return dbError(err)
+	}
+	user.CreatedAt, user.UpdatedAt, user.Version, user.Role = now, now, 1, RoleUser
+	user.Status, user.StatusChangedAt = StatusPending, now
+	return nil
 }
 
-// SaveOTP saves otp in database
-func (s Store) SaveOTP(otp, phoneNumber string) error {
-	expiry := time.Now().Add(time.Minute * 5)
-	//try to update existing row
-	res, err := s.db.Exec(`UPDATE otp SET value = $1 , expiry=$2 WHERE phone_number= $3`, otp, expiry, phoneNumber)
+// GetUser returns user profile based on phone number, from the read replica if there is one and ctx is not from Primary.
+// Deleted users are not found by any lookup.
+func (s Store) GetUser(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var user *User
+	err := s.lookup(ctx, func(db dbtx) (err error) {
+		user, err = scanUser(db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE phone_number= $1 AND deleted_at IS NULL`, phoneNumber))
+		return err
+	})
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return user, nil
+}
+
+// GetUserByID returns user profile based on its id. It reads the primary database, as it checks the status
+// and token version of authenticated users.
+func (s Store) GetUserByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	user, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id= $1 AND deleted_at IS NULL`, id))
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns user profile based on email. It reads the primary database, as email logins check
+// the status of the user before issuing auth tokens.
+func (s Store) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	user, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email= $1 AND deleted_at IS NULL`, email))
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return user, nil
+}
+
+// UpdateUser saves the profile fields of the user if it was not changed since user.Version.
+// It increases the version and sets the update time of user.
+func (s Store) UpdateUser(ctx context.Context, user *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	metadata, err := json.Marshal(user.Metadata)
 	if err != nil {
 		return err
 	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET name=$1, email=NULLIF($2,''), pending_email=$3, display_name=$4, avatar_url=$5, locale=$6,
+		timezone=$7, metadata=$8, updated_at=$9, version=version+1 WHERE id=$10 AND version=$11 AND deleted_at IS NULL`, user.Name, user.Email,
+		user.PendingEmail, user.DisplayName, user.AvatarURL, user.Locale, user.Timezone, string(metadata), now, user.ID, user.Version)
+	if err != nil {
+		return dbError(err)
+	}
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		return err
+		return dbError(err)
 	}
+	if rowsAffected < 1 {
+		// tell a missing user from a changed one
+		var exists int
+		err := s.db.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL`, user.ID).Scan(&exists)
+		if err != nil {
+			return dbError(err)
+		}
+		return ErrVersionMismatch
+	}
+	user.UpdatedAt = now
+	user.Version++
+	return nil
+}
 
-	// if row does not exist, insert new one
+// ConfirmEmail makes the pending email of the user its email. It returns ErrNotFound if the user is deleted
+// or its pending email is another one, and ErrAlreadyExists if another user has the email.
+func (s Store) ConfirmEmail(ctx context.Context, id, email string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET email=$1, pending_email='', version=version+1, updated_at=$2
+		WHERE id=$3 AND pending_email=$1 AND deleted_at IS NULL`, email, time.Now().UTC().Truncate(time.Microsecond), id)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
 	if rowsAffected < 1 {
-		_, err = s.db.Exec(`INSERT INTO otp  (value,expiry,phone_number) VALUES ($1,$2 ,$3)`, otp, expiry, phoneNumber)
+		return ErrNotFound
 	}
-	return err
+	return nil
 }
 
-// GetOTP returns otp saved in database. It returns error if otp is expired.
-func (s Store) GetOTP(phoneNumber string) (string, error) {
+// ChangePhoneNumber changes the phone number of the user and revokes its auth tokens.
+// It returns ErrAlreadyExists if another user has the phone number.
+func (s Store) ChangePhoneNumber(ctx context.Context, id, phoneNumber string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET phone_number=$1, token_version=token_version+1, version=version+1, updated_at=$2
+		WHERE id=$3 AND deleted_at IS NULL`, phoneNumber, time.Now().UTC().Truncate(time.Microsecond), id)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if rowsAffected < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// VerifyUser marks user as verified, pending users become active
+func (s Store) VerifyUser(ctx context.Context, phoneNumber string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET is_verified=true,
+		status=CASE WHEN status=$1 THEN $2 ELSE status END, status_changed_at=CASE WHEN status=$1 THEN $3 ELSE status_changed_at END
+		WHERE phone_number=$4`, StatusPending, StatusActive, now, phoneNumber)
+	return dbError(err)
+}
+
+// SaveOTP saves otp in database. The otp is saved against a phone number, or an email for email logins.
+func (s Store) SaveOTP(ctx context.Context, otp, phoneNumber string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	expiry := time.Now().Add(s.config.Auth.OTPExpiry)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO otp (value,expiry,phone_number,attempts) VALUES ($1,$2,$3,0)
+		ON CONFLICT (phone_number) DO UPDATE SET value=$1, expiry=$2, attempts=0`, otp, expiry, phoneNumber)
+	return dbError(err)
+}
+
+// CheckOTP counts an attempt and compares the otp saved in database, it is deleted once it is used
+func (s Store) CheckOTP(ctx context.Context, phoneNumber, otp string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var value string
 	var expiry time.Time
-	var otp string
-	err := s.db.QueryRow(`SELECT value,expiry FROM otp WHERE phone_number= $1`, phoneNumber).Scan(&otp, &expiry)
+	var attempts int
+	// the attempt is counted before comparing so that concurrent attempts can not exceed the limit
+	err := s.db.QueryRowContext(ctx, `UPDATE otp SET attempts=attempts+1 WHERE phone_number=$1 RETURNING value,expiry,attempts`, phoneNumber).
+		Scan(&value, &expiry, &attempts)
 	if err != nil {
-		return otp, err
+		return dbError(err)
+	}
+	if err := checkOTP(s.config, value, otp, expiry, attempts); err != nil {
+		return err
+	}
+
+	// only one of concurrent attempts with the right otp can use it
+	res, err := s.db.ExecContext(ctx, `DELETE FROM otp WHERE phone_number=$1 AND value=$2`, phoneNumber, otp)
+	if err != nil {
+		return dbError(err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if deleted < 1 {
+		return ErrOTPInvalid
+	}
+	return nil
+}
+
+// checkOTP checks an attempt to use the saved otp, attempts includes this one
+func checkOTP(config utils.Config, saved, otp string, expiry time.Time, attempts int) error {
+	if config.Auth.OTPMaxAttempts > 0 && attempts > config.Auth.OTPMaxAttempts {
+		return ErrTooManyAttempts
 	}
 	if time.Now().After(expiry) {
-		return otp, errors.New("otp expired")
+		return ErrOTPExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(saved), []byte(otp)) != 1 {
+		return ErrOTPInvalid
+	}
+	return nil
+}
+
+// AllowOTPRequest counts the otp requests of the key in fixed windows of auth.otpRequestWindow
+func (s Store) AllowOTPRequest(ctx context.Context, key string) (time.Duration, error) {
+	return s.AllowRequest(ctx, key, s.config.Auth.OTPRequestLimit, s.config.Auth.OTPRequestWindow)
+}
+
+// AllowRequest counts the requests of the key in fixed windows, a limit or window of 0 allows all requests
+func (s Store) AllowRequest(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	if limit <= 0 || window <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(window)
+	var count int
+	err := s.db.QueryRowContext(ctx, `INSERT INTO otp_requests (key,window_start,count) VALUES ($1,$2,1)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN otp_requests.window_start = $2 THEN otp_requests.count+1 ELSE 1 END,
+			window_start = $2
+		RETURNING count`, key, windowStart).Scan(&count)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	if count > limit {
+		return windowStart.Add(window).Sub(now), nil
+	}
+	return 0, nil
+}
+
+// deliveryColumns are the columns of deliveries read into Delivery
+const deliveryColumns = `message_id,phone_number,channel,provider,provider_sid,status,error_code,created_at,updated_at`
+
+// GetLatestDelivery returns the delivery state of the latest otp message sent to the phone number
+func (s Store) GetLatestDelivery(ctx context.Context, phoneNumber string) (*Delivery, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var delivery Delivery
+	err := s.db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM deliveries
+		WHERE phone_number= $1 ORDER BY created_at DESC LIMIT 1`, phoneNumber).
+		Scan(&delivery.MessageID, &delivery.PhoneNumber, &delivery.Channel, &delivery.Provider, &delivery.ProviderSID, &delivery.Status, &delivery.ErrorCode, &delivery.CreatedAt, &delivery.UpdatedAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &delivery, nil
+}
+
+// SaveMagicLink saves the id of a magic link token so that it can be used only once
+func (s Store) SaveMagicLink(ctx context.Context, id, email string, expiry time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO magic_links (id,email,expiry) VALUES ($1,$2,$3)`, id, email, expiry)
+	return dbError(err)
+}
+
+// UseMagicLink marks the magic link as used. It returns ErrMagicLinkInvalid if it is expired or already used.
+func (s Store) UseMagicLink(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `UPDATE magic_links SET used_at=$1 WHERE id=$2 AND used_at IS NULL AND expiry > $1`, now, id)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if rowsAffected < 1 {
+		return ErrMagicLinkInvalid
+	}
+	return nil
+}
+
+// ListSenders returns all sms senders
+func (s Store) ListSenders(ctx context.Context) ([]Sender, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT address,kind,countries,rate_per_second,enabled FROM senders ORDER BY address`)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	senders := []Sender{}
+	for rows.Next() {
+		var sender Sender
+		var countries string
+		err = rows.Scan(&sender.Address, &sender.Kind, &countries, &sender.RatePerSecond, &sender.Enabled)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		if countries != "" {
+			sender.Countries = strings.Split(countries, ",")
+		}
+		senders = append(senders, sender)
+	}
+	return senders, dbError(rows.Err())
+}
+
+// SaveSender inserts the sender or updates the sender with the same address
+func (s Store) SaveSender(ctx context.Context, sender *Sender) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO senders (address,kind,countries,rate_per_second,enabled,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$6,$6)
+		ON CONFLICT (address) DO UPDATE SET kind=$2, countries=$3, rate_per_second=$4, enabled=$5, updated_at=$6`,
+		sender.Address, sender.Kind, strings.Join(sender.Countries, ","), sender.RatePerSecond, sender.Enabled, now)
+	return dbError(err)
+}
+
+// DeleteSender deletes the sender and its assignments to recipients. It returns ErrNotFound if the sender does not exist.
+func (s Store) DeleteSender(ctx context.Context, address string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM senders WHERE address=$1`, address)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
 	}
-	return otp, nil
+	return nil
 }
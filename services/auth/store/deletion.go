@@ -0,0 +1,342 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// PhoneChangeKey is the key of otps sent to change the phone number of a user, so that they can not be used to login
+// and only the user who started the change can confirm it
+func PhoneChangeKey(userID, phoneNumber string) string {
+	return "change:" + userID + ":" + phoneNumber
+}
+
+// RestoreKey is the key of otps sent to restore the deleted account of a phone number, so that they can not be used to login
+func RestoreKey(phoneNumber string) string {
+	return "restore:" + phoneNumber
+}
+
+// deletedPhoneNumber is the phone number a deleted user has until it is restored or purged,
+// so that its own number can be registered again
+func deletedPhoneNumber(id string) string {
+	return "deleted:" + id
+}
+
+// DeleteUser marks the account of the user as deleted and revokes its auth tokens.
+// The phone number and email of the user are freed and kept aside, so that they can be registered again.
+// It returns ErrNotFound if the user does not exist or is already deleted.
+func (s Store) DeleteUser(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET deleted_at=$1, status=$2, status_changed_at=$1, token_version=token_version+1,
+		version=version+1, updated_at=$1, deleted_phone_number=phone_number, deleted_email=COALESCE(email,''), deleted_status=status,
+		phone_number=$3, email=NULL WHERE id=$4 AND deleted_at IS NULL`, now, StatusDeleted, deletedPhoneNumber(id), id)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if rowsAffected < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deletedUserColumns are userColumns with the status a deleted user had before its deletion instead of its status
+var deletedUserColumns = strings.Replace(userColumns, "status,status_reason", "deleted_status,status_reason", 1)
+
+// GetDeletedUser returns the user which deleted its account last among those which had the phone number,
+// with its phone number and the status it had before the deletion. It returns ErrNotFound if there is none.
+func (s Store) GetDeletedUser(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	user, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+deletedUserColumns+` FROM users WHERE deleted_phone_number=$1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC LIMIT 1`, phoneNumber))
+	if err != nil {
+		return nil, dbError(err)
+	}
+	user.PhoneNumber = phoneNumber
+	return user, nil
+}
+
+// RestoreUser cancels the deletion of the account of the user, which gets back the status it had before the deletion
+// with its phone number verified, as accounts are restored with an otp sent to it. Pending users become active. Its email is restored too unless another user registered it meanwhile.
+// It returns ErrNotFound if the user is not deleted and ErrAlreadyExists if its phone number was registered meanwhile.
+func (s Store) RestoreUser(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET phone_number=deleted_phone_number, is_verified=true,
+		email=CASE WHEN deleted_email='' OR EXISTS (SELECT 1 FROM users other WHERE other.email=users.deleted_email) THEN NULL ELSE deleted_email END,
+		status=CASE WHEN deleted_status IN ('',$1) THEN $2 ELSE deleted_status END, status_changed_at=$3,
+		deleted_phone_number='', deleted_email='', deleted_status='', deleted_at=NULL, version=version+1, updated_at=$3
+		WHERE id=$4 AND deleted_at IS NOT NULL`, StatusPending, StatusActive, now, id)
+	if err != nil {
+		return dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if rowsAffected < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deletedUser is a deleted user waiting to be purged
+type deletedUser struct {
+	id          string
+	phoneNumber string
+	email       string
+	// pendingEmail got email verification links, which are kept against it
+	pendingEmail string
+	// status is the status of the user before it was deleted
+	status    string
+	deletedAt time.Time
+}
+
+// PurgeDeletedUsers removes users deleted before the time together with their otps, deliveries, magic links,
+// sender assignments and unpublished messages, and anonymises their auth events. It returns how many users were removed.
+// Each user is removed in a transaction of its own, so that a failure does not hold back the others.
+func (s Store) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	users, err := s.deletedUsers(ctx, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	for i, user := range users {
+		if err := s.purgeUser(ctx, user); err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
+
+// deletedUsers returns up to 100 users deleted before the time
+func (s Store) deletedUsers(ctx context.Context, deletedBefore time.Time) ([]deletedUser, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT id,deleted_phone_number,deleted_email,pending_email,deleted_at FROM users
+		WHERE deleted_at < $1 ORDER BY deleted_at LIMIT 100`, deletedBefore)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var users []deletedUser
+	for rows.Next() {
+		var user deletedUser
+		if err := rows.Scan(&user.id, &user.phoneNumber, &user.email, &user.pendingEmail, &user.deletedAt); err != nil {
+			return nil, dbError(err)
+		}
+		users = append(users, user)
+	}
+	return users, dbError(rows.Err())
+}
+
+// purgeUser removes the user with its devices and everything kept against its phone number and email.
+// What is kept against a phone number or email which was registered again since the user was deleted is left alone,
+// apart from deliveries and messages from before the deletion.
+func (s Store) purgeUser(ctx context.Context, user deletedUser) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	// empty keys match nothing
+	keys := []interface{}{"", "", ""}
+	for i, key := range []string{user.phoneNumber, user.email, user.pendingEmail} {
+		var others int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id<>$1 AND (phone_number=$2 OR email=$2 OR pending_email=$2)`,
+			user.id, key).Scan(&others)
+		if err != nil {
+			return dbError(err)
+		}
+		if others == 0 {
+			keys[i] = key
+		}
+	}
+	emails := keys[1:]
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM otp WHERE phone_number IN ($1,$2,$3)`, keys},
+		{`DELETE FROM otp WHERE phone_number LIKE $1`, []interface{}{PhoneChangeKey(user.id, "%")}},
+		{`DELETE FROM otp WHERE phone_number=$1 AND $2<>''`, []interface{}{RestoreKey(user.phoneNumber), keys[0]}},
+		{`DELETE FROM otp_requests WHERE key IN ($1,$2,$3)`, keys},
+		{`DELETE FROM deliveries WHERE phone_number=$1 AND created_at <= $2`, []interface{}{user.phoneNumber, user.deletedAt}},
+		{`DELETE FROM sender_assignments WHERE phone_number=$1`, keys[:1]},
+		{`DELETE FROM magic_links WHERE email IN ($1,$2)`, emails},
+		{`DELETE FROM user_devices WHERE user_id=$1`, []interface{}{user.id}},
+		// events of the user, and events of its phone number and email from before the deletion whose user is not known,
+		// like failed otps, keep only what happened and when
+		{`UPDATE auth_events SET ip='', user_agent='',
+			target=CASE WHEN target IN ($1,$2,$3) OR target LIKE $4 THEN '' ELSE target END
+			WHERE user_id=$5 OR (user_id='' AND target<>'' AND target IN ($1,$2,$3) AND created_at <= $6)`,
+			[]interface{}{user.phoneNumber, user.email, user.pendingEmail, PhoneChangeKey(user.id, "%"), user.id, user.deletedAt}},
+		{`DELETE FROM users WHERE id=$1 AND deleted_at IS NOT NULL`, []interface{}{user.id}},
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+			return dbError(err)
+		}
+	}
+	if err := s.purgeOutbox(ctx, tx, user); err != nil {
+		return err
+	}
+	return dbError(tx.Commit())
+}
+
+// purgeOutbox deletes the messages to the user which were saved before it was deleted and are not published yet.
+// Messages are encrypted, so each one is opened to find its recipient.
+func (s Store) purgeOutbox(ctx context.Context, tx *sql.Tx, user deletedUser) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id,attributes FROM outbox WHERE created_at <= $1`, user.deletedAt)
+	if err != nil {
+		return dbError(err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id, sealed string
+		if err := rows.Scan(&id, &sealed); err != nil {
+			rows.Close()
+			return dbError(err)
+		}
+		attributes, err := s.openOutbox(sealed)
+		if err != nil {
+			logrus.Errorf("could not open outbox message %s: %v", id, err)
+			continue
+		}
+		recipient := attributes["PHONE_NUMBER"]
+		if attributes["CHANNEL"] == ChannelEmail {
+			recipient = attributes["EMAIL"]
+		}
+		if recipient != "" && (recipient == user.phoneNumber || recipient == user.email || recipient == user.pendingEmail) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return dbError(err)
+	}
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE id=$1`, id); err != nil {
+			return dbError(err)
+		}
+	}
+	return nil
+}
+
+// ExportUserData returns the profile of the user and everything kept against its phone number and email
+func (s Store) ExportUserData(ctx context.Context, id string) (*UserData, error) {
+	user, err := s.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	data := UserData{User: *user, Deliveries: []Delivery{}, MagicLinks: []MagicLink{}, AuthEvents: []AuthEvent{}}
+
+	// deliveries to earlier owners of the phone number are left out
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deliveryColumns+` FROM deliveries WHERE phone_number=$1 AND created_at >= $2 ORDER BY created_at`,
+		user.PhoneNumber, user.CreatedAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var delivery Delivery
+		err := rows.Scan(&delivery.MessageID, &delivery.PhoneNumber, &delivery.Channel, &delivery.Provider, &delivery.ProviderSID,
+			&delivery.Status, &delivery.ErrorCode, &delivery.CreatedAt, &delivery.UpdatedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		data.Deliveries = append(data.Deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	if user.Email != "" || user.PendingEmail != "" {
+		rows, err := s.db.QueryContext(ctx, `SELECT email,expiry,used_at FROM magic_links WHERE email IN ($1,$2) ORDER BY expiry`,
+			user.Email, user.PendingEmail)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var link MagicLink
+			var usedAt sql.NullTime
+			if err := rows.Scan(&link.Email, &link.Expiry, &usedAt); err != nil {
+				return nil, dbError(err)
+			}
+			if usedAt.Valid {
+				link.UsedAt = &usedAt.Time
+			}
+			data.MagicLinks = append(data.MagicLinks, link)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, dbError(err)
+		}
+	}
+
+	if data.Devices, err = s.userDevices(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `SELECT `+authEventColumns+` FROM auth_events WHERE user_id=$1 ORDER BY created_at, id`, user.ID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	events, err := scanAuthEvents(rows)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	data.AuthEvents = append(data.AuthEvents, events...)
+
+	var assignment SenderAssignment
+	err = s.db.QueryRowContext(ctx, `SELECT sender,assigned_at FROM sender_assignments WHERE phone_number=$1`, user.PhoneNumber).
+		Scan(&assignment.Sender, &assignment.AssignedAt)
+	switch {
+	case err == nil:
+		data.SenderAssignment = &assignment
+	case err != sql.ErrNoRows:
+		return nil, dbError(err)
+	}
+	return &data, nil
+}
+
+// purgeDeletedUsers removes users deleted more than gracePeriod ago every interval
+func purgeDeletedUsers(ctx context.Context, s UserStore, interval, gracePeriod time.Duration) {
+	if interval <= 0 || gracePeriod <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := s.PurgeDeletedUsers(ctx, time.Now().Add(-gracePeriod))
+		if err != nil {
+			logrus.Errorf("could not purge deleted users: %v", err)
+		}
+		if purged > 0 {
+			logrus.Infof("purged %d deleted users", purged)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// SaveDevice records a login from the device and sets its last seen time. It returns true if the user logged in from
+// other devices before, but never from this one.
+func (s Store) SaveDevice(ctx context.Context, device *Device) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	res, err := s.db.ExecContext(ctx, `UPDATE user_devices SET user_agent=$1, last_seen_at=$2 WHERE user_id=$3 AND fingerprint=$4`,
+		device.UserAgent, now, device.UserID, device.Fingerprint)
+	if err != nil {
+		return false, dbError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+	if rowsAffected > 0 {
+		device.LastSeenAt = now
+		return false, nil
+	}
+
+	var known int
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM user_devices WHERE user_id=$1`, device.UserID).Scan(&known)
+	if err != nil {
+		return false, dbError(err)
+	}
+	// a concurrent login from the same device may have added it since it was looked up
+	res, err = s.db.ExecContext(ctx, `INSERT INTO user_devices (user_id,fingerprint,user_agent,created_at,last_seen_at)
+		VALUES ($1,$2,$3,$4,$4) ON CONFLICT (user_id,fingerprint) DO NOTHING`,
+		device.UserID, device.Fingerprint, device.UserAgent, now)
+	if err != nil {
+		return false, dbError(err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, dbError(err)
+	}
+	device.CreatedAt, device.LastSeenAt = now, now
+	return inserted > 0 && known > 0, nil
+}
+
+// userDevices returns the devices of the user, oldest first
+func (s Store) userDevices(ctx context.Context, userID string) ([]Device, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id,fingerprint,user_agent,created_at,last_seen_at FROM user_devices
+		WHERE user_id=$1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+	devices := []Device{}
+	for rows.Next() {
+		var device Device
+		err := rows.Scan(&device.UserID, &device.Fingerprint, &device.UserAgent, &device.CreatedAt, &device.LastSeenAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, dbError(rows.Err())
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// Store errors, the errors of all stores wrap one of them so that callers can check them with errors.Is
+// without depending on the database driver
+var (
+	// ErrNotFound is returned when something does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when something with the same unique value already exists
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrExpired is returned when something can not be used any more
+	ErrExpired = errors.New("expired")
+	// ErrConflict is returned when a change conflicts with a concurrent one, it can be retried
+	ErrConflict = errors.New("conflict")
+)
+
+// dbError translates database errors to store errors, the message of the database error is kept
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "unique_violation":
+			return fmt.Errorf("%w: %v", ErrAlreadyExists, err)
+		case "foreign_key_violation", "serialization_failure", "deadlock_detected", "lock_not_available":
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		}
+		return err
+	}
+	return sqliteError(err)
+}
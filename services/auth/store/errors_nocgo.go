@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package store
+
+// sqliteError returns the error as it is, sqlite needs cgo
+func sqliteError(err error) error {
+	return err
+}
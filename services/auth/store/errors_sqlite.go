@@ -0,0 +1,28 @@
+//go:build cgo
+// +build cgo
+
+package store
+
+import (
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteError translates sqlite errors to store errors
+func sqliteError(err error) error {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return err
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return fmt.Errorf("%w: %v", ErrAlreadyExists, err)
+	case sqlite3.ErrConstraintForeignKey:
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
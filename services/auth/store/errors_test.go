@@ -0,0 +1,46 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"testing"
+)
+
+func TestDBError(t *testing.T) {
+	otherErr := errors.New("connection refused")
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "no rows", err: sql.ErrNoRows, want: ErrNotFound},
+		{name: "wrapped no rows", err: fmt.Errorf("scan: %w", sql.ErrNoRows), want: ErrNotFound},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: ErrAlreadyExists},
+		{name: "foreign key violation", err: &pq.Error{Code: "23503"}, want: ErrConflict},
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, want: ErrConflict},
+		{name: "deadlock", err: &pq.Error{Code: "40P01"}, want: ErrConflict},
+		{name: "other postgres error", err: &pq.Error{Code: "42P01"}},
+		{name: "other error", err: otherErr, want: otherErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dbError(tt.err)
+			if tt.want == nil {
+				for _, storeErr := range []error{ErrNotFound, ErrAlreadyExists, ErrExpired, ErrConflict} {
+					if errors.Is(got, storeErr) {
+						t.Errorf("dbError() = %v, want an untranslated error", got)
+					}
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("dbError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if dbError(nil) != nil {
+		t.Error("dbError(nil) wanted nil")
+	}
+}
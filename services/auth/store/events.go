@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// authEventColumns are the columns read by scanAuthEvent
+const authEventColumns = `id,type,action,outcome,user_id,actor,target,ip,user_agent,details,created_at`
+
+// RecordAuthEvent appends the event to the audit log, it sets the id and time of the event
+func (s Store) RecordAuthEvent(ctx context.Context, event *AuthEvent) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	details := []byte("{}")
+	if len(event.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(event.Details); err != nil {
+			return err
+		}
+	}
+	event.ID = uuid.New().String()
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO auth_events (`+authEventColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
+		event.ID, event.Type, event.Action, event.Outcome, event.UserID, event.Actor, event.Target, event.IP, event.UserAgent,
+		string(details), event.CreatedAt)
+	return dbError(err)
+}
+
+// QueryAuthEvents returns up to limit events matching the filter which come before the cursor, newest first.
+// It starts from the newest event if before is nil.
+func (s Store) QueryAuthEvents(ctx context.Context, filter AuthEventFilter, before *Cursor, limit int) ([]AuthEvent, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + authEventColumns + ` FROM auth_events WHERE 1=1`
+	var args []interface{}
+	// arg adds an argument and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	// in adds the values as arguments and returns a list of their placeholders
+	in := func(values []string) string {
+		placeholders := make([]string, len(values))
+		for i, value := range values {
+			placeholders[i] = arg(value)
+		}
+		return `(` + strings.Join(placeholders, ",") + `)`
+	}
+	if len(filter.Types) > 0 {
+		query += ` AND type IN ` + in(filter.Types)
+	}
+	if len(filter.Outcomes) > 0 {
+		query += ` AND outcome IN ` + in(filter.Outcomes)
+	}
+	if filter.UserID != "" {
+		query += ` AND user_id=` + arg(filter.UserID)
+	}
+	if filter.Actor != "" {
+		query += ` AND actor=` + arg(filter.Actor)
+	}
+	if !filter.Since.IsZero() {
+		query += ` AND created_at >= ` + arg(filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		query += ` AND created_at < ` + arg(filter.Until.UTC())
+	}
+	if before != nil {
+		createdAt := arg(before.CreatedAt.UTC())
+		query += ` AND (created_at < ` + createdAt + ` OR (created_at = ` + createdAt + ` AND id < ` + arg(before.ID) + `))`
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ` + arg(limit)
+
+	var events []AuthEvent
+	err := s.lookup(ctx, func(db dbtx) error {
+		events = nil
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		events, err = scanAuthEvents(rows)
+		return err
+	})
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return events, nil
+}
+
+// scanAuthEvents reads the events of rows which select authEventColumns
+func scanAuthEvents(rows *sql.Rows) ([]AuthEvent, error) {
+	var events []AuthEvent
+	for rows.Next() {
+		var event AuthEvent
+		var details string
+		err := rows.Scan(&event.ID, &event.Type, &event.Action, &event.Outcome, &event.UserID, &event.Actor, &event.Target,
+			&event.IP, &event.UserAgent, &details, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(details), &event.Details); err != nil {
+			return nil, err
+		}
+		if len(event.Details) == 0 {
+			event.Details = nil
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
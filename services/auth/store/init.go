@@ -7,71 +7,257 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"database/sql"
-	"fmt"
 	"github.com/bhrg3se/flahmingo-homework/utils"
-	_ "github.com/lib/pq"
+	"github.com/bhrg3se/flahmingo-homework/utils/migrations"
+	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/api/option"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"time"
 )
 
+// database drivers selectable in config
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// otp stores selectable in config, otps are kept with everything else by default
+const (
+	OTPStoreDatabase = "database"
+	OTPStoreRedis    = "redis"
+)
+
+// GenericStore is everything the auth server needs from its dependencies.
+// Errors of all stores wrap ErrNotFound, ErrAlreadyExists, ErrExpired or ErrConflict when they are caused by the data,
+// any other error is a failure of the store itself.
 type GenericStore interface {
+	// InTx runs f with a store whose changes are committed together if f returns nil and are discarded otherwise.
+	// Otp messages published in f are sent only once the changes are committed.
+	InTx(ctx context.Context, f func(tx GenericStore) error) error
+	UserStore
+	OTPStore
+	SenderStore
+	AdminStore
+	AuditStore
+	KeyStore
 	GetConfig() utils.Config
-	CreateUser(user *User) error
-	GetUser(phoneNumber string) (*User, error)
-	PublishOTP(ctx context.Context, otp, phoneNumber string)
-	SaveOTP(otp, phoneNumber string) error
-	GetOTP(phoneNumber string) (string, error)
-	VerifyUser(phoneNumber string) error
+}
+
+// UserStore keeps user profiles
+type UserStore interface {
+	CreateUser(ctx context.Context, user *User) error
+	GetUser(ctx context.Context, phoneNumber string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	VerifyUser(ctx context.Context, phoneNumber string) error
+	// UpdateUser saves the profile of the user, it returns ErrVersionMismatch if it was changed since user.Version
+	UpdateUser(ctx context.Context, user *User) error
+	// ConfirmEmail makes the pending email of the user its email, if the pending email is still the given one
+	ConfirmEmail(ctx context.Context, id, email string) error
+	// ChangePhoneNumber changes the phone number of the user and revokes its auth tokens by increasing its token version
+	ChangePhoneNumber(ctx context.Context, id, phoneNumber string) error
+	// DeleteUser marks the account of the user as deleted and revokes its auth tokens, lookups do not find it anymore.
+	// Its phone number and email can be registered again.
+	DeleteUser(ctx context.Context, id string) error
+	// GetDeletedUser returns the user which deleted its account last among those which had the phone number,
+	// with the status it had before the deletion
+	GetDeletedUser(ctx context.Context, phoneNumber string) (*User, error)
+	// RestoreUser cancels the deletion of the account of the user during the grace period, giving it back its status
+	RestoreUser(ctx context.Context, id string) error
+	// PurgeDeletedUsers removes users deleted before the time with all their data and returns how many were removed
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error)
+	// ExportUserData returns everything kept about the user
+	ExportUserData(ctx context.Context, id string) (*UserData, error)
+	// SaveDevice records a login from the device. It returns true if the user logged in from other devices before,
+	// but never from this one. The first device of a user is not new.
+	SaveDevice(ctx context.Context, device *Device) (bool, error)
+}
+
+// OTPStore keeps otps and magic links and sends them to the otp service
+type OTPStore interface {
+	CodeStore
+	PublishOTP(ctx context.Context, msg OTPMessage) error
+	GetLatestDelivery(ctx context.Context, phoneNumber string) (*Delivery, error)
+	SaveMagicLink(ctx context.Context, id, email string, expiry time.Time) error
+	UseMagicLink(ctx context.Context, id string) error
+}
+
+// CodeStore keeps otps and limits how often they are sent and tried.
+// Otps are saved against a phone number, or an email for email logins.
+type CodeStore interface {
+	// SaveOTP replaces the otp of the key and resets its attempts
+	SaveOTP(ctx context.Context, otp, key string) error
+	// CheckOTP counts an attempt and compares the otp, which can only be used once.
+	// It returns ErrOTPInvalid, ErrOTPExpired or ErrTooManyAttempts if the otp can not be used
+	// and ErrNotFound if no otp was saved.
+	CheckOTP(ctx context.Context, key, otp string) error
+	// AllowOTPRequest counts an otp request of the key.
+	// It returns how long to wait if too many otps were requested, or 0 if the otp can be sent.
+	AllowOTPRequest(ctx context.Context, key string) (time.Duration, error)
+	// AllowRequest counts a request of the key in fixed windows, allowing limit requests per window.
+	// It returns how long to wait if too many requests were made, or 0 if the request is allowed.
+	AllowRequest(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error)
+}
+
+// SenderStore keeps the numbers and ids which the otp service sends sms from
+type SenderStore interface {
+	ListSenders(ctx context.Context) ([]Sender, error)
+	SaveSender(ctx context.Context, sender *Sender) error
+	DeleteSender(ctx context.Context, address string) error
+}
+
+// AdminStore manages users through the admin service
+type AdminStore interface {
+	// ListUsers returns up to limit users matching the filter after the cursor, ordered by creation time and id
+	ListUsers(ctx context.Context, filter UserFilter, after *Cursor, limit int) ([]User, error)
+	// SetUserStatus changes the status of the user, suspending or locking the user revokes its auth tokens
+	SetUserStatus(ctx context.Context, id, status, reason string) error
+	SetUserRole(ctx context.Context, id, role string) error
+}
+
+// AuditStore keeps the audit log of auth events. Events are never removed and only changed when the account of their
+// user is purged, which clears their address, user agent and the phone number or email of the user.
+type AuditStore interface {
+	// RecordAuthEvent appends the event to the audit log, it sets the id and time of the event
+	RecordAuthEvent(ctx context.Context, event *AuthEvent) error
+	// QueryAuthEvents returns up to limit events matching the filter before the cursor, newest first
+	QueryAuthEvents(ctx context.Context, filter AuthEventFilter, before *Cursor, limit int) ([]AuthEvent, error)
+}
+
+// KeyStore keeps the keys used to sign and verify auth tokens
+type KeyStore interface {
 	GetJWTPublicKey() *rsa.PublicKey
 	GetJWTPrivateKey() *rsa.PrivateKey
 }
 
+// Store keeps everything in a postgres or sqlite database
 type Store struct {
-	db     *sql.DB
+	jwtKeys
+	// db runs the queries, it is the transaction inside InTx
+	db     dbtx
+	conn   *sql.DB
+	tx     *storeTx
 	config utils.Config
 	pubsub *pubsub.Client
-	jwtKey struct {
-		public  *rsa.PublicKey
-		private *rsa.PrivateKey
-	}
+
+	// replica is the read replica used by lookups, nil if there is none
+	replica *replica
+}
+
+// dbtx is a database or a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// storeTx collects the messages published in a transaction
+type storeTx struct {
+	outbox []outboxMessage
 }
 
-// NewStore creates a new store with all dependencies like database, pubsub client etc
-func NewStore(config utils.Config) Store {
+// NewStore creates a new store with all dependencies like database, pubsub client etc.
+// The database is selected by database.driver in config, otps are kept in redis if auth.otpStore is redis.
+func NewStore(config utils.Config) GenericStore {
 
 	//initialise pub sub client
-	absPath, err := filepath.Abs("/etc/flahmingo/key.json")
-	if err != nil {
-		logrus.Errorf("google key not found: %v", err)
+	psClient := newPubSubClient(config)
+
+	privateKey := initJWTKeys(config.Auth.JWTKeyFile)
+
+	var s GenericStore
+	if config.Database.Driver == DriverMemory {
+		logrus.Warn("using in-memory store, all data is lost on restart")
+		s = NewMemoryStore(config, psClient, privateKey)
+	} else {
+		s = newDatabaseStore(config, psClient, privateKey)
 	}
-	opt := option.WithCredentialsFile(absPath)
-	psClient, err := pubsub.NewClient(context.Background(), config.GoogleCloud.ProjectID, opt)
+	go purgeDeletedUsers(context.Background(), s, config.Auth.PurgeInterval, config.Auth.DeletionGracePeriod)
+
+	if config.Auth.OTPStore == OTPStoreRedis {
+		client := redis.NewClient(&redis.Options{
+			Addr:     config.Redis.Address,
+			Password: config.Redis.Password,
+			DB:       config.Redis.DB,
+		})
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			logrus.Fatalf("could not connect to redis: %v", err)
+		}
+		s = WithCodeStore(s, NewRedisCodeStore(config, client))
+	}
+	return s
+}
+
+// newDatabaseStore connects to the postgres or sqlite database and migrates it if enabled
+func newDatabaseStore(config utils.Config, psClient *pubsub.Client, privateKey *rsa.PrivateKey) Store {
+	//create database connection
+	db, dialect, err := OpenDB(config)
 	if err != nil {
 		panic(err)
 	}
+	if config.Database.AutoMigrate {
+		// the database is connected in the background, migrations have to wait for it
+		if err := utils.WaitForDB(context.Background(), db, "database"); err != nil {
+			logrus.Fatalf("could not connect to database: %v", err)
+		}
+		applied, err := migrate(db, dialect)
+		if err != nil {
+			logrus.Fatalf("could not migrate database: %v", err)
+		}
+		logrus.Infof("applied %d migrations", applied)
+	}
 
-	privateKey := initJWTKeys()
+	s := New(config, db, psClient, privateKey)
+	if dialect == migrations.Postgres {
+		if replicaDB := utils.CreateReplicaPool(config); replicaDB != nil {
+			s.replica = newReplica(replicaDB)
+			go s.replica.monitor(context.Background(), config.Database.HealthCheckInterval)
+		}
+	}
+	go s.relayOutbox(context.Background(), config.Auth.OutboxInterval)
+	return s
+}
 
-	//create database connection
-	db := createDBPool(config)
+// New creates a store from already initialised dependencies
+func New(config utils.Config, db *sql.DB, psClient *pubsub.Client, privateKey *rsa.PrivateKey) Store {
 	return Store{
-		db:     db,
-		config: config,
-		jwtKey: struct {
-			public  *rsa.PublicKey
-			private *rsa.PrivateKey
-		}{public: &privateKey.PublicKey, private: privateKey},
-		pubsub: psClient,
+		jwtKeys: newJWTKeys(privateKey),
+		db:      db,
+		conn:    db,
+		config:  config,
+		pubsub:  psClient,
+	}
+}
+
+// newPubSubClient creates the pubsub client which publishes otp messages. If pubsub is not configured, it returns nil
+// when googleCloud.logOTPs is set for development, otp messages are logged in plain text instead of being sent then.
+func newPubSubClient(config utils.Config) *pubsub.Client {
+	if config.GoogleCloud.ProjectID == "" && !utils.UsingPubSubEmulator(config) {
+		if !config.GoogleCloud.LogOTPs {
+			panic("pubsub is not configured: set googleCloud.projectID or googleCloud.emulatorHost, or googleCloud.logOTPs for development")
+		}
+		logrus.Warn("pubsub is not configured, otp messages are logged in plain text instead of being sent")
+		return nil
 	}
+
+	psClient, err := utils.NewPubSubClient(context.Background(), config)
+	if err != nil {
+		panic(err)
+	}
+	if utils.UsingPubSubEmulator(config) {
+		_, err = utils.CreateVerificationTopic(context.Background(), psClient)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return psClient
 }
 
-func initJWTKeys() *rsa.PrivateKey {
+func initJWTKeys(path string) *rsa.PrivateKey {
 
-	f, err := os.Open("/etc/flahmingo/jwt.key")
+	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			key, errGen := rsa.GenerateKey(rand.Reader, 2048)
@@ -80,7 +266,7 @@ func initJWTKeys() *rsa.PrivateKey {
 			}
 			keyBytes := x509.MarshalPKCS1PrivateKey(key)
 
-			err = ioutil.WriteFile("/etc/flahmingo/jwt.key", keyBytes, os.ModeType)
+			err = ioutil.WriteFile(path, keyBytes, os.ModeType)
 			if err != nil {
 				logrus.Fatalf("could not write private key file: %v", err)
 			}
@@ -101,71 +287,100 @@ func initJWTKeys() *rsa.PrivateKey {
 	return privateKey
 }
 
-// createDBPool creates the connection to postgres database
-func createDBPool(config utils.Config) *sql.DB {
-	var str string
-
-	if config.Database.SSL {
-
-		caCert, _ := filepath.Abs(config.Database.CaCertPath)
-		userCert, _ := filepath.Abs(config.Database.UserCertPath)
-		userKey, _ := filepath.Abs(config.Database.UserKeyPath)
-
-		str = fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=verify-full&sslrootcert=%s&sslcert=%s&sslkey=%s",
-			config.Database.User,
-			config.Database.Password,
-			config.Database.Host,
-			config.Database.Port,
-			config.Database.Name,
-			caCert,
-			userCert,
-			userKey,
-		)
+// GetConfig returns config
+func (s Store) GetConfig() utils.Config {
+	return s.config
+}
 
-	} else {
-		str = fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable",
-			config.Database.User,
-			config.Database.Password,
-			config.Database.Host,
-			config.Database.Port,
-			config.Database.Name,
-		)
+// InTx runs f in a database transaction and publishes the otp messages of f once it is committed.
+// Calls of InTx inside f run in the same transaction.
+func (s Store) InTx(ctx context.Context, f func(tx GenericStore) error) error {
+	if s.tx != nil {
+		return f(s)
 	}
 
-	db, err := sql.Open("postgres", str)
+	sqlTx, err := s.conn.BeginTx(ctx, nil)
 	if err != nil {
-		panic(err.Error())
+		return dbError(err)
 	}
+	txStore := s
+	txStore.db = sqlTx
+	txStore.tx = &storeTx{}
 
-	//Check if the connection is successful by establishing a connection.
-	//Retry upto 10 times if connection is not successful
-	for retryCount := 0; retryCount < 10; retryCount++ {
-		err = db.Ping()
-		if err == nil {
-			logrus.Info("database connection successful")
-			return db
-		}
-
-		logrus.Error(err)
-		logrus.Info("could not connect to database: retrying...")
-		time.Sleep(time.Second)
+	if err := f(txStore); err != nil {
+		sqlTx.Rollback()
+		return err
 	}
+	if err := sqlTx.Commit(); err != nil {
+		return dbError(err)
+	}
+	go s.publishOutbox(txStore.tx.outbox)
+	return nil
+}
 
-	panic("could not connect to database")
+// withTimeout limits a database query to database.queryTimeout, so that a slow database does not hold requests forever
+func (s Store) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, s.config.Database.QueryTimeout)
+}
 
+// jwtKeys are the keys used to sign and verify auth tokens
+type jwtKeys struct {
+	public  *rsa.PublicKey
+	private *rsa.PrivateKey
 }
 
-// GetConfig returns config
-func (s Store) GetConfig() utils.Config {
-	return s.config
+func newJWTKeys(privateKey *rsa.PrivateKey) jwtKeys {
+	return jwtKeys{public: &privateKey.PublicKey, private: privateKey}
 }
 
 // GetJWTPrivateKey gets the private key used for generating JWT tokens
-func (s Store) GetJWTPrivateKey() *rsa.PrivateKey {
-	return s.jwtKey.private
+func (k jwtKeys) GetJWTPrivateKey() *rsa.PrivateKey {
+	return k.private
 }
 
 // GetJWTPublicKey gets the private key used to verify JWT tokens
-func (s Store) GetJWTPublicKey() *rsa.PublicKey {
-	return s.jwtKey.public
+func (k jwtKeys) GetJWTPublicKey() *rsa.PublicKey {
+	return k.public
+}
+
+// WithCodeStore returns the store with otps kept in codes instead
+func WithCodeStore(s GenericStore, codes CodeStore) GenericStore {
+	return codeStoreOverride{GenericStore: s, codes: codes}
+}
+
+type codeStoreOverride struct {
+	GenericStore
+	codes CodeStore
+}
+
+// InTx runs f in a transaction of the store, otps are saved in codes right away.
+// An otp saved in a discarded transaction is harmless, it is replaced by the next one.
+func (s codeStoreOverride) InTx(ctx context.Context, f func(tx GenericStore) error) error {
+	return s.GenericStore.InTx(ctx, func(tx GenericStore) error {
+		return f(codeStoreOverride{GenericStore: tx, codes: s.codes})
+	})
+}
+
+func (s codeStoreOverride) SaveOTP(ctx context.Context, otp, key string) error {
+	return s.codes.SaveOTP(ctx, otp, key)
+}
+
+func (s codeStoreOverride) CheckOTP(ctx context.Context, key, otp string) error {
+	return s.codes.CheckOTP(ctx, key, otp)
+}
+
+func (s codeStoreOverride) AllowOTPRequest(ctx context.Context, key string) (time.Duration, error) {
+	return s.codes.AllowOTPRequest(ctx, key)
+}
+
+func (s codeStoreOverride) AllowRequest(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error) {
+	return s.codes.AllowRequest(ctx, key, limit, window)
+}
+
+// withTimeout limits ctx to timeout, which is not limited if it is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,914 @@
+package store
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errUserExists = fmt.Errorf("user with the phone number or email %w", ErrAlreadyExists)
+
+// MemoryStore keeps everything in memory, everything is lost when the service stops.
+// It is used to run the auth service without any database.
+// It returns the same errors as the database store.
+type MemoryStore struct {
+	jwtKeys
+	config utils.Config
+	pubsub *pubsub.Client
+
+	// txMu runs transactions one at a time
+	txMu sync.Mutex
+	mu   sync.RWMutex
+	memoryState
+}
+
+// memoryState is everything kept in the memory store
+type memoryState struct {
+	users      map[string]User
+	otps       map[string]memoryOTP
+	deliveries map[string]Delivery
+	magicLinks map[string]memoryMagicLink
+	senders    map[string]Sender
+	requests   map[string]memoryWindow
+	devices    map[memoryDeviceKey]Device
+	// deletedUsers are the phone numbers and emails which deleted users had, by user id
+	deletedUsers map[string]deletedUser
+	// authEvents is the audit log, oldest first
+	authEvents []AuthEvent
+}
+
+// copyState copies the state so that transactions can work on a copy of their own
+func (m *MemoryStore) copyState() memoryState {
+	state := memoryState{
+		users:        make(map[string]User, len(m.users)),
+		otps:         make(map[string]memoryOTP, len(m.otps)),
+		deliveries:   make(map[string]Delivery, len(m.deliveries)),
+		magicLinks:   make(map[string]memoryMagicLink, len(m.magicLinks)),
+		senders:      make(map[string]Sender, len(m.senders)),
+		requests:     make(map[string]memoryWindow, len(m.requests)),
+		devices:      make(map[memoryDeviceKey]Device, len(m.devices)),
+		deletedUsers: make(map[string]deletedUser, len(m.deletedUsers)),
+		// events are only appended, the slice can be shared
+		authEvents: m.authEvents[:len(m.authEvents):len(m.authEvents)],
+	}
+	for k, v := range m.users {
+		state.users[k] = v
+	}
+	for k, v := range m.otps {
+		state.otps[k] = v
+	}
+	for k, v := range m.deliveries {
+		state.deliveries[k] = v
+	}
+	for k, v := range m.magicLinks {
+		state.magicLinks[k] = v
+	}
+	for k, v := range m.senders {
+		state.senders[k] = v
+	}
+	for k, v := range m.requests {
+		state.requests[k] = v
+	}
+	for k, v := range m.devices {
+		state.devices[k] = v
+	}
+	for k, v := range m.deletedUsers {
+		state.deletedUsers[k] = v
+	}
+	return state
+}
+
+type memoryOTP struct {
+	value    string
+	expiry   time.Time
+	attempts int
+}
+
+type memoryWindow struct {
+	start time.Time
+	count int
+}
+
+type memoryDeviceKey struct {
+	userID      string
+	fingerprint string
+}
+
+type memoryMagicLink struct {
+	email  string
+	expiry time.Time
+	usedAt *time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store. Otp messages are published on pubsub if psClient is not nil.
+func NewMemoryStore(config utils.Config, psClient *pubsub.Client, privateKey *rsa.PrivateKey) *MemoryStore {
+	return &MemoryStore{
+		jwtKeys: newJWTKeys(privateKey),
+		config:  config,
+		pubsub:  psClient,
+		memoryState: memoryState{
+			users:        map[string]User{},
+			otps:         map[string]memoryOTP{},
+			deliveries:   map[string]Delivery{},
+			magicLinks:   map[string]memoryMagicLink{},
+			senders:      map[string]Sender{},
+			requests:     map[string]memoryWindow{},
+			devices:      map[memoryDeviceKey]Device{},
+			deletedUsers: map[string]deletedUser{},
+		},
+	}
+}
+
+// GetConfig returns config
+func (m *MemoryStore) GetConfig() utils.Config {
+	return m.config
+}
+
+// CreateUser saves new user profile. Phone numbers and emails must be unique.
+func (m *MemoryStore) CreateUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.PhoneNumber == user.PhoneNumber || (user.Email != "" && u.Email == user.Email) {
+			return errUserExists
+		}
+	}
+	now := time.Now().UTC()
+	user.CreatedAt, user.UpdatedAt, user.Version, user.Role = now, now, 1, RoleUser
+	user.Status, user.StatusChangedAt = StatusPending, now
+	m.users[user.PhoneNumber] = copyUser(*user)
+	return nil
+}
+
+// copyUser copies the metadata too, so that users returned by the store can be changed freely
+func copyUser(user User) User {
+	if user.Metadata != nil {
+		metadata := make(map[string]string, len(user.Metadata))
+		for k, v := range user.Metadata {
+			metadata[k] = v
+		}
+		user.Metadata = metadata
+	}
+	return user
+}
+
+// UpdateUser saves the profile fields of the user if it was not changed since user.Version
+func (m *MemoryStore) UpdateUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var saved *User
+	for _, u := range m.users {
+		if u.ID == user.ID && u.DeletedAt == nil {
+			u := u
+			saved = &u
+		} else if user.Email != "" && u.Email == user.Email {
+			return errUserExists
+		}
+	}
+	if saved == nil {
+		return ErrNotFound
+	}
+	if saved.Version != user.Version {
+		return ErrVersionMismatch
+	}
+
+	saved.Name, saved.Email, saved.PendingEmail = user.Name, user.Email, user.PendingEmail
+	saved.DisplayName, saved.AvatarURL = user.DisplayName, user.AvatarURL
+	saved.Locale, saved.Timezone, saved.Metadata = user.Locale, user.Timezone, user.Metadata
+	saved.UpdatedAt = time.Now().UTC()
+	saved.Version++
+	m.users[saved.PhoneNumber] = copyUser(*saved)
+	user.UpdatedAt, user.Version = saved.UpdatedAt, saved.Version
+	return nil
+}
+
+// GetUser returns user profile based on phone number
+func (m *MemoryStore) GetUser(ctx context.Context, phoneNumber string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[phoneNumber]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	user = copyUser(user)
+	return &user, nil
+}
+
+// GetUserByEmail returns user profile based on email
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if email != "" && user.Email == email && user.DeletedAt == nil {
+			user = copyUser(user)
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetUserByID returns user profile based on its id
+func (m *MemoryStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.ID == id && user.DeletedAt == nil {
+			user = copyUser(user)
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ConfirmEmail makes the pending email of the user its email
+func (m *MemoryStore) ConfirmEmail(ctx context.Context, id, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for phoneNumber, user := range m.users {
+		if user.ID != id || user.DeletedAt != nil {
+			continue
+		}
+		if user.PendingEmail != email {
+			return ErrNotFound
+		}
+		for _, u := range m.users {
+			if u.ID != id && u.Email == email {
+				return errUserExists
+			}
+		}
+		user.Email, user.PendingEmail = email, ""
+		user.UpdatedAt = time.Now().UTC()
+		user.Version++
+		m.users[phoneNumber] = user
+		return nil
+	}
+	return ErrNotFound
+}
+
+// ChangePhoneNumber changes the phone number of the user and revokes its auth tokens
+func (m *MemoryStore) ChangePhoneNumber(ctx context.Context, id, phoneNumber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[phoneNumber]; ok {
+		return errUserExists
+	}
+	for oldPhoneNumber, user := range m.users {
+		if user.ID != id || user.DeletedAt != nil {
+			continue
+		}
+		user.PhoneNumber = phoneNumber
+		user.TokenVersion++
+		user.Version++
+		user.UpdatedAt = time.Now().UTC()
+		delete(m.users, oldPhoneNumber)
+		m.users[phoneNumber] = user
+		return nil
+	}
+	return ErrNotFound
+}
+
+// VerifyUser marks user as verified
+func (m *MemoryStore) VerifyUser(ctx context.Context, phoneNumber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[phoneNumber]; ok {
+		user.IsVerified = true
+		if user.Status == StatusPending {
+			user.Status, user.StatusChangedAt = StatusActive, time.Now().UTC()
+		}
+		m.users[phoneNumber] = user
+	}
+	return nil
+}
+
+// DeleteUser marks the account of the user as deleted and revokes its auth tokens, freeing its phone number and email
+func (m *MemoryStore) DeleteUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for phoneNumber, user := range m.users {
+		if user.ID != id || user.DeletedAt != nil {
+			continue
+		}
+		now := time.Now().UTC()
+		m.deletedUsers[id] = deletedUser{id: id, phoneNumber: phoneNumber, email: user.Email, pendingEmail: user.PendingEmail,
+			status: user.Status, deletedAt: now}
+		user.DeletedAt = &now
+		user.Status, user.StatusChangedAt = StatusDeleted, now
+		user.TokenVersion++
+		user.Version++
+		user.UpdatedAt = now
+		user.PhoneNumber, user.Email = deletedPhoneNumber(id), ""
+		delete(m.users, phoneNumber)
+		m.users[user.PhoneNumber] = user
+		return nil
+	}
+	return ErrNotFound
+}
+
+// GetDeletedUser returns the user which deleted its account last among those which had the phone number,
+// with the status it had before the deletion
+func (m *MemoryStore) GetDeletedUser(ctx context.Context, phoneNumber string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found *User
+	for _, deleted := range m.deletedUsers {
+		if deleted.phoneNumber != phoneNumber {
+			continue
+		}
+		user := m.users[deletedPhoneNumber(deleted.id)]
+		if found == nil || user.DeletedAt.After(*found.DeletedAt) {
+			user = copyUser(user)
+			user.PhoneNumber, user.Status = phoneNumber, deleted.status
+			found = &user
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// RestoreUser cancels the deletion of the account of the user like the database store does
+func (m *MemoryStore) RestoreUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted, ok := m.deletedUsers[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.users[deleted.phoneNumber]; ok {
+		return errUserExists
+	}
+	user := m.users[deletedPhoneNumber(id)]
+	user.PhoneNumber = deleted.phoneNumber
+	user.Email = deleted.email
+	user.IsVerified = true
+	for _, u := range m.users {
+		if deleted.email != "" && u.Email == deleted.email {
+			user.Email = ""
+		}
+	}
+	now := time.Now().UTC()
+	user.DeletedAt = nil
+	user.Status, user.StatusChangedAt = deleted.status, now
+	if user.Status == StatusPending {
+		user.Status = StatusActive
+	}
+	user.Version++
+	user.UpdatedAt = now
+	delete(m.users, deletedPhoneNumber(id))
+	delete(m.deletedUsers, id)
+	m.users[user.PhoneNumber] = user
+	return nil
+}
+
+// PurgeDeletedUsers removes users deleted before the time with their otps, deliveries, magic links and devices,
+// and anonymises their auth events, leaving alone what is kept against phone numbers and emails registered again
+func (m *MemoryStore) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for id, deleted := range m.deletedUsers {
+		if !deleted.deletedAt.Before(deletedBefore) {
+			continue
+		}
+		var keys []string
+		for _, key := range []string{deleted.phoneNumber, deleted.email, deleted.pendingEmail} {
+			if key != "" && !m.registered(id, key) {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			delete(m.otps, key)
+			delete(m.requests, key)
+			for linkID, link := range m.magicLinks {
+				if link.email == key {
+					delete(m.magicLinks, linkID)
+				}
+			}
+		}
+		if !m.registered(id, deleted.phoneNumber) {
+			delete(m.otps, RestoreKey(deleted.phoneNumber))
+		}
+		for key := range m.otps {
+			if strings.HasPrefix(key, PhoneChangeKey(id, "")) {
+				delete(m.otps, key)
+			}
+		}
+		for messageID, delivery := range m.deliveries {
+			if delivery.PhoneNumber == deleted.phoneNumber && !delivery.CreatedAt.After(deleted.deletedAt) {
+				delete(m.deliveries, messageID)
+			}
+		}
+		for key := range m.devices {
+			if key.userID == id {
+				delete(m.devices, key)
+			}
+		}
+
+		// events are shared with saved states, so they are copied before being changed
+		identifiers := map[string]bool{deleted.phoneNumber: true, deleted.email: true, deleted.pendingEmail: true}
+		events := make([]AuthEvent, len(m.authEvents))
+		copy(events, m.authEvents)
+		for i, event := range events {
+			anonymous := event.UserID == "" && event.Target != "" && identifiers[event.Target] && !event.CreatedAt.After(deleted.deletedAt)
+			if event.UserID != id && !anonymous {
+				continue
+			}
+			if identifiers[event.Target] || strings.HasPrefix(event.Target, PhoneChangeKey(id, "")) {
+				event.Target = ""
+			}
+			event.IP, event.UserAgent = "", ""
+			events[i] = event
+		}
+		m.authEvents = events
+
+		delete(m.users, deletedPhoneNumber(id))
+		delete(m.deletedUsers, id)
+		purged++
+	}
+	return purged, nil
+}
+
+// registered tells if a user other than id has the phone number or email
+func (m *MemoryStore) registered(id, key string) bool {
+	for _, user := range m.users {
+		if user.ID != id && (user.PhoneNumber == key || user.Email == key || user.PendingEmail == key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportUserData returns the profile of the user with its deliveries, magic links and devices
+func (m *MemoryStore) ExportUserData(ctx context.Context, id string) (*UserData, error) {
+	user, err := m.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data := UserData{User: *user, Deliveries: []Delivery{}, MagicLinks: []MagicLink{}, Devices: []Device{}, AuthEvents: []AuthEvent{}}
+	for _, delivery := range m.deliveries {
+		if delivery.PhoneNumber == user.PhoneNumber && !delivery.CreatedAt.Before(user.CreatedAt) {
+			data.Deliveries = append(data.Deliveries, delivery)
+		}
+	}
+	sort.Slice(data.Deliveries, func(i, j int) bool { return data.Deliveries[i].CreatedAt.Before(data.Deliveries[j].CreatedAt) })
+	for _, link := range m.magicLinks {
+		if link.email != "" && (link.email == user.Email || link.email == user.PendingEmail) {
+			data.MagicLinks = append(data.MagicLinks, MagicLink{Email: link.email, Expiry: link.expiry, UsedAt: link.usedAt})
+		}
+	}
+	sort.Slice(data.MagicLinks, func(i, j int) bool { return data.MagicLinks[i].Expiry.Before(data.MagicLinks[j].Expiry) })
+	for key, device := range m.devices {
+		if key.userID == user.ID {
+			data.Devices = append(data.Devices, device)
+		}
+	}
+	sort.Slice(data.Devices, func(i, j int) bool { return data.Devices[i].CreatedAt.Before(data.Devices[j].CreatedAt) })
+	for _, event := range m.authEvents {
+		if event.UserID == user.ID {
+			data.AuthEvents = append(data.AuthEvents, copyEvent(event))
+		}
+	}
+	return &data, nil
+}
+
+// SaveDevice records a login from the device like the database store does
+func (m *MemoryStore) SaveDevice(ctx context.Context, device *Device) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	key := memoryDeviceKey{userID: device.UserID, fingerprint: device.Fingerprint}
+	if saved, ok := m.devices[key]; ok {
+		saved.UserAgent, saved.LastSeenAt = device.UserAgent, now
+		m.devices[key] = saved
+		device.LastSeenAt = now
+		return false, nil
+	}
+
+	known := false
+	for k := range m.devices {
+		if k.userID == device.UserID {
+			known = true
+			break
+		}
+	}
+	device.CreatedAt, device.LastSeenAt = now, now
+	m.devices[key] = *device
+	return known, nil
+}
+
+// PublishOTP records the message as queued and publishes it like the database store does
+func (m *MemoryStore) PublishOTP(ctx context.Context, otpMsg OTPMessage) error {
+	go m.publish(m.queue(otpMsg))
+	return nil
+}
+
+// queue records the message as queued and returns its pubsub attributes
+func (m *MemoryStore) queue(otpMsg OTPMessage) map[string]string {
+	messageID := otpMsg.ID
+	if messageID == "" {
+		messageID = uuid.New().String()
+	}
+
+	if otpMsg.Channel != ChannelEmail {
+		now := time.Now()
+		m.mu.Lock()
+		m.deliveries[messageID] = Delivery{
+			MessageID:   messageID,
+			PhoneNumber: otpMsg.PhoneNumber,
+			Channel:     otpMsg.Channel,
+			Status:      DeliveryQueued,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		m.mu.Unlock()
+	}
+	return otpAttributes(messageID, otpMsg)
+}
+
+func (m *MemoryStore) publish(attributes map[string]string) {
+	if err := publish(context.Background(), m.pubsub, m.config, attributes); err != nil {
+		logrus.Errorf("could not publish message %s: %v", attributes["MESSAGE_ID"], err)
+	}
+}
+
+// InTx runs f on a copy of the state of the store and applies the changes f made to the store once f succeeds.
+// If f fails its changes are dropped, while changes made outside of the transaction meanwhile are kept.
+// Transactions run one at a time and do not see changes made outside of them after they started.
+func (m *MemoryStore) InTx(ctx context.Context, f func(tx GenericStore) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.RLock()
+	saved, working := m.copyState(), m.copyState()
+	m.mu.RUnlock()
+
+	tx := &memoryTx{MemoryStore: &MemoryStore{jwtKeys: m.jwtKeys, config: m.config, pubsub: m.pubsub, memoryState: working}}
+	if err := f(tx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.apply(saved, tx.memoryState)
+	m.mu.Unlock()
+	for _, attributes := range tx.outbox {
+		go m.publish(attributes)
+	}
+	return nil
+}
+
+// apply makes the changes from saved to changed, the state before and after a transaction, to the state of the store
+func (m *MemoryStore) apply(saved, changed memoryState) {
+	applyMap(m.users, saved.users, changed.users)
+	applyMap(m.otps, saved.otps, changed.otps)
+	applyMap(m.deliveries, saved.deliveries, changed.deliveries)
+	applyMap(m.magicLinks, saved.magicLinks, changed.magicLinks)
+	applyMap(m.senders, saved.senders, changed.senders)
+	applyMap(m.requests, saved.requests, changed.requests)
+	applyMap(m.devices, saved.devices, changed.devices)
+	applyMap(m.deletedUsers, saved.deletedUsers, changed.deletedUsers)
+
+	// events recorded before the transaction are only changed when users are purged, they are found by id
+	for i, event := range changed.authEvents[:len(saved.authEvents)] {
+		if reflect.DeepEqual(event, saved.authEvents[i]) {
+			continue
+		}
+		for j := range m.authEvents {
+			if m.authEvents[j].ID == event.ID {
+				m.authEvents[j] = event
+			}
+		}
+	}
+	m.authEvents = append(m.authEvents, changed.authEvents[len(saved.authEvents):]...)
+}
+
+// applyMap makes the changes from the map saved to the map changed to the map live. Entries which were not changed
+// are left alone, so that changes made to them outside of the transaction are kept.
+func applyMap(live, saved, changed interface{}) {
+	l, s, c := reflect.ValueOf(live), reflect.ValueOf(saved), reflect.ValueOf(changed)
+	for _, key := range s.MapKeys() {
+		if !c.MapIndex(key).IsValid() {
+			l.SetMapIndex(key, reflect.Value{})
+		}
+	}
+	for iter := c.MapRange(); iter.Next(); {
+		if old := s.MapIndex(iter.Key()); old.IsValid() && reflect.DeepEqual(old.Interface(), iter.Value().Interface()) {
+			continue
+		}
+		l.SetMapIndex(iter.Key(), iter.Value())
+	}
+}
+
+// memoryTx is the store inside InTx, it publishes otp messages once f succeeds
+type memoryTx struct {
+	*MemoryStore
+	outbox []map[string]string
+}
+
+func (tx *memoryTx) InTx(ctx context.Context, f func(tx GenericStore) error) error {
+	return f(tx)
+}
+
+func (tx *memoryTx) PublishOTP(ctx context.Context, otpMsg OTPMessage) error {
+	tx.outbox = append(tx.outbox, tx.queue(otpMsg))
+	return nil
+}
+
+// SaveOTP saves otp against a phone number, or an email for email logins
+func (m *MemoryStore) SaveOTP(ctx context.Context, otp, phoneNumber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.otps[phoneNumber] = memoryOTP{value: otp, expiry: time.Now().Add(m.config.Auth.OTPExpiry)}
+	return nil
+}
+
+// CheckOTP counts an attempt and compares the saved otp, it is deleted once it is used
+func (m *MemoryStore) CheckOTP(ctx context.Context, phoneNumber, otp string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.otps[phoneNumber]
+	if !ok {
+		return ErrNotFound
+	}
+	saved.attempts++
+	m.otps[phoneNumber] = saved
+	if err := checkOTP(m.config, saved.value, otp, saved.expiry, saved.attempts); err != nil {
+		return err
+	}
+	delete(m.otps, phoneNumber)
+	return nil
+}
+
+// AllowOTPRequest counts the otp requests of the key in fixed windows of auth.otpRequestWindow
+func (m *MemoryStore) AllowOTPRequest(ctx context.Context, key string) (time.Duration, error) {
+	return m.AllowRequest(ctx, key, m.config.Auth.OTPRequestLimit, m.config.Auth.OTPRequestWindow)
+}
+
+// AllowRequest counts the requests of the key in fixed windows, a limit or window of 0 allows all requests
+func (m *MemoryStore) AllowRequest(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error) {
+	if limit <= 0 || window <= 0 {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	requests := m.requests[key]
+	if start := now.Truncate(window); !requests.start.Equal(start) {
+		requests = memoryWindow{start: start}
+	}
+	requests.count++
+	m.requests[key] = requests
+	if requests.count > limit {
+		return requests.start.Add(window).Sub(now), nil
+	}
+	return 0, nil
+}
+
+// GetLatestDelivery returns the delivery state of the latest otp message sent to the phone number.
+// Deliveries stay queued as the otp service can not update them.
+func (m *MemoryStore) GetLatestDelivery(ctx context.Context, phoneNumber string) (*Delivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest *Delivery
+	for _, delivery := range m.deliveries {
+		if delivery.PhoneNumber != phoneNumber {
+			continue
+		}
+		if latest == nil || delivery.UpdatedAt.After(latest.UpdatedAt) {
+			d := delivery
+			latest = &d
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return latest, nil
+}
+
+// SaveMagicLink saves the id of a magic link token so that it can be used only once
+func (m *MemoryStore) SaveMagicLink(ctx context.Context, id, email string, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.magicLinks[id] = memoryMagicLink{email: email, expiry: expiry}
+	return nil
+}
+
+// UseMagicLink marks the magic link as used. It returns ErrMagicLinkInvalid if it is expired or already used.
+func (m *MemoryStore) UseMagicLink(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.magicLinks[id]
+	now := time.Now()
+	if !ok || link.usedAt != nil || !now.Before(link.expiry) {
+		return ErrMagicLinkInvalid
+	}
+	link.usedAt = &now
+	m.magicLinks[id] = link
+	return nil
+}
+
+// ListSenders returns all sms senders
+func (m *MemoryStore) ListSenders(ctx context.Context) ([]Sender, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	senders := []Sender{}
+	for _, sender := range m.senders {
+		senders = append(senders, sender)
+	}
+	sort.Slice(senders, func(i, j int) bool { return senders[i].Address < senders[j].Address })
+	return senders, nil
+}
+
+// SaveSender saves the sender or updates the sender with the same address
+func (m *MemoryStore) SaveSender(ctx context.Context, sender *Sender) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.senders[sender.Address] = *sender
+	return nil
+}
+
+// DeleteSender deletes the sender. It returns ErrNotFound if the sender does not exist.
+func (m *MemoryStore) DeleteSender(ctx context.Context, address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.senders[address]; !ok {
+		return ErrNotFound
+	}
+	delete(m.senders, address)
+	return nil
+}
+
+// ListUsers returns up to limit users matching the filter after the cursor, ordered by creation time and id
+func (m *MemoryStore) ListUsers(ctx context.Context, filter UserFilter, after *Cursor, limit int) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []User
+	for _, user := range m.users {
+		switch {
+		case user.DeletedAt != nil,
+			filter.Verified != nil && user.IsVerified != *filter.Verified,
+			!filter.CreatedAfter.IsZero() && user.CreatedAt.Before(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !user.CreatedAt.Before(filter.CreatedBefore),
+			!strings.HasPrefix(user.PhoneNumber, filter.PhonePrefix),
+			after != nil && !userAfter(user, *after):
+			continue
+		}
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return userAfter(users[j], Cursor{CreatedAt: users[i].CreatedAt, ID: users[i].ID})
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// userAfter tells if the user comes after the cursor in ListUsers
+func userAfter(user User, cursor Cursor) bool {
+	if user.CreatedAt.Equal(cursor.CreatedAt) {
+		return user.ID > cursor.ID
+	}
+	return user.CreatedAt.After(cursor.CreatedAt)
+}
+
+// SetUserStatus changes the status of the user, suspending or locking the user revokes its auth tokens
+func (m *MemoryStore) SetUserStatus(ctx context.Context, id, status, reason string) error {
+	return m.changeUser(id, func(user *User) {
+		user.Status, user.StatusReason, user.StatusChangedAt = status, reason, user.UpdatedAt
+		if status == StatusSuspended || status == StatusLocked {
+			user.TokenVersion++
+		}
+	})
+}
+
+// SetUserRole changes the role of the user
+func (m *MemoryStore) SetUserRole(ctx context.Context, id, role string) error {
+	return m.changeUser(id, func(user *User) {
+		user.Role = role
+	})
+}
+
+// changeUser applies change to the user which is not deleted, increasing its version
+func (m *MemoryStore) changeUser(id string, change func(user *User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for phoneNumber, user := range m.users {
+		if user.ID != id || user.DeletedAt != nil {
+			continue
+		}
+		user.UpdatedAt = time.Now().UTC()
+		user.Version++
+		change(&user)
+		m.users[phoneNumber] = user
+		return nil
+	}
+	return ErrNotFound
+}
+
+// RecordAuthEvent appends the event to the audit log
+func (m *MemoryStore) RecordAuthEvent(ctx context.Context, event *AuthEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = uuid.New().String()
+	event.CreatedAt = time.Now().UTC()
+	m.authEvents = append(m.authEvents, copyEvent(*event))
+	return nil
+}
+
+// QueryAuthEvents returns up to limit events matching the filter which come before the cursor, newest first
+func (m *MemoryStore) QueryAuthEvents(ctx context.Context, filter AuthEventFilter, before *Cursor, limit int) ([]AuthEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []AuthEvent
+	for _, event := range m.authEvents {
+		switch {
+		case len(filter.Types) > 0 && !contains(filter.Types, event.Type),
+			len(filter.Outcomes) > 0 && !contains(filter.Outcomes, event.Outcome),
+			filter.UserID != "" && event.UserID != filter.UserID,
+			filter.Actor != "" && event.Actor != filter.Actor,
+			!filter.Since.IsZero() && event.CreatedAt.Before(filter.Since),
+			!filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until),
+			before != nil && !eventBefore(event, *before):
+			continue
+		}
+		events = append(events, copyEvent(event))
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return eventBefore(events[j], Cursor{CreatedAt: events[i].CreatedAt, ID: events[i].ID})
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// eventBefore tells if the event comes before the cursor, QueryAuthEvents lists the newest events first
+func eventBefore(event AuthEvent, cursor Cursor) bool {
+	if event.CreatedAt.Equal(cursor.CreatedAt) {
+		return event.ID < cursor.ID
+	}
+	return event.CreatedAt.Before(cursor.CreatedAt)
+}
+
+// copyEvent copies the details too, so that events returned by the store can be changed freely
+func copyEvent(event AuthEvent) AuthEvent {
+	if event.Details != nil {
+		details := make(map[string]string, len(event.Details))
+		for k, v := range event.Details {
+			details[k] = v
+		}
+		event.Details = details
+	}
+	return event
+}
+
+// contains tells if the value is one of the values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
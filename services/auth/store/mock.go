@@ -5,6 +5,7 @@ import (
 	"crypto/rsa"
 	"github.com/bhrg3se/flahmingo-homework/utils"
 	"github.com/stretchr/testify/mock"
+	"time"
 )
 
 type MockStore struct {
@@ -16,13 +17,13 @@ func (m *MockStore) GetConfig() utils.Config {
 	return args.Get(0).(utils.Config)
 }
 
-func (m *MockStore) CreateUser(user *User) error {
-	args := m.Called(user)
+func (m *MockStore) CreateUser(ctx context.Context, user *User) error {
+	args := m.Called(ctx, user)
 	return args.Error(0)
 }
 
-func (m *MockStore) GetUser(phoneNumber string) (*User, error) {
-	args := m.Called(phoneNumber)
+func (m *MockStore) GetUser(ctx context.Context, phoneNumber string) (*User, error) {
+	args := m.Called(ctx, phoneNumber)
 	r0, r1 := args.Get(0), args.Error(1)
 	if r0 == nil {
 		return nil, r1
@@ -30,22 +31,148 @@ func (m *MockStore) GetUser(phoneNumber string) (*User, error) {
 	return r0.(*User), r1
 }
 
-func (m *MockStore) PublishOTP(ctx context.Context, otp, phoneNumber string) {
-	m.Called(ctx, otp, phoneNumber)
+func (m *MockStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	args := m.Called(ctx, email)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.(*User), r1
+}
+
+func (m *MockStore) UpdateUser(ctx context.Context, user *User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	args := m.Called(ctx, id)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.(*User), r1
+}
+
+func (m *MockStore) ConfirmEmail(ctx context.Context, id, email string) error {
+	args := m.Called(ctx, id, email)
+	return args.Error(0)
+}
+
+func (m *MockStore) ChangePhoneNumber(ctx context.Context, id, phoneNumber string) error {
+	args := m.Called(ctx, id, phoneNumber)
+	return args.Error(0)
+}
+
+func (m *MockStore) DeleteUser(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetDeletedUser(ctx context.Context, phoneNumber string) (*User, error) {
+	args := m.Called(ctx, phoneNumber)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.(*User), r1
 }
 
-func (m *MockStore) SaveOTP(otp, phoneNumber string) error {
-	args := m.Called(otp, phoneNumber)
+func (m *MockStore) RestoreUser(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
-func (m *MockStore) GetOTP(phoneNumber string) (string, error) {
-	args := m.Called(phoneNumber)
-	return args.String(0), args.Error(1)
+func (m *MockStore) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+	args := m.Called(ctx, deletedBefore)
+	return args.Int(0), args.Error(1)
 }
 
-func (m *MockStore) VerifyUser(phoneNumber string) error {
-	args := m.Called(phoneNumber)
+func (m *MockStore) ExportUserData(ctx context.Context, id string) (*UserData, error) {
+	args := m.Called(ctx, id)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.(*UserData), r1
+}
+
+func (m *MockStore) SaveDevice(ctx context.Context, device *Device) (bool, error) {
+	args := m.Called(ctx, device)
+	return args.Bool(0), args.Error(1)
+}
+
+// InTx runs f with the mock itself
+func (m *MockStore) InTx(ctx context.Context, f func(tx GenericStore) error) error {
+	m.Called(ctx)
+	return f(m)
+}
+
+func (m *MockStore) PublishOTP(ctx context.Context, msg OTPMessage) error {
+	args := m.Called(ctx, msg)
+	return args.Error(0)
+}
+
+func (m *MockStore) SaveOTP(ctx context.Context, otp, phoneNumber string) error {
+	args := m.Called(ctx, otp, phoneNumber)
+	return args.Error(0)
+}
+
+func (m *MockStore) CheckOTP(ctx context.Context, phoneNumber, otp string) error {
+	args := m.Called(ctx, phoneNumber, otp)
+	return args.Error(0)
+}
+
+func (m *MockStore) AllowOTPRequest(ctx context.Context, key string) (time.Duration, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockStore) AllowRequest(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error) {
+	args := m.Called(ctx, key, limit, window)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockStore) VerifyUser(ctx context.Context, phoneNumber string) error {
+	args := m.Called(ctx, phoneNumber)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetLatestDelivery(ctx context.Context, phoneNumber string) (*Delivery, error) {
+	args := m.Called(ctx, phoneNumber)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.(*Delivery), r1
+}
+
+func (m *MockStore) SaveMagicLink(ctx context.Context, id, email string, expiry time.Time) error {
+	args := m.Called(ctx, id, email, expiry)
+	return args.Error(0)
+}
+
+func (m *MockStore) UseMagicLink(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockStore) ListSenders(ctx context.Context) ([]Sender, error) {
+	args := m.Called(ctx)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.([]Sender), r1
+}
+
+func (m *MockStore) SaveSender(ctx context.Context, sender *Sender) error {
+	args := m.Called(ctx, sender)
+	return args.Error(0)
+}
+
+func (m *MockStore) DeleteSender(ctx context.Context, address string) error {
+	args := m.Called(ctx, address)
 	return args.Error(0)
 }
 
@@ -58,3 +185,36 @@ func (m *MockStore) GetJWTPrivateKey() *rsa.PrivateKey {
 	args := m.Called()
 	return args.Get(0).(*rsa.PrivateKey)
 }
+
+func (m *MockStore) ListUsers(ctx context.Context, filter UserFilter, after *Cursor, limit int) ([]User, error) {
+	args := m.Called(ctx, filter, after, limit)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.([]User), r1
+}
+
+func (m *MockStore) SetUserStatus(ctx context.Context, id, status, reason string) error {
+	args := m.Called(ctx, id, status, reason)
+	return args.Error(0)
+}
+
+func (m *MockStore) SetUserRole(ctx context.Context, id, role string) error {
+	args := m.Called(ctx, id, role)
+	return args.Error(0)
+}
+
+func (m *MockStore) RecordAuthEvent(ctx context.Context, event *AuthEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockStore) QueryAuthEvents(ctx context.Context, filter AuthEventFilter, before *Cursor, limit int) ([]AuthEvent, error) {
+	args := m.Called(ctx, filter, before, limit)
+	r0, r1 := args.Get(0), args.Error(1)
+	if r0 == nil {
+		return nil, r1
+	}
+	return r0.([]AuthEvent), r1
+}
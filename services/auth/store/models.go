@@ -1,8 +1,252 @@
 package store
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 type User struct {
 	ID          string `db:"id"`
 	Name        string `db:"name"`
 	IsVerified  bool   `db:"is_verified"`
 	PhoneNumber string `db:"phone_number"`
+	// Email is confirmed by the user, only confirmed emails can be used to login
+	Email string `db:"email"`
+	// PendingEmail is not confirmed yet, it becomes Email once the user opens the link sent to it
+	PendingEmail string            `db:"pending_email"`
+	DisplayName  string            `db:"display_name"`
+	AvatarURL    string            `db:"avatar_url"`
+	Locale       string            `db:"locale"`
+	Timezone     string            `db:"timezone"`
+	Metadata     map[string]string `db:"metadata"`
+	CreatedAt    time.Time         `db:"created_at"`
+	UpdatedAt    time.Time         `db:"updated_at"`
+	// Version is increased by every update, updates of an older version fail with ErrVersionMismatch
+	Version int64 `db:"version"`
+	// TokenVersion is carried in auth tokens, tokens of older versions are revoked
+	TokenVersion int64 `db:"token_version"`
+	// DeletedAt is when the user deleted its account, the account is purged after auth.deletionGracePeriod
+	DeletedAt *time.Time `db:"deleted_at"`
+	// Role grants access to the admin service
+	Role string `db:"role"`
+	// Status is the state of the account, only active users can login
+	Status string `db:"status"`
+	// StatusReason is why the user was suspended or locked
+	StatusReason    string    `db:"status_reason"`
+	StatusChangedAt time.Time `db:"status_changed_at"`
+}
+
+// statuses of users
+const (
+	// StatusPending users signed up but have not verified their phone number yet
+	StatusPending = "pending"
+	// StatusActive users verified their phone number
+	StatusActive = "active"
+	// StatusSuspended users were suspended for abuse through the admin service
+	StatusSuspended = "suspended"
+	// StatusLocked users were locked through the admin service to protect them, like when the account is compromised
+	StatusLocked = "locked"
+	// StatusDeleted users deleted their account, they are not found by lookups
+	StatusDeleted = "deleted"
+)
+
+// roles of users, each role can do everything the roles before it can
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// UserFilter selects the users returned by ListUsers, zero fields do not filter
+type UserFilter struct {
+	// Verified selects verified or unverified users
+	Verified *bool
+	// CreatedAfter and CreatedBefore select users created in [CreatedAfter, CreatedBefore)
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	PhonePrefix   string
+}
+
+// Cursor is the position of a user in ListUsers or an event in QueryAuthEvents, which are ordered by creation time and id
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// AuthEvent is an event of the audit log
+type AuthEvent struct {
+	ID   string `db:"id"`
+	Type string `db:"type"`
+	// Action is the name of an admin action, empty for other events
+	Action  string `db:"action"`
+	Outcome string `db:"outcome"`
+	// UserID is the user the event is about, empty if it is not known
+	UserID string `db:"user_id"`
+	// Actor is the id of the user who caused the event, or "admin-token"
+	Actor string `db:"actor"`
+	// Target is the phone number, email or otp key of the event, or what an admin action was taken on
+	Target    string            `db:"target"`
+	IP        string            `db:"ip"`
+	UserAgent string            `db:"user_agent"`
+	Details   map[string]string `db:"details"`
+	CreatedAt time.Time         `db:"created_at"`
+}
+
+// types of auth events
+const (
+	EventSignup         = "signup"
+	EventOTPSent        = "otp_sent"
+	EventOTPVerified    = "otp_verified"
+	EventOTPFailed      = "otp_failed"
+	EventLogin          = "login"
+	EventTokenIssued    = "token_issued"
+	EventTokenRefreshed = "token_refreshed"
+	EventTokenRevoked   = "token_revoked"
+	EventAdminAction    = "admin_action"
+	EventEmailConfirmed = "email_confirmed"
+	// EventAccountRestored is recorded when a user cancels the deletion of its account
+	EventAccountRestored = "account_restored"
+)
+
+// outcomes of auth events
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// AuthEventFilter selects the events returned by QueryAuthEvents, zero fields do not filter
+type AuthEventFilter struct {
+	Types    []string
+	Outcomes []string
+	UserID   string
+	Actor    string
+	// Since and Until select events created in [Since, Until)
+	Since time.Time
+	Until time.Time
+}
+
+// Delivery is the delivery state of an otp message sent through the otp service
+type Delivery struct {
+	MessageID   string    `db:"message_id"`
+	PhoneNumber string    `db:"phone_number"`
+	Channel     string    `db:"channel"`
+	Provider    string    `db:"provider"`
+	ProviderSID string    `db:"provider_sid"`
+	Status      string    `db:"status"`
+	ErrorCode   string    `db:"error_code"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+// MagicLink is a magic link sent to login with an email
+type MagicLink struct {
+	Email  string     `db:"email"`
+	Expiry time.Time  `db:"expiry"`
+	UsedAt *time.Time `db:"used_at"`
 }
+
+// SenderAssignment is the sender which a phone number gets sms from
+type SenderAssignment struct {
+	Sender     string    `db:"sender"`
+	AssignedAt time.Time `db:"assigned_at"`
+}
+
+// UserData is everything kept about a user, exported with ExportMyData
+type UserData struct {
+	User       User
+	Deliveries []Delivery
+	MagicLinks []MagicLink
+	Devices    []Device
+	// SenderAssignment is nil if no sms was sent to the user yet
+	SenderAssignment *SenderAssignment
+	// AuthEvents are the events of the user in the audit log, oldest first
+	AuthEvents []AuthEvent
+}
+
+// delivery statuses
+const (
+	DeliveryQueued    = "queued"
+	DeliverySent      = "sent"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Device is a device which a user logged in from
+type Device struct {
+	UserID string
+	// Fingerprint identifies the device, it is a hash so that it does not keep what the client sent
+	Fingerprint string
+	// UserAgent is the user agent of the latest login from the device
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// otp delivery channels
+const (
+	ChannelSMS      = "sms"
+	ChannelVoice    = "voice"
+	ChannelEmail    = "email"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// purposes of otp messages, used to pick the message template
+const (
+	PurposeSignup      = "signup"
+	PurposeLogin       = "login"
+	PurposeEmailLogin  = "email_login"
+	PurposePhoneChange = "phone_change"
+	// PurposeEmailVerification is the link sent to confirm an email, its messages have no otp
+	PurposeEmailVerification = "email_verification"
+	// PurposeNewLogin is the alert sent when a user logs in from a new device, its messages have no otp
+	PurposeNewLogin = "new_login"
+	// PurposeAccountRestore is the otp sent to restore a deleted account
+	PurposeAccountRestore = "account_restore"
+)
+
+// OTPMessage is the message published to the otp service
+type OTPMessage struct {
+	// ID is the id of the message and of its delivery, PublishOTP generates one if it is empty
+	ID          string
+	OTP         string
+	PhoneNumber string
+	Email       string
+	MagicLink   string
+	Channel     string
+	Purpose     string
+	// Locale is the language of the message, the otp service falls back to its default locale if it is empty
+	Locale string
+}
+
+// Sender is a phone number, short code or alphanumeric id which sms are sent from
+type Sender struct {
+	Address string `db:"address"`
+	Kind    string `db:"kind"`
+	// Countries are ISO 3166 codes, empty to use the sender for countries without a sender of their own
+	Countries     []string `db:"countries"`
+	RatePerSecond float64  `db:"rate_per_second"`
+	Enabled       bool     `db:"enabled"`
+}
+
+// sender kinds
+const (
+	SenderNumber       = "number"
+	SenderShortCode    = "short_code"
+	SenderAlphanumeric = "alphanumeric"
+)
+
+var (
+	// ErrMagicLinkInvalid is returned when a magic link is expired or already used
+	ErrMagicLinkInvalid = fmt.Errorf("magic link is %w or already used", ErrExpired)
+
+	// ErrOTPInvalid is returned when an otp does not match the saved one
+	ErrOTPInvalid = errors.New("invalid otp")
+	// ErrOTPExpired is returned when an otp is expired
+	ErrOTPExpired = fmt.Errorf("otp %w", ErrExpired)
+	// ErrTooManyAttempts is returned when an otp was tried too many times
+	ErrTooManyAttempts = errors.New("too many otp attempts")
+
+	// ErrVersionMismatch is returned when a user was changed since the version which is updated
+	ErrVersionMismatch = fmt.Errorf("version %w", ErrConflict)
+)
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// messages which were not published are found and published by the relay
+func TestStore_pendingOutbox(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "flahmingo.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer db.Close()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(utils.Config{}, db, nil, privateKey)
+
+	sealed, err := s.sealOutbox(map[string]string{"MESSAGE_ID": "someID", "OTP": "111111"})
+	if err != nil {
+		t.Fatalf("sealOutbox() error = %v", err)
+	}
+	if strings.Contains(sealed, "111111") {
+		t.Fatalf("sealOutbox() got = %s, want the otp encrypted", sealed)
+	}
+	// messages saved before they were encrypted are still published
+	_, err = db.Exec(`INSERT INTO outbox (id,attributes,created_at) VALUES ($1,$2,$3),($4,$5,$3)`,
+		"someID", sealed, time.Now().Add(-time.Minute), "oldID", `{"MESSAGE_ID":"oldID","OTP":"222222"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := s.pendingOutbox(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("pendingOutbox() error = %v", err)
+	}
+	otps := map[string]string{}
+	for _, msg := range messages {
+		otps[msg.id] = msg.attributes["OTP"]
+	}
+	if len(messages) != 2 || otps["someID"] != "111111" || otps["oldID"] != "222222" {
+		t.Fatalf("pendingOutbox() got = %+v, want the saved messages", messages)
+	}
+
+	// messages are not lost without pubsub, they are only logged if otps are logged for development
+	s.publishOutbox(messages)
+	messages, err = s.pendingOutbox(ctx, time.Now())
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("pendingOutbox() after publishing without pubsub got = %+v, %v, want the saved message", messages, err)
+	}
+
+	var config utils.Config
+	config.GoogleCloud.LogOTPs = true
+	s = New(config, db, nil, privateKey)
+	s.publishOutbox(messages)
+	messages, err = s.pendingOutbox(ctx, time.Now())
+	if err != nil || len(messages) != 0 {
+		t.Errorf("pendingOutbox() after publishing got = %+v, %v, want no messages", messages, err)
+	}
+	// published messages are not kept
+	var kept int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM outbox`).Scan(&kept); err != nil || kept != 0 {
+		t.Errorf("outbox after publishing has %d messages, %v, want none", kept, err)
+	}
+}
+
+// unpublished messages to purged users are deleted with them
+func TestStore_purgeOutbox(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "flahmingo.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer db.Close()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(utils.Config{}, db, nil, privateKey)
+
+	if err := s.CreateUser(ctx, &User{ID: "someID", PhoneNumber: "+9779841000000"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, phoneNumber := range map[string]string{"toUser": "+9779841000000", "toOther": "+9779841000001"} {
+		sealed, err := s.sealOutbox(map[string]string{"MESSAGE_ID": id, "PHONE_NUMBER": phoneNumber, "CHANNEL": ChannelSMS})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`INSERT INTO outbox (id,attributes,created_at) VALUES ($1,$2,$3)`, id, sealed, time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteUser(ctx, "someID"); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := s.PurgeDeletedUsers(ctx, time.Now().Add(time.Second)); err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedUsers() = %v, %v, want 1", purged, err)
+	}
+
+	messages, err := s.pendingOutbox(ctx, time.Now())
+	if err != nil || len(messages) != 1 || messages[0].id != "toOther" {
+		t.Errorf("pendingOutbox() after purge got = %+v, %v, want only the message to the other number", messages, err)
+	}
+}
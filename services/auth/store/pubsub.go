@@ -3,16 +3,229 @@ package store
 import (
 	"cloud.google.com/go/pubsub"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
 )
 
-// PublishOTP publishes a pubsub message with phone number and otp as attributes
-func (s Store) PublishOTP(ctx context.Context, otp, phoneNumber string) {
-	msg := pubsub.Message{
-		Data: nil,
-		Attributes: map[string]string{
-			"OTP":          otp,
-			"PHONE_NUMBER": phoneNumber,
-		},
+// outboxMessage is a pubsub message kept in the outbox table until it is published.
+// Its attributes carry otps and magic links, so they are saved encrypted and the row is deleted once it is published.
+type outboxMessage struct {
+	id         string
+	attributes map[string]string
+}
+
+// PublishOTP records the message as queued and adds it to the outbox, which keeps it until it is published.
+// Each message carries a unique id so that the otp service can drop duplicate deliveries
+// and report the delivery status of the message.
+// Messages are published right away, or once the transaction is committed inside InTx.
+func (s Store) PublishOTP(ctx context.Context, otpMsg OTPMessage) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	msg := outboxMessage{id: otpMsg.ID}
+	if msg.id == "" {
+		msg.id = uuid.New().String()
+	}
+	msg.attributes = otpAttributes(msg.id, otpMsg)
+	attributes, err := s.sealOutbox(msg.attributes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	// record the message as queued, the otp service updates it as the delivery progresses
+	if otpMsg.Channel != ChannelEmail {
+		_, err := s.db.ExecContext(ctx, `INSERT INTO deliveries (message_id,phone_number,channel,status,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$5)`,
+			msg.id, otpMsg.PhoneNumber, otpMsg.Channel, DeliveryQueued, now)
+		if err != nil {
+			return dbError(err)
+		}
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO outbox (id,attributes,created_at) VALUES ($1,$2,$3)`, msg.id, attributes, now)
+	if err != nil {
+		return dbError(err)
+	}
+
+	if s.tx != nil {
+		s.tx.outbox = append(s.tx.outbox, msg)
+		return nil
+	}
+	go s.publishOutbox([]outboxMessage{msg})
+	return nil
+}
+
+// publishOutbox publishes the messages and deletes them from the outbox
+func (s Store) publishOutbox(messages []outboxMessage) {
+	for _, msg := range messages {
+		ctx, cancel := s.withTimeout(context.Background())
+		err := publish(ctx, s.pubsub, s.config, msg.attributes)
+		if err == nil {
+			_, err = s.conn.ExecContext(ctx, `DELETE FROM outbox WHERE id=$1`, msg.id)
+		}
+		cancel()
+		if err != nil {
+			// the relay publishes it later
+			logrus.Errorf("could not publish message %s: %v", msg.id, err)
+		}
+	}
+}
+
+// relayOutbox publishes messages which were not published right after they were saved, like when the service
+// stopped or pubsub was down.
+// Messages may be published twice, the otp service drops duplicates by their id.
+func (s Store) relayOutbox(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		messages, err := s.pendingOutbox(ctx, time.Now().Add(-interval))
+		if err != nil {
+			logrus.Errorf("could not get outbox messages: %v", err)
+			continue
+		}
+		s.publishOutbox(messages)
+	}
+}
+
+// pendingOutbox returns unpublished messages saved before the time
+func (s Store) pendingOutbox(ctx context.Context, before time.Time) ([]outboxMessage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.conn.QueryContext(ctx, `SELECT id,attributes FROM outbox WHERE published_at IS NULL AND created_at < $1
+		ORDER BY created_at LIMIT 100`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []outboxMessage
+	for rows.Next() {
+		var msg outboxMessage
+		var attributes string
+		if err := rows.Scan(&msg.id, &attributes); err != nil {
+			return nil, err
+		}
+		msg.attributes, err = s.openOutbox(attributes)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// outboxKey is the key which outbox messages are encrypted with, it is derived from the jwt private key
+// so that it is the same on every instance and after restarts
+func (k jwtKeys) outboxKey() []byte {
+	key := sha256.Sum256(append([]byte("outbox:"), k.private.D.Bytes()...))
+	return key[:]
+}
+
+// sealOutbox encrypts the attributes of an outbox message with AES-GCM
+func (k jwtKeys) sealOutbox(attributes map[string]string) (string, error) {
+	plain, err := json.Marshal(attributes)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(k.outboxKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// openOutbox decrypts the attributes of an outbox message.
+// Messages saved before they were encrypted are plain json.
+func (k jwtKeys) openOutbox(sealed string) (map[string]string, error) {
+	var attributes map[string]string
+	if strings.HasPrefix(sealed, "{") {
+		err := json.Unmarshal([]byte(sealed), &attributes)
+		return attributes, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
 	}
-	s.pubsub.Topic("verification").Publish(ctx, &msg)
+	block, err := aes.NewCipher(k.outboxKey())
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("outbox message is too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(plain, &attributes)
+	return attributes, err
+}
+
+// otpAttributes are the pubsub attributes of an otp message
+func otpAttributes(messageID string, otpMsg OTPMessage) map[string]string {
+	return map[string]string{
+		"MESSAGE_ID":   messageID,
+		"OTP":          otpMsg.OTP,
+		"PHONE_NUMBER": otpMsg.PhoneNumber,
+		"EMAIL":        otpMsg.Email,
+		"MAGIC_LINK":   otpMsg.MagicLink,
+		"CHANNEL":      otpMsg.Channel,
+		"PURPOSE":      otpMsg.Purpose,
+		"LOCALE":       otpMsg.Locale,
+	}
+}
+
+// errPubSubNotConfigured is returned when publishing without a pubsub client, unless otps are logged for development
+var errPubSubNotConfigured = errors.New("pubsub is not configured")
+
+// publish publishes the otp message on pubsub and waits until it is accepted.
+// Without a pubsub client it logs the message if googleCloud.logOTPs is set, and fails otherwise.
+func publish(ctx context.Context, client *pubsub.Client, config utils.Config, attributes map[string]string) error {
+	if client == nil {
+		if !config.GoogleCloud.LogOTPs {
+			return errPubSubNotConfigured
+		}
+		recipient := attributes["PHONE_NUMBER"]
+		if attributes["CHANNEL"] == ChannelEmail {
+			recipient = attributes["EMAIL"]
+		}
+		logrus.Infof("otp for %s is %s %s", recipient, attributes["OTP"], attributes["MAGIC_LINK"])
+		return nil
+	}
+
+	result := client.Topic(utils.VerificationTopic).Publish(ctx, &pubsub.Message{Attributes: attributes})
+	_, err := result.Get(ctx)
+	return err
 }
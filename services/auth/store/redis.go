@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// results of checkOTPScript
+const (
+	redisOTPMissing = iota
+	redisOTPMatched
+	redisOTPMismatched
+	redisOTPExpired
+	redisOTPTooManyAttempts
+)
+
+// checkOTPScript counts an attempt and compares the otp in a single step, so that concurrent attempts
+// from several auth instances can neither exceed the attempt limit nor use the same otp twice.
+// KEYS[1] is the otp hash, ARGV is the otp, max attempts (0 is unlimited) and the current time in milliseconds.
+var checkOTPScript = redis.NewScript(`
+local saved = redis.call('HMGET', KEYS[1], 'value', 'expiry')
+if not saved[1] then
+	return 0
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+local maxAttempts = tonumber(ARGV[2])
+if maxAttempts > 0 and attempts > maxAttempts then
+	return 4
+end
+if tonumber(ARGV[3]) > tonumber(saved[2]) then
+	return 3
+end
+if saved[1] ~= ARGV[1] then
+	return 2
+end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// allowRequestScript counts a request in the current window, which starts with the first request.
+// KEYS[1] is the counter, ARGV[1] is the window in milliseconds. It returns the count and the milliseconds left in the window.
+var allowRequestScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {count, redis.call('PTTL', KEYS[1])}
+`)
+
+// RedisCodeStore keeps otps and otp request counters in redis, which removes them once they expire.
+// Like the database store, it returns ErrNotFound when there is no otp.
+type RedisCodeStore struct {
+	config utils.Config
+	client redis.UniversalClient
+}
+
+// NewRedisCodeStore creates an otp store on the redis client
+func NewRedisCodeStore(config utils.Config, client redis.UniversalClient) *RedisCodeStore {
+	return &RedisCodeStore{config: config, client: client}
+}
+
+func otpKey(key string) string {
+	return "otp:" + key
+}
+
+func otpRequestsKey(key string) string {
+	return "otp_requests:" + key
+}
+
+// SaveOTP replaces the otp of the key and resets its attempts
+func (r *RedisCodeStore) SaveOTP(ctx context.Context, otp, key string) error {
+	ctx, cancel := withTimeout(ctx, r.config.Redis.Timeout)
+	defer cancel()
+	expiry := time.Now().Add(r.config.Auth.OTPExpiry)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, otpKey(key))
+		pipe.HSet(ctx, otpKey(key), "value", otp, "expiry", expiry.UnixNano()/int64(time.Millisecond), "attempts", 0)
+		// the otp is kept a little longer than its expiry so that late attempts get ErrOTPExpired instead of ErrNotFound
+		pipe.PExpire(ctx, otpKey(key), r.config.Auth.OTPExpiry+time.Minute)
+		return nil
+	})
+	return err
+}
+
+// CheckOTP counts an attempt and compares the otp, it is deleted once it is used
+func (r *RedisCodeStore) CheckOTP(ctx context.Context, key, otp string) error {
+	ctx, cancel := withTimeout(ctx, r.config.Redis.Timeout)
+	defer cancel()
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	result, err := checkOTPScript.Run(ctx, r.client, []string{otpKey(key)},
+		otp, r.config.Auth.OTPMaxAttempts, now).Int()
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case redisOTPMissing:
+		return ErrNotFound
+	case redisOTPMismatched:
+		return ErrOTPInvalid
+	case redisOTPExpired:
+		return ErrOTPExpired
+	case redisOTPTooManyAttempts:
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// AllowOTPRequest counts the otp requests of the key in windows of auth.otpRequestWindow
+func (r *RedisCodeStore) AllowOTPRequest(ctx context.Context, key string) (time.Duration, error) {
+	return r.AllowRequest(ctx, key, r.config.Auth.OTPRequestLimit, r.config.Auth.OTPRequestWindow)
+}
+
+// AllowRequest counts the requests of the key in windows, a limit or window of 0 allows all requests
+func (r *RedisCodeStore) AllowRequest(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error) {
+	if limit <= 0 || window <= 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, r.config.Redis.Timeout)
+	defer cancel()
+	result, err := allowRequestScript.Run(ctx, r.client, []string{otpRequestsKey(key)},
+		window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if result[0] > int64(limit) {
+		return time.Duration(result[1]) * time.Millisecond, nil
+	}
+	return 0, nil
+}
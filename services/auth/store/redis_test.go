@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/go-redis/redis/v8"
+	"testing"
+	"time"
+)
+
+// otps and request counters are removed by redis once they expire
+func TestRedisCodeStore_expiry(t *testing.T) {
+	ctx := context.Background()
+	redisServer := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	defer client.Close()
+	s := store.NewRedisCodeStore(testConfig(), client)
+
+	if err := s.SaveOTP(ctx, "111111", "+9779841000000"); err != nil {
+		t.Fatalf("SaveOTP() error = %v", err)
+	}
+	if ttl := redisServer.TTL("otp:+9779841000000"); ttl <= 0 {
+		t.Errorf("otp ttl = %v, want the otp to expire", ttl)
+	}
+	redisServer.FastForward(time.Minute * 10)
+	if err := s.CheckOTP(ctx, "+9779841000000", "111111"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("CheckOTP() after expiry error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.AllowOTPRequest(ctx, "+9779841000000"); err != nil {
+			t.Fatalf("AllowOTPRequest() error = %v", err)
+		}
+	}
+	redisServer.FastForward(time.Hour)
+	if retryAfter, err := s.AllowOTPRequest(ctx, "+9779841000000"); err != nil || retryAfter != 0 {
+		t.Errorf("AllowOTPRequest() after window got = %v, %v, want allowed", retryAfter, err)
+	}
+}
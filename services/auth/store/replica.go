@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+// replica is a read replica of the database. It is skipped once a query fails on it until it can be pinged again.
+type replica struct {
+	db      *sql.DB
+	healthy int32
+}
+
+func newReplica(db *sql.DB) *replica {
+	return &replica{db: db, healthy: 1}
+}
+
+// usable tells if lookups should be tried on the replica
+func (r *replica) usable() bool {
+	return r != nil && atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+	if atomic.SwapInt32(&r.healthy, value) != value {
+		if healthy {
+			logrus.Info("read replica is reachable again")
+		} else {
+			logrus.Warn("read replica is unreachable, reading from the primary database")
+		}
+	}
+}
+
+// monitor pings the replica while it is unhealthy, so that lookups go back to it once it recovers
+func (r *replica) monitor(ctx context.Context, interval time.Duration) {
+	if r == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if r.usable() {
+			continue
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			r.setHealthy(true)
+		}
+	}
+}
+
+// primaryKey marks contexts whose lookups read the primary database
+type primaryKey struct{}
+
+// Primary returns a context whose lookups read the primary database instead of the read replica. It is used by checks
+// which must see the latest writes, like the status and token version of a user, and by reads which precede a write.
+func Primary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// lookup runs a read only query on the read replica if there is one, and on the primary database if it fails.
+// Missing rows are looked up on the primary too, as the replica may not have caught up yet.
+// Inside transactions and for contexts from Primary lookups always use the primary.
+func (s Store) lookup(ctx context.Context, query func(db dbtx) error) error {
+	if s.tx == nil && s.replica.usable() && ctx.Value(primaryKey{}) == nil {
+		err := query(s.replica.db)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("read replica query failed: %v", err)
+			s.replica.setHealthy(false)
+		}
+	}
+	return query(s.db)
+}
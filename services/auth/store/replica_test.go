@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lookups use the replica, and the primary for users the replica does not have yet or when it fails
+func TestStore_lookupReplica(t *testing.T) {
+	ctx := context.Background()
+	primary, err := OpenSQLite(filepath.Join(t.TempDir(), "primary.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer primary.Close()
+	replicaDB, err := OpenSQLite(filepath.Join(t.TempDir(), "replica.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer replicaDB.Close()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(utils.Config{}, primary, nil, privateKey)
+	s.replica = newReplica(replicaDB)
+
+	if err := s.CreateUser(ctx, &User{ID: "primaryID", Name: "On Primary", PhoneNumber: "+9779841000000"}); err != nil {
+		t.Fatal(err)
+	}
+	replicaStore := New(utils.Config{}, replicaDB, nil, privateKey)
+	if err := replicaStore.CreateUser(ctx, &User{ID: "replicaID", Name: "On Replica", PhoneNumber: "+9779841000001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := s.GetUser(ctx, "+9779841000001"); err != nil || user.ID != "replicaID" {
+		t.Errorf("GetUser() got = %v, %v, want the user from the replica", user, err)
+	}
+	if user, err := s.GetUser(ctx, "+9779841000000"); err != nil || user.ID != "primaryID" {
+		t.Errorf("GetUser() of user missing on the replica got = %v, %v, want the user from the primary", user, err)
+	}
+	if !s.replica.usable() {
+		t.Error("replica wanted to stay usable after a missing row")
+	}
+
+	// status and revocation checks read the primary, which does not have the user of the replica
+	if user, err := s.GetUser(Primary(ctx), "+9779841000001"); err != ErrNotFound {
+		t.Errorf("GetUser() with Primary context got = %v, %v, want the user not found on the primary", user, err)
+	}
+	if user, err := s.GetUserByID(ctx, "replicaID"); err != ErrNotFound {
+		t.Errorf("GetUserByID() got = %v, %v, want the user not found on the primary", user, err)
+	}
+
+	// lookups inside transactions see their own changes
+	err = s.InTx(ctx, func(tx GenericStore) error {
+		if err := tx.CreateUser(ctx, &User{ID: "txID", PhoneNumber: "+9779841000002"}); err != nil {
+			return err
+		}
+		_, err := tx.GetUser(ctx, "+9779841000002")
+		return err
+	})
+	if err != nil {
+		t.Errorf("GetUser() inside transaction error = %v", err)
+	}
+
+	replicaDB.Close()
+	if user, err := s.GetUser(ctx, "+9779841000000"); err != nil || user.ID != "primaryID" {
+		t.Errorf("GetUser() with failed replica got = %v, %v, want the user from the primary", user, err)
+	}
+	if s.replica.usable() {
+		t.Error("failed replica wanted to be skipped")
+	}
+
+	// the replica is used again once it can be pinged
+	recovered, err := OpenSQLite(filepath.Join(t.TempDir(), "recovered.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	s.replica.db = recovered
+	monitorCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.replica.monitor(monitorCtx, 10*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for !s.replica.usable() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.replica.usable() {
+		t.Error("recovered replica wanted to be used again")
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/bhrg3se/flahmingo-homework/utils/migrations"
+	_ "github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// OpenSQLite opens the sqlite database in the file and applies migrations.
+// Both auth and otp services can use it, which makes it possible to run them without a database server.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, migrations.SQLite); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&"
+	} else {
+		dsn += "?"
+	}
+	dsn += "_foreign_keys=on&_busy_timeout=5000"
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer, concurrent writes would fail with "database is locked"
+	db.SetMaxOpenConns(1)
+	return db, db.Ping()
+}
+
+// OpenDB opens the database selected in config without applying migrations.
+// It returns the database and its migration dialect.
+func OpenDB(config utils.Config) (*sql.DB, string, error) {
+	if config.Database.Driver == DriverSQLite {
+		db, err := openSQLite(config.Database.Path)
+		return db, migrations.SQLite, err
+	}
+	return utils.CreateDBPool(config), migrations.Postgres, nil
+}
+
+// migrate applies pending migrations
+func migrate(db *sql.DB, dialect string) (int, error) {
+	migrator, err := migrations.New(db, dialect)
+	if err != nil {
+		return 0, err
+	}
+	return migrator.Up(context.Background())
+}
@@ -0,0 +1,834 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/testutils"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/go-redis/redis/v8"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testConfig allows 3 otp attempts and 2 otp requests an hour, and logs otps as the stores have no pubsub client
+func testConfig() utils.Config {
+	var config utils.Config
+	config.Auth.OTPExpiry = time.Minute * 5
+	config.Auth.OTPMaxAttempts = 3
+	config.Auth.OTPRequestLimit = 2
+	config.Auth.OTPRequestWindow = time.Hour
+	config.GoogleCloud.LogOTPs = true
+	return config
+}
+
+// backends returns a new empty store of each backend which runs without external dependencies.
+// Redis is served by miniredis.
+func backends(t *testing.T, config utils.Config) map[string]store.GenericStore {
+	privateKey := testutils.GetMockPrivateKey1()
+
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "flahmingo.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	return map[string]store.GenericStore{
+		store.DriverMemory:  store.NewMemoryStore(config, nil, privateKey),
+		store.DriverSQLite:  store.New(config, db, nil, privateKey),
+		store.OTPStoreRedis: store.WithCodeStore(store.NewMemoryStore(config, nil, privateKey), store.NewRedisCodeStore(config, redisClient)),
+	}
+}
+
+func TestStore_users(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			user := store.User{ID: "someID", Name: "Some User", PhoneNumber: "+9779841000000", Email: "user@example.com"}
+			if err := s.CreateUser(ctx, &user); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+			if err := s.CreateUser(ctx, &store.User{ID: "otherID", PhoneNumber: user.PhoneNumber}); !errors.Is(err, store.ErrAlreadyExists) {
+				t.Errorf("CreateUser() with existing phone number error = %v, want %v", err, store.ErrAlreadyExists)
+			}
+			if err := s.CreateUser(ctx, &store.User{ID: "otherID", PhoneNumber: "+9779841000009", Email: user.Email}); !errors.Is(err, store.ErrAlreadyExists) {
+				t.Errorf("CreateUser() with existing email error = %v, want %v", err, store.ErrAlreadyExists)
+			}
+			// users without email do not conflict with each other
+			if err := s.CreateUser(ctx, &store.User{ID: "thirdID", PhoneNumber: "+9779841000001"}); err != nil {
+				t.Errorf("CreateUser() without email error = %v", err)
+			}
+			if err := s.CreateUser(ctx, &store.User{ID: "fourthID", PhoneNumber: "+9779841000002"}); err != nil {
+				t.Errorf("CreateUser() without email error = %v", err)
+			}
+
+			if _, err := s.GetUser(ctx, "+9779841999999"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUser() of unknown number error = %v, want %v", err, store.ErrNotFound)
+			}
+
+			if err := s.VerifyUser(ctx, user.PhoneNumber); err != nil {
+				t.Fatalf("VerifyUser() error = %v", err)
+			}
+			user.IsVerified = true
+			user.Status = store.StatusActive
+
+			got, err := s.GetUser(ctx, user.PhoneNumber)
+			if err != nil {
+				t.Fatalf("GetUser() error = %v", err)
+			}
+			if got.StatusChangedAt.Before(user.CreatedAt) {
+				t.Errorf("GetUser() status changed at = %v, want after creation", got.StatusChangedAt)
+			}
+			user.StatusChangedAt = got.StatusChangedAt
+			if !reflect.DeepEqual(*got, user) {
+				t.Errorf("GetUser() got = %v, want %v", *got, user)
+			}
+
+			got, err = s.GetUserByEmail(ctx, user.Email)
+			if err != nil {
+				t.Fatalf("GetUserByEmail() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, user) {
+				t.Errorf("GetUserByEmail() got = %v, want %v", *got, user)
+			}
+		})
+	}
+}
+
+func TestStore_updateUser(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			user := store.User{ID: "someID", Name: "Some User", PhoneNumber: "+9779841000000"}
+			if err := s.CreateUser(ctx, &user); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+			if user.Version != 1 || user.CreatedAt.IsZero() {
+				t.Errorf("CreateUser() version = %d, created at %v, want version 1 and a creation time", user.Version, user.CreatedAt)
+			}
+			if err := s.CreateUser(ctx, &store.User{ID: "otherID", PhoneNumber: "+9779841000001", Email: "other@example.com"}); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+
+			stale := user
+			user.DisplayName = "Someone"
+			user.AvatarURL = "https://example.com/avatar.png"
+			user.Locale = "pt-BR"
+			user.Timezone = "Asia/Kathmandu"
+			user.Metadata = map[string]string{"theme": "dark"}
+			if err := s.UpdateUser(ctx, &user); err != nil {
+				t.Fatalf("UpdateUser() error = %v", err)
+			}
+			if user.Version != 2 {
+				t.Errorf("UpdateUser() version = %d, want 2", user.Version)
+			}
+
+			got, err := s.GetUser(ctx, user.PhoneNumber)
+			if err != nil {
+				t.Fatalf("GetUser() error = %v", err)
+			}
+			if got.DisplayName != user.DisplayName || got.AvatarURL != user.AvatarURL || got.Locale != user.Locale ||
+				got.Timezone != user.Timezone || !reflect.DeepEqual(got.Metadata, user.Metadata) || got.Version != 2 ||
+				!got.CreatedAt.Equal(user.CreatedAt) || !got.UpdatedAt.Equal(user.UpdatedAt) {
+				t.Errorf("GetUser() got = %+v, want %+v", *got, user)
+			}
+
+			stale.Name = "Stale"
+			if err := s.UpdateUser(ctx, &stale); !errors.Is(err, store.ErrVersionMismatch) || !errors.Is(err, store.ErrConflict) {
+				t.Errorf("UpdateUser() of old version error = %v, want %v", err, store.ErrVersionMismatch)
+			}
+			user.Email = "other@example.com"
+			if err := s.UpdateUser(ctx, &user); !errors.Is(err, store.ErrAlreadyExists) {
+				t.Errorf("UpdateUser() with existing email error = %v, want %v", err, store.ErrAlreadyExists)
+			}
+			if err := s.UpdateUser(ctx, &store.User{ID: "unknownID", Version: 1}); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("UpdateUser() of unknown user error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestStore_changePhoneNumber(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			user := store.User{ID: "someID", PhoneNumber: "+9779841000000"}
+			if err := s.CreateUser(ctx, &user); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+			if err := s.CreateUser(ctx, &store.User{ID: "otherID", PhoneNumber: "+9779841000001"}); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+
+			if err := s.ChangePhoneNumber(ctx, user.ID, "+9779841000001"); !errors.Is(err, store.ErrAlreadyExists) {
+				t.Errorf("ChangePhoneNumber() to existing number error = %v, want %v", err, store.ErrAlreadyExists)
+			}
+			if err := s.ChangePhoneNumber(ctx, "unknownID", "+9779841000002"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("ChangePhoneNumber() of unknown user error = %v, want %v", err, store.ErrNotFound)
+			}
+			if err := s.ChangePhoneNumber(ctx, user.ID, "+9779841000002"); err != nil {
+				t.Fatalf("ChangePhoneNumber() error = %v", err)
+			}
+
+			if _, err := s.GetUser(ctx, user.PhoneNumber); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUser() of old number error = %v, want %v", err, store.ErrNotFound)
+			}
+			got, err := s.GetUserByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("GetUserByID() error = %v", err)
+			}
+			if got.PhoneNumber != "+9779841000002" || got.TokenVersion != 1 || got.Version != 2 {
+				t.Errorf("GetUserByID() got = %+v, want the new number with token version 1 and version 2", *got)
+			}
+		})
+	}
+}
+
+func TestStore_confirmEmail(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			user := store.User{ID: "someID", PhoneNumber: "+9779841000000", PendingEmail: "someone@example.com"}
+			if err := s.CreateUser(ctx, &user); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+			other := store.User{ID: "otherID", PhoneNumber: "+9779841000001", PendingEmail: "someone@example.com"}
+			if err := s.CreateUser(ctx, &other); err != nil {
+				t.Fatalf("CreateUser() with the same pending email error = %v", err)
+			}
+			if _, err := s.GetUserByEmail(ctx, "someone@example.com"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUserByEmail() of pending email error = %v, want %v", err, store.ErrNotFound)
+			}
+
+			if err := s.ConfirmEmail(ctx, user.ID, "other@example.com"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("ConfirmEmail() of another email error = %v, want %v", err, store.ErrNotFound)
+			}
+			if err := s.ConfirmEmail(ctx, user.ID, "someone@example.com"); err != nil {
+				t.Fatalf("ConfirmEmail() error = %v", err)
+			}
+			if err := s.ConfirmEmail(ctx, other.ID, "someone@example.com"); !errors.Is(err, store.ErrAlreadyExists) {
+				t.Errorf("ConfirmEmail() of email of another user error = %v, want %v", err, store.ErrAlreadyExists)
+			}
+
+			got, err := s.GetUserByEmail(ctx, "someone@example.com")
+			if err != nil {
+				t.Fatalf("GetUserByEmail() error = %v", err)
+			}
+			if got.ID != user.ID || got.PendingEmail != "" || got.Version != 2 {
+				t.Errorf("GetUserByEmail() got = %+v, want the user with the confirmed email and version 2", *got)
+			}
+		})
+	}
+}
+
+func TestStore_deleteUser(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			user := store.User{ID: "someID", PhoneNumber: "+9779841000000", Email: "user@example.com"}
+			if err := s.CreateUser(ctx, &user); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+			if err := s.PublishOTP(ctx, store.OTPMessage{OTP: "111111", PhoneNumber: user.PhoneNumber, Channel: store.ChannelSMS}); err != nil {
+				t.Fatalf("PublishOTP() error = %v", err)
+			}
+			if err := s.SaveMagicLink(ctx, "linkID", user.Email, time.Now().Add(time.Minute)); err != nil {
+				t.Fatalf("SaveMagicLink() error = %v", err)
+			}
+			if err := s.SaveOTP(ctx, "222222", store.PhoneChangeKey(user.ID, "+9779841000001")); err != nil {
+				t.Fatalf("SaveOTP() error = %v", err)
+			}
+			login := store.AuthEvent{Type: store.EventLogin, Outcome: store.OutcomeSuccess, UserID: user.ID, Target: user.PhoneNumber,
+				IP: "10.0.0.1", UserAgent: "app/1.0"}
+			if err := s.RecordAuthEvent(ctx, &login); err != nil {
+				t.Fatalf("RecordAuthEvent() error = %v", err)
+			}
+
+			data, err := s.ExportUserData(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("ExportUserData() error = %v", err)
+			}
+			if data.User.ID != user.ID || len(data.Deliveries) != 1 || len(data.MagicLinks) != 1 || data.MagicLinks[0].UsedAt != nil {
+				t.Errorf("ExportUserData() got = %+v, want the user with a delivery and an unused magic link", *data)
+			}
+			if len(data.AuthEvents) != 1 || data.AuthEvents[0].ID != login.ID || data.AuthEvents[0].IP != login.IP {
+				t.Errorf("ExportUserData() auth events = %+v, want the login", data.AuthEvents)
+			}
+
+			if err := s.DeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+			if err := s.DeleteUser(ctx, user.ID); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("DeleteUser() of deleted user error = %v, want %v", err, store.ErrNotFound)
+			}
+			if _, err := s.GetUser(ctx, user.PhoneNumber); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUser() of deleted user error = %v, want %v", err, store.ErrNotFound)
+			}
+			if _, err := s.GetUserByEmail(ctx, user.Email); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUserByEmail() of deleted user error = %v, want %v", err, store.ErrNotFound)
+			}
+
+			// the deletion can be cancelled during the grace period
+			deleted, err := s.GetDeletedUser(ctx, user.PhoneNumber)
+			if err != nil || deleted.ID != user.ID || deleted.PhoneNumber != user.PhoneNumber || deleted.DeletedAt == nil {
+				t.Fatalf("GetDeletedUser() got = %+v, %v, want the deleted user", deleted, err)
+			}
+			if err := s.RestoreUser(ctx, user.ID); err != nil {
+				t.Fatalf("RestoreUser() error = %v", err)
+			}
+			restored, err := s.GetUserByEmail(ctx, user.Email)
+			if err != nil || restored.ID != user.ID || restored.PhoneNumber != user.PhoneNumber || restored.Status != store.StatusActive ||
+				!restored.IsVerified {
+				t.Errorf("GetUserByEmail() of restored user got = %+v, %v, want the active and verified user", restored, err)
+			}
+			if err := s.RestoreUser(ctx, user.ID); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("RestoreUser() of restored user error = %v, want %v", err, store.ErrNotFound)
+			}
+
+			// restoring the account does not lift a suspension
+			if err := s.SetUserStatus(ctx, user.ID, store.StatusSuspended, "spam"); err != nil {
+				t.Fatalf("SetUserStatus() error = %v", err)
+			}
+			if err := s.DeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+			deleted, err = s.GetDeletedUser(ctx, user.PhoneNumber)
+			if err != nil || deleted.Status != store.StatusSuspended {
+				t.Fatalf("GetDeletedUser() of suspended user got = %+v, %v, want the suspended status", deleted, err)
+			}
+			if err := s.RestoreUser(ctx, user.ID); err != nil {
+				t.Fatalf("RestoreUser() error = %v", err)
+			}
+			if restored, err := s.GetUserByID(ctx, user.ID); err != nil || restored.Status != store.StatusSuspended {
+				t.Errorf("GetUserByID() of restored suspended user got = %+v, %v, want the suspended user", restored, err)
+			}
+			if err := s.SetUserStatus(ctx, user.ID, store.StatusActive, ""); err != nil {
+				t.Fatalf("SetUserStatus() error = %v", err)
+			}
+
+			// the number and email are freed, so the account can not be restored once they are registered again
+			if err := s.DeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+			other := store.User{ID: "otherID", PhoneNumber: user.PhoneNumber, Email: user.Email}
+			if err := s.CreateUser(ctx, &other); err != nil {
+				t.Fatalf("CreateUser() with number of deleted user error = %v", err)
+			}
+			if err := s.RestoreUser(ctx, user.ID); !errors.Is(err, store.ErrAlreadyExists) {
+				t.Errorf("RestoreUser() with registered number error = %v, want %v", err, store.ErrAlreadyExists)
+			}
+			time.Sleep(time.Millisecond)
+			if err := s.PublishOTP(ctx, store.OTPMessage{OTP: "333333", PhoneNumber: other.PhoneNumber, Channel: store.ChannelSMS}); err != nil {
+				t.Fatalf("PublishOTP() error = %v", err)
+			}
+
+			purged, err := s.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour))
+			if err != nil || purged != 0 {
+				t.Fatalf("PurgeDeletedUsers() in grace period = %v, %v, want 0", purged, err)
+			}
+			purged, err = s.PurgeDeletedUsers(ctx, time.Now().Add(time.Second))
+			if err != nil || purged != 1 {
+				t.Fatalf("PurgeDeletedUsers() = %v, %v, want 1", purged, err)
+			}
+			if _, err := s.GetDeletedUser(ctx, user.PhoneNumber); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetDeletedUser() of purged user error = %v, want %v", err, store.ErrNotFound)
+			}
+			// what belongs to the new owner of the number and email is kept
+			otherData, err := s.ExportUserData(ctx, other.ID)
+			if err != nil || len(otherData.Deliveries) != 1 || len(otherData.MagicLinks) != 1 {
+				t.Errorf("ExportUserData() of new owner got = %+v, %v, want only its own delivery and the magic link of the email", otherData, err)
+			}
+			// otps in redis expire on their own
+			if name != store.OTPStoreRedis {
+				if err := s.CheckOTP(ctx, store.PhoneChangeKey(user.ID, "+9779841000001"), "222222"); !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("CheckOTP() of phone change of purged user error = %v, want %v", err, store.ErrNotFound)
+				}
+			}
+			events, err := s.QueryAuthEvents(ctx, store.AuthEventFilter{UserID: user.ID}, nil, 10)
+			if err != nil || len(events) != 1 || events[0].IP != "" || events[0].UserAgent != "" || events[0].Target != "" {
+				t.Errorf("QueryAuthEvents() of purged user got = %+v, %v, want the login without ip, user agent and phone number", events, err)
+			}
+		})
+	}
+}
+
+func TestStore_devices(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			user := store.User{ID: "someID", PhoneNumber: "+9779841000000"}
+			if err := s.CreateUser(ctx, &user); err != nil {
+				t.Fatalf("CreateUser() error = %v", err)
+			}
+
+			saves := []struct {
+				device store.Device
+				want   bool
+			}{
+				// the first device of a user is not new
+				{device: store.Device{UserID: user.ID, Fingerprint: "phone", UserAgent: "app/1.0"}, want: false},
+				{device: store.Device{UserID: user.ID, Fingerprint: "phone", UserAgent: "app/1.1"}, want: false},
+				{device: store.Device{UserID: user.ID, Fingerprint: "laptop", UserAgent: "browser"}, want: true},
+				{device: store.Device{UserID: user.ID, Fingerprint: "laptop", UserAgent: "browser"}, want: false},
+				{device: store.Device{UserID: "otherID", Fingerprint: "laptop"}, want: false},
+			}
+			for i, save := range saves {
+				got, err := s.SaveDevice(ctx, &save.device)
+				if err != nil || got != save.want {
+					t.Fatalf("SaveDevice() %d = %v, %v, want %v", i, got, err, save.want)
+				}
+				if save.device.LastSeenAt.IsZero() {
+					t.Errorf("SaveDevice() %d did not set the last seen time", i)
+				}
+			}
+
+			data, err := s.ExportUserData(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("ExportUserData() error = %v", err)
+			}
+			if len(data.Devices) != 2 || data.Devices[0].Fingerprint != "phone" || data.Devices[0].UserAgent != "app/1.1" ||
+				data.Devices[1].Fingerprint != "laptop" {
+				t.Errorf("ExportUserData() devices = %+v, want phone with the latest user agent and laptop", data.Devices)
+			}
+
+			if err := s.DeleteUser(ctx, user.ID); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+			if _, err := s.PurgeDeletedUsers(ctx, time.Now().Add(time.Second)); err != nil {
+				t.Fatalf("PurgeDeletedUsers() error = %v", err)
+			}
+			// the devices were purged with the user, so the device is the first one again
+			if got, err := s.SaveDevice(ctx, &store.Device{UserID: user.ID, Fingerprint: "tablet"}); err != nil || got {
+				t.Errorf("SaveDevice() after purge = %v, %v, want false", got, err)
+			}
+		})
+	}
+}
+
+func TestStore_adminUsers(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			users := []store.User{
+				{ID: "a", PhoneNumber: "+9779841000000"},
+				{ID: "b", PhoneNumber: "+9779841000001"},
+				{ID: "c", PhoneNumber: "+15005550000"},
+				{ID: "d", PhoneNumber: "+9779841000002"},
+			}
+			for i := range users {
+				if err := s.CreateUser(ctx, &users[i]); err != nil {
+					t.Fatalf("CreateUser() error = %v", err)
+				}
+			}
+			if err := s.VerifyUser(ctx, users[1].PhoneNumber); err != nil {
+				t.Fatalf("VerifyUser() error = %v", err)
+			}
+			if err := s.DeleteUser(ctx, "d"); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+
+			ids := func(users []store.User) (ids []string) {
+				for _, user := range users {
+					ids = append(ids, user.ID)
+				}
+				return ids
+			}
+			verified, unverified := true, false
+			tests := []struct {
+				name   string
+				filter store.UserFilter
+				after  *store.Cursor
+				limit  int
+				want   []string
+			}{
+				{name: "all", limit: 10, want: []string{"a", "b", "c"}},
+				{name: "first page", limit: 2, want: []string{"a", "b"}},
+				{name: "next page", after: &store.Cursor{CreatedAt: users[1].CreatedAt, ID: "b"}, limit: 2, want: []string{"c"}},
+				{name: "verified", filter: store.UserFilter{Verified: &verified}, limit: 10, want: []string{"b"}},
+				{name: "unverified", filter: store.UserFilter{Verified: &unverified}, limit: 10, want: []string{"a", "c"}},
+				{name: "phone prefix", filter: store.UserFilter{PhonePrefix: "+977"}, limit: 10, want: []string{"a", "b"}},
+				{name: "created before", filter: store.UserFilter{CreatedBefore: users[0].CreatedAt}, limit: 10},
+				{name: "created after", filter: store.UserFilter{CreatedAfter: time.Now().Add(time.Hour)}, limit: 10},
+			}
+			for _, tt := range tests {
+				got, err := s.ListUsers(ctx, tt.filter, tt.after, tt.limit)
+				if err != nil {
+					t.Fatalf("ListUsers() %v error = %v", tt.name, err)
+				}
+				if !reflect.DeepEqual(ids(got), tt.want) {
+					t.Errorf("ListUsers() %v got = %v, want %v", tt.name, ids(got), tt.want)
+				}
+			}
+
+			if users[0].Status != store.StatusPending {
+				t.Errorf("CreateUser() status = %v, want %v", users[0].Status, store.StatusPending)
+			}
+			if got, _ := s.GetUserByID(ctx, "b"); got.Status != store.StatusActive || got.StatusChangedAt.Before(users[1].CreatedAt) {
+				t.Errorf("VerifyUser() status = %v, %v, want %v", got.Status, got.StatusChangedAt, store.StatusActive)
+			}
+
+			if err := s.SetUserStatus(ctx, "a", store.StatusSuspended, "spam"); err != nil {
+				t.Fatalf("SetUserStatus() error = %v", err)
+			}
+			got, err := s.GetUserByID(ctx, "a")
+			if err != nil {
+				t.Fatalf("GetUserByID() error = %v", err)
+			}
+			if got.Status != store.StatusSuspended || got.StatusReason != "spam" || got.TokenVersion != users[0].TokenVersion+1 {
+				t.Errorf("SetUserStatus() got = %v, %v, %v, want suspended for spam with a new token version", got.Status, got.StatusReason, got.TokenVersion)
+			}
+			// suspended users stay suspended when they verify their number
+			if err := s.VerifyUser(ctx, users[0].PhoneNumber); err != nil {
+				t.Fatalf("VerifyUser() error = %v", err)
+			}
+			if got, _ := s.GetUserByID(ctx, "a"); got.Status != store.StatusSuspended || !got.IsVerified {
+				t.Errorf("VerifyUser() of suspended user status = %v, want %v", got.Status, store.StatusSuspended)
+			}
+			if err := s.SetUserStatus(ctx, "a", store.StatusActive, ""); err != nil {
+				t.Fatalf("SetUserStatus() error = %v", err)
+			}
+			if got, _ := s.GetUserByID(ctx, "a"); got.Status != store.StatusActive || got.TokenVersion != users[0].TokenVersion+1 {
+				t.Errorf("SetUserStatus() got = %v, %v, want active with the same token version", got.Status, got.TokenVersion)
+			}
+			if err := s.SetUserStatus(ctx, "d", store.StatusLocked, "compromised"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("SetUserStatus() of deleted user error = %v, want %v", err, store.ErrNotFound)
+			}
+
+			if users[0].Role != store.RoleUser {
+				t.Errorf("CreateUser() role = %v, want %v", users[0].Role, store.RoleUser)
+			}
+			if err := s.SetUserRole(ctx, "b", store.RoleSupport); err != nil {
+				t.Fatalf("SetUserRole() error = %v", err)
+			}
+			if got, _ := s.GetUserByID(ctx, "b"); got.Role != store.RoleSupport {
+				t.Errorf("SetUserRole() role = %v, want %v", got.Role, store.RoleSupport)
+			}
+			if err := s.SetUserRole(ctx, "unknownID", store.RoleAdmin); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("SetUserRole() of unknown user error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestStore_authEvents(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now().Add(-time.Second)
+			events := []store.AuthEvent{
+				{Type: store.EventOTPSent, Outcome: store.OutcomeSuccess, Target: "+9779841000000", IP: "10.0.0.1", UserAgent: "grpc-go"},
+				{Type: store.EventLogin, Outcome: store.OutcomeFailure, Target: "+9779841000000", Details: map[string]string{"reason": "OTP_INVALID"}},
+				{Type: store.EventLogin, Outcome: store.OutcomeSuccess, UserID: "someID", Actor: "someID", Target: "+9779841000000"},
+				{Type: store.EventAdminAction, Action: "suspend_user", Outcome: store.OutcomeSuccess, UserID: "someID", Actor: "admin-token", Target: "someID"},
+			}
+			for i := range events {
+				if err := s.RecordAuthEvent(ctx, &events[i]); err != nil {
+					t.Fatalf("RecordAuthEvent() error = %v", err)
+				}
+				if events[i].ID == "" || events[i].CreatedAt.IsZero() {
+					t.Fatalf("RecordAuthEvent() got = %+v, want id and time", events[i])
+				}
+			}
+
+			ids := func(events []store.AuthEvent) (ids []string) {
+				for _, event := range events {
+					ids = append(ids, event.ID)
+				}
+				return ids
+			}
+			// newest events come first, events created at the same time are ordered by id
+			all, err := s.QueryAuthEvents(ctx, store.AuthEventFilter{}, nil, 10)
+			if err != nil {
+				t.Fatalf("QueryAuthEvents() error = %v", err)
+			}
+			if len(all) != len(events) {
+				t.Fatalf("QueryAuthEvents() got %d events, want %d", len(all), len(events))
+			}
+			for i := 1; i < len(all); i++ {
+				if all[i].CreatedAt.After(all[i-1].CreatedAt) {
+					t.Errorf("QueryAuthEvents() got %v after %v", all[i].CreatedAt, all[i-1].CreatedAt)
+				}
+			}
+			for _, got := range all {
+				if got.ID == events[0].ID && !reflect.DeepEqual(got, events[0]) {
+					t.Errorf("QueryAuthEvents() got = %+v, want %+v", got, events[0])
+				}
+			}
+			// where returns the ids of the events matching the filter in the order of the query
+			where := func(keep func(event store.AuthEvent) bool) (ids []string) {
+				for _, event := range all {
+					if keep(event) {
+						ids = append(ids, event.ID)
+					}
+				}
+				return ids
+			}
+
+			tests := []struct {
+				name   string
+				filter store.AuthEventFilter
+				before *store.Cursor
+				limit  int
+				want   []string
+			}{
+				{name: "first page", limit: 2, want: ids(all[:2])},
+				{name: "next page", before: &store.Cursor{CreatedAt: all[1].CreatedAt, ID: all[1].ID}, limit: 10, want: ids(all[2:])},
+				{name: "types", filter: store.AuthEventFilter{Types: []string{store.EventOTPSent, store.EventAdminAction}}, limit: 10,
+					want: where(func(event store.AuthEvent) bool { return event.Type != store.EventLogin })},
+				{name: "outcomes", filter: store.AuthEventFilter{Outcomes: []string{store.OutcomeFailure}}, limit: 10, want: []string{events[1].ID}},
+				{name: "user", filter: store.AuthEventFilter{UserID: "someID", Actor: "someID"}, limit: 10, want: []string{events[2].ID}},
+				{name: "since", filter: store.AuthEventFilter{Since: time.Now().Add(time.Hour)}, limit: 10},
+				{name: "until", filter: store.AuthEventFilter{Since: start, Until: start.Add(time.Hour)}, limit: 10, want: ids(all)},
+			}
+			for _, tt := range tests {
+				got, err := s.QueryAuthEvents(ctx, tt.filter, tt.before, tt.limit)
+				if err != nil {
+					t.Fatalf("QueryAuthEvents() %v error = %v", tt.name, err)
+				}
+				if !reflect.DeepEqual(ids(got), tt.want) {
+					t.Errorf("QueryAuthEvents() %v got = %v, want %v", tt.name, ids(got), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_otp(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.SaveOTP(ctx, "111111", "+9779841000000"); err != nil {
+				t.Fatalf("SaveOTP() error = %v", err)
+			}
+			if err := s.SaveOTP(ctx, "222222", "+9779841000000"); err != nil {
+				t.Fatalf("SaveOTP() error = %v", err)
+			}
+			if err := s.CheckOTP(ctx, "+9779841000000", "111111"); err != store.ErrOTPInvalid {
+				t.Errorf("CheckOTP() of replaced otp error = %v, want %v", err, store.ErrOTPInvalid)
+			}
+			if err := s.CheckOTP(ctx, "+9779841000000", "222222"); err != nil {
+				t.Errorf("CheckOTP() of latest otp error = %v", err)
+			}
+			// otps can be used only once
+			if err := s.CheckOTP(ctx, "+9779841000000", "222222"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("CheckOTP() of used otp error = %v, want %v", err, store.ErrNotFound)
+			}
+			if err := s.CheckOTP(ctx, "+9779841999999", "222222"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("CheckOTP() of unknown number error = %v, want %v", err, store.ErrNotFound)
+			}
+			otp := "222222"
+
+			if _, err := s.GetLatestDelivery(ctx, "+9779841000000"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetLatestDelivery() before publishing error = %v, want %v", err, store.ErrNotFound)
+			}
+			s.PublishOTP(ctx, store.OTPMessage{OTP: otp, PhoneNumber: "+9779841000000", Channel: store.ChannelSMS})
+			delivery, err := s.GetLatestDelivery(ctx, "+9779841000000")
+			if err != nil {
+				t.Fatalf("GetLatestDelivery() error = %v", err)
+			}
+			if delivery.Status != store.DeliveryQueued || delivery.Channel != store.ChannelSMS || delivery.MessageID == "" {
+				t.Errorf("GetLatestDelivery() got = %+v, want queued sms", delivery)
+			}
+		})
+	}
+}
+
+func TestStore_otpAttempts(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.SaveOTP(ctx, "111111", "user@example.com"); err != nil {
+				t.Fatalf("SaveOTP() error = %v", err)
+			}
+			for i := 0; i < 3; i++ {
+				if err := s.CheckOTP(ctx, "user@example.com", "999999"); err != store.ErrOTPInvalid {
+					t.Fatalf("CheckOTP() attempt %d error = %v, want %v", i+1, err, store.ErrOTPInvalid)
+				}
+			}
+			if err := s.CheckOTP(ctx, "user@example.com", "111111"); err != store.ErrTooManyAttempts {
+				t.Fatalf("CheckOTP() after max attempts error = %v, want %v", err, store.ErrTooManyAttempts)
+			}
+
+			// a new otp can be tried again
+			if err := s.SaveOTP(ctx, "222222", "user@example.com"); err != nil {
+				t.Fatalf("SaveOTP() error = %v", err)
+			}
+			if err := s.CheckOTP(ctx, "user@example.com", "222222"); err != nil {
+				t.Errorf("CheckOTP() of new otp error = %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_otpExpired(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	config.Auth.OTPExpiry = -time.Second
+	for name, s := range backends(t, config) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.SaveOTP(ctx, "111111", "+9779841000000"); err != nil {
+				t.Fatalf("SaveOTP() error = %v", err)
+			}
+			if err := s.CheckOTP(ctx, "+9779841000000", "111111"); err != store.ErrOTPExpired || !errors.Is(err, store.ErrExpired) {
+				t.Errorf("CheckOTP() error = %v, want %v", err, store.ErrOTPExpired)
+			}
+		})
+	}
+}
+
+func TestStore_otpRequests(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				if retryAfter, err := s.AllowOTPRequest(ctx, "+9779841000000"); err != nil || retryAfter != 0 {
+					t.Fatalf("AllowOTPRequest() %d got = %v, %v, want allowed", i+1, retryAfter, err)
+				}
+			}
+			retryAfter, err := s.AllowOTPRequest(ctx, "+9779841000000")
+			if err != nil || retryAfter <= 0 || retryAfter > time.Hour {
+				t.Errorf("AllowOTPRequest() over limit got = %v, %v, want to wait up to an hour", retryAfter, err)
+			}
+			if retryAfter, err := s.AllowOTPRequest(ctx, "+9779841000001"); err != nil || retryAfter != 0 {
+				t.Errorf("AllowOTPRequest() of another number got = %v, %v, want allowed", retryAfter, err)
+			}
+		})
+	}
+}
+
+func TestStore_transactions(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			errFailed := errors.New("failed")
+			// changes made outside of a transaction are kept when it fails.
+			// Sqlite has a single connection, which the transaction holds.
+			outside := store.AuthEvent{Type: store.EventOTPSent, Outcome: store.OutcomeSuccess, Target: "+9779841000001"}
+			writeOutside := name != store.DriverSQLite
+			err := s.InTx(ctx, func(tx store.GenericStore) error {
+				if writeOutside {
+					if err := s.RecordAuthEvent(ctx, &outside); err != nil {
+						return err
+					}
+				}
+				if err := tx.CreateUser(ctx, &store.User{ID: "someID", PhoneNumber: "+9779841000000"}); err != nil {
+					return err
+				}
+				if err := tx.PublishOTP(ctx, store.OTPMessage{OTP: "111111", PhoneNumber: "+9779841000000", Channel: store.ChannelSMS}); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			if err != errFailed {
+				t.Fatalf("InTx() error = %v, want %v", err, errFailed)
+			}
+			if _, err := s.GetUser(ctx, "+9779841000000"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetUser() after rollback error = %v, want %v", err, store.ErrNotFound)
+			}
+			if _, err := s.GetLatestDelivery(ctx, "+9779841000000"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetLatestDelivery() after rollback error = %v, want %v", err, store.ErrNotFound)
+			}
+			if writeOutside {
+				events, err := s.QueryAuthEvents(ctx, store.AuthEventFilter{}, nil, 10)
+				if err != nil || len(events) != 1 || events[0].ID != outside.ID {
+					t.Errorf("QueryAuthEvents() after rollback got = %+v, %v, want the event recorded outside of the transaction", events, err)
+				}
+			}
+
+			err = s.InTx(ctx, func(tx store.GenericStore) error {
+				if err := tx.CreateUser(ctx, &store.User{ID: "someID", PhoneNumber: "+9779841000000"}); err != nil {
+					return err
+				}
+				return tx.PublishOTP(ctx, store.OTPMessage{OTP: "111111", PhoneNumber: "+9779841000000", Channel: store.ChannelSMS})
+			})
+			if err != nil {
+				t.Fatalf("InTx() error = %v", err)
+			}
+			if _, err := s.GetUser(ctx, "+9779841000000"); err != nil {
+				t.Errorf("GetUser() after commit error = %v", err)
+			}
+			if _, err := s.GetLatestDelivery(ctx, "+9779841000000"); err != nil {
+				t.Errorf("GetLatestDelivery() after commit error = %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_magicLinks(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.SaveMagicLink(ctx, "valid", "user@example.com", time.Now().Add(time.Minute)); err != nil {
+				t.Fatalf("SaveMagicLink() error = %v", err)
+			}
+			if err := s.SaveMagicLink(ctx, "expired", "user@example.com", time.Now().Add(-time.Minute)); err != nil {
+				t.Fatalf("SaveMagicLink() error = %v", err)
+			}
+
+			if err := s.UseMagicLink(ctx, "valid"); err != nil {
+				t.Errorf("UseMagicLink() error = %v", err)
+			}
+			for _, id := range []string{"valid", "expired", "unknown"} {
+				if err := s.UseMagicLink(ctx, id); err != store.ErrMagicLinkInvalid {
+					t.Errorf("UseMagicLink(%s) error = %v, want %v", id, err, store.ErrMagicLinkInvalid)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_senders(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t, testConfig()) {
+		t.Run(name, func(t *testing.T) {
+			number := store.Sender{Address: "+15005550006", Kind: store.SenderNumber, Countries: []string{"US", "CA"}, RatePerSecond: 1, Enabled: true}
+			id := store.Sender{Address: "Flahmingo", Kind: store.SenderAlphanumeric, Enabled: true}
+			for _, sender := range []store.Sender{number, id} {
+				sender := sender
+				if err := s.SaveSender(ctx, &sender); err != nil {
+					t.Fatalf("SaveSender() error = %v", err)
+				}
+			}
+			number.Enabled = false
+			if err := s.SaveSender(ctx, &number); err != nil {
+				t.Fatalf("SaveSender() update error = %v", err)
+			}
+
+			got, err := s.ListSenders(ctx)
+			if err != nil {
+				t.Fatalf("ListSenders() error = %v", err)
+			}
+			if want := []store.Sender{number, id}; !reflect.DeepEqual(got, want) {
+				t.Errorf("ListSenders() got = %v, want %v", got, want)
+			}
+
+			if err := s.DeleteSender(ctx, id.Address); err != nil {
+				t.Errorf("DeleteSender() error = %v", err)
+			}
+			if err := s.DeleteSender(ctx, id.Address); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("DeleteSender() of deleted sender error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+// queries of cancelled requests are not run
+func TestStore_cancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := backends(t, testConfig())[store.DriverSQLite]
+	if _, err := s.GetUser(ctx, "+9779841000000"); err != context.Canceled {
+		t.Errorf("GetUser() error = %v, want %v", err, context.Canceled)
+	}
+	if err := s.SaveOTP(ctx, "111111", "+9779841000000"); err != context.Canceled {
+		t.Errorf("SaveOTP() error = %v, want %v", err, context.Canceled)
+	}
+}
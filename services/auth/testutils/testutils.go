@@ -39,6 +39,7 @@ var MockUser1 = store.User{
 	Name:        "Some User",
 	IsVerified:  true,
 	PhoneNumber: "someNumber",
+	Status:      store.StatusActive,
 }
 
 var MockUser2 = store.User{
@@ -46,6 +47,7 @@ var MockUser2 = store.User{
 	Name:        "Some User 2",
 	IsVerified:  true,
 	PhoneNumber: "21234567890",
+	Status:      store.StatusActive,
 }
 
 func GetContextWithAuthToken(token string) context.Context {
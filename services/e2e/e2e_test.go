@@ -0,0 +1,131 @@
+package e2e
+
+import (
+	"context"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"testing"
+	"time"
+)
+
+func TestSignupAndLogin(t *testing.T) {
+	h := NewHarness(t)
+	ctx := context.Background()
+	phoneNumber := "+9779841000000"
+
+	var header metadata.MD
+	_, err := h.Client.SignupWithPhoneNumber(ctx, &pb.User{Name: "Test User", PhoneNumber: phoneNumber}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("SignupWithPhoneNumber() error = %v", err)
+	}
+	deliveryID := header.Get("delivery-id")
+	if len(deliveryID) != 1 {
+		t.Fatalf("SignupWithPhoneNumber() delivery-id header = %v, want one id", deliveryID)
+	}
+
+	otp, err := h.WaitForOTP(phoneNumber, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = h.Client.VerifyPhoneNumber(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phoneNumber, Otp: "wrong"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("VerifyPhoneNumber() with wrong otp error = %v, want %v", err, codes.Unauthenticated)
+	}
+	_, err = h.Client.VerifyPhoneNumber(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phoneNumber, Otp: otp})
+	if err != nil {
+		t.Fatalf("VerifyPhoneNumber() error = %v", err)
+	}
+
+	// the fake gateway reports the sms as delivered in background
+	var delivery *pb.DeliveryStatus
+	for deadline := time.Now().Add(messageWaitOn); time.Now().Before(deadline); time.Sleep(time.Millisecond * 50) {
+		delivery, err = h.Client.GetDeliveryStatus(ctx, &pb.DeliveryStatusRequest{PhoneNumber: phoneNumber, DeliveryId: deliveryID[0]})
+		if err != nil {
+			t.Fatalf("GetDeliveryStatus() error = %v", err)
+		}
+		if delivery.Status != pb.DeliveryStatus_QUEUED {
+			break
+		}
+	}
+	if delivery.Status != pb.DeliveryStatus_SENT && delivery.Status != pb.DeliveryStatus_DELIVERED {
+		t.Errorf("GetDeliveryStatus() status = %v, want SENT or DELIVERED", delivery.Status)
+	}
+
+	_, err = h.Client.LoginWithPhoneNumber(ctx, &pb.User{PhoneNumber: phoneNumber})
+	if err != nil {
+		t.Fatalf("LoginWithPhoneNumber() error = %v", err)
+	}
+	otp, err = h.WaitForOTP(phoneNumber, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := h.Client.ValidatePhoneNumberLogin(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phoneNumber, Otp: otp})
+	if err != nil {
+		t.Fatalf("ValidatePhoneNumberLogin() error = %v", err)
+	}
+
+	_, err = h.Client.GetProfile(ctx, &emptypb.Empty{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetProfile() without token error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, "token", token.Token)
+	profile, err := h.Client.GetProfile(authCtx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if profile.PhoneNumber != phoneNumber || profile.Name != "Test User" {
+		t.Errorf("GetProfile() = %v, want phone number %s and name Test User", profile, phoneNumber)
+	}
+}
+
+func TestSignupAgainBeforeVerifying(t *testing.T) {
+	h := NewHarness(t)
+	ctx := context.Background()
+	phoneNumber := "+9779841000001"
+
+	var header metadata.MD
+	_, err := h.Client.SignupWithPhoneNumber(ctx, &pb.User{Name: "Test User", PhoneNumber: phoneNumber}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("SignupWithPhoneNumber() error = %v", err)
+	}
+	deliveryID := header.Get("delivery-id")
+	if len(deliveryID) != 1 {
+		t.Fatalf("SignupWithPhoneNumber() delivery-id header = %v, want one id", deliveryID)
+	}
+	first, err := h.WaitForOTP(phoneNumber, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the otp did not arrive in time, the user signs up again and gets a new one
+	_, err = h.Client.SignupWithPhoneNumber(ctx, &pb.User{Name: "Test User", PhoneNumber: phoneNumber})
+	if err != nil {
+		t.Fatalf("second SignupWithPhoneNumber() error = %v", err)
+	}
+	second, err := h.WaitForOTP(phoneNumber, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		_, err = h.Client.VerifyPhoneNumber(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phoneNumber, Otp: first})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("VerifyPhoneNumber() with replaced otp error = %v, want %v", err, codes.Unauthenticated)
+		}
+	}
+	_, err = h.Client.VerifyPhoneNumber(ctx, &pb.VerifyPhoneNumberRequest{PhoneNumber: phoneNumber, Otp: second})
+	if err != nil {
+		t.Fatalf("VerifyPhoneNumber() error = %v", err)
+	}
+
+	_, err = h.Client.SignupWithPhoneNumber(ctx, &pb.User{Name: "Test User", PhoneNumber: phoneNumber})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("SignupWithPhoneNumber() after verifying error = %v, want %v", err, codes.AlreadyExists)
+	}
+}
@@ -0,0 +1,229 @@
+// Package e2e boots auth, otp, a fake sms gateway, a pubsub emulator and the database in-process
+// so that the whole signup and login flow can be driven through a real grpc client.
+package e2e
+
+import (
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/pb/proto"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/server"
+	"github.com/bhrg3se/flahmingo-homework/services/auth/store"
+	"github.com/bhrg3se/flahmingo-homework/services/fakesms/gateway"
+	"github.com/bhrg3se/flahmingo-homework/services/otp/service"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/bhrg3se/flahmingo-homework/utils/migrations"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const (
+	projectID     = "flahmingo-e2e"
+	twilioToken   = "e2e-auth-token"
+	twilioSID     = "ACe2e"
+	twilioNumber  = "+15005550006"
+	messageWaitOn = time.Second * 10
+)
+
+var otpPattern = regexp.MustCompile(`\d{6}`)
+
+// Harness is a running instance of all services
+type Harness struct {
+	Config  utils.Config
+	DB      *sql.DB
+	SMS     *gateway.Gateway
+	Client  pb.AuthServiceClient
+	cleanup []func()
+}
+
+// databaseConfig reads the test database from E2E_DATABASE_* environment variables.
+// A sqlite database in a temporary directory is used if they are not set.
+func databaseConfig(t testing.TB) utils.Config {
+	var config utils.Config
+	config.Database.Host = os.Getenv("E2E_DATABASE_HOST")
+	if config.Database.Host == "" {
+		config.Database.Driver = store.DriverSQLite
+		config.Database.Path = filepath.Join(t.TempDir(), "e2e.db")
+		return config
+	}
+	config.Database.Driver = store.DriverPostgres
+	config.Database.Port = os.Getenv("E2E_DATABASE_PORT")
+	if config.Database.Port == "" {
+		config.Database.Port = "5432"
+	}
+	config.Database.User = os.Getenv("E2E_DATABASE_USER")
+	config.Database.Password = os.Getenv("E2E_DATABASE_PASSWORD")
+	config.Database.Name = os.Getenv("E2E_DATABASE_NAME")
+	return config
+}
+
+// openDatabase opens the database shared by auth and otp services.
+// The postgres database is wiped so that every test starts with an empty one.
+func openDatabase(config utils.Config) (*sql.DB, error) {
+	if config.Database.Driver == store.DriverSQLite {
+		return store.OpenSQLite(config.Database.Path)
+	}
+
+	db := utils.CreateDBPool(config)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := utils.WaitForDB(ctx, db, "database"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := resetDatabase(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewHarness starts all services and stops them when the test finishes
+func NewHarness(t testing.TB) *Harness {
+	h := &Harness{Config: databaseConfig(t)}
+	t.Cleanup(h.close)
+
+	db, err := openDatabase(h.Config)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	h.DB = db
+	h.cleanup = append(h.cleanup, func() { h.DB.Close() })
+
+	// fake twilio
+	h.SMS = gateway.New(twilioToken)
+	smsServer := httptest.NewServer(h.SMS.Handler())
+	h.cleanup = append(h.cleanup, smsServer.Close)
+
+	// the otp service needs its webhook url in the config before it is created,
+	// so the server is started only after the otp service is plugged into it
+	webhooks := httptest.NewUnstartedServer(nil)
+	h.cleanup = append(h.cleanup, webhooks.Close)
+
+	h.Config.GoogleCloud.ProjectID = projectID
+	h.Config.Twilio.BaseURL = smsServer.URL
+	h.Config.Twilio.AccountSID = twilioSID
+	h.Config.Twilio.AuthToken = twilioToken
+	h.Config.Twilio.PhoneNumber = twilioNumber
+	h.Config.OTP.StatusCallbackURL = "http://" + webhooks.Listener.Addr().String() + "/twilio/status"
+	h.Config.OTP.Templates.DefaultLocale = "en"
+	h.Config.OTP.Dedup.Store = "memory"
+	h.Config.OTP.Dedup.Window = time.Hour
+	h.Config.OTP.Dedup.CacheSize = 1000
+	h.Config.OTP.Subscriber.Workers = 10
+	h.Config.OTP.HTTP.Timeout = time.Second * 5
+	h.Config.Auth.OTPExpiry = time.Minute * 5
+	h.Config.Auth.OTPMaxAttempts = 5
+
+	// pubsub emulator shared by both services
+	psServer := pstest.NewServer()
+	h.cleanup = append(h.cleanup, func() { psServer.Close() })
+	authPubSub := h.pubsubClient(t, psServer.Addr)
+	otpPubSub := h.pubsubClient(t, psServer.Addr)
+	if _, err := utils.CreateVerificationTopic(context.Background(), authPubSub); err != nil {
+		t.Fatalf("could not create topic: %v", err)
+	}
+
+	// otp service
+	otpService, err := service.New(h.Config, h.DB)
+	if err != nil {
+		t.Fatalf("could not create otp service: %v", err)
+	}
+	webhooks.Config.Handler = otpService.Handler()
+	webhooks.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		otpService.Receive(ctx, otpPubSub.Subscription(utils.VerificationSubscription))
+	}()
+	h.cleanup = append(h.cleanup, func() {
+		cancel()
+		<-done
+	})
+
+	// auth service
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate jwt key: %v", err)
+	}
+	authStore := store.New(h.Config, h.DB, authPubSub, privateKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	pb.RegisterAuthServiceServer(grpcServer, server.NewServer(authStore))
+	go grpcServer.Serve(listener)
+	h.cleanup = append(h.cleanup, grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to auth service: %v", err)
+	}
+	h.cleanup = append(h.cleanup, func() { conn.Close() })
+	h.Client = pb.NewAuthServiceClient(conn)
+
+	return h
+}
+
+func (h *Harness) pubsubClient(t testing.TB, addr string) *pubsub.Client {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to pubsub emulator: %v", err)
+	}
+	client, err := pubsub.NewClient(context.Background(), projectID, option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("could not create pubsub client: %v", err)
+	}
+	h.cleanup = append(h.cleanup, func() { client.Close() })
+	return client
+}
+
+// close stops the services in the reverse order they were started
+func (h *Harness) close() {
+	for i := len(h.cleanup) - 1; i >= 0; i-- {
+		h.cleanup[i]()
+	}
+}
+
+// WaitForOTP waits until n sms are sent to the phone number and returns the otp in the last one
+func (h *Harness) WaitForOTP(to string, n int) (string, error) {
+	deadline := time.Now().Add(messageWaitOn)
+	for time.Now().Before(deadline) {
+		if messages := h.SMS.Messages(to); len(messages) >= n {
+			otp := otpPattern.FindString(messages[n-1].Body)
+			if otp == "" {
+				return "", fmt.Errorf("no otp in sms: %s", messages[n-1].Body)
+			}
+			return otp, nil
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return "", fmt.Errorf("sms %d was not sent to %s within %s", n, to, messageWaitOn)
+}
+
+// resetDatabase drops every table and recreates the schema by applying all migrations
+func resetDatabase(db *sql.DB) error {
+	_, err := db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public;`)
+	if err != nil {
+		return err
+	}
+	migrator, err := migrations.New(db, migrations.Postgres)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(context.Background())
+	return err
+}
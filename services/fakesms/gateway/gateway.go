@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an sms received by the gateway
+type Message struct {
+	SID         string    `json:"sid"`
+	AccountSID  string    `json:"account_sid"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Body        string    `json:"body"`
+	Status      string    `json:"status"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// Call is a voice call received by the gateway
+type Call struct {
+	SID         string    `json:"sid"`
+	AccountSID  string    `json:"account_sid"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Twiml       string    `json:"twiml"`
+	Status      string    `json:"status"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// Gateway is a fake sms gateway which implements the Messages.json and Calls.json endpoints of Twilio's API.
+// It keeps received messages and calls in memory and serves them through an inbox API.
+type Gateway struct {
+	// authToken is used to sign status callbacks, like twilio does with the account's auth token
+	authToken string
+
+	mu       sync.Mutex
+	seq      int
+	messages []Message
+	calls    []Call
+	failing  map[string]bool
+}
+
+// New creates a gateway which signs status callbacks with the given auth token
+func New(authToken string) *Gateway {
+	return &Gateway{authToken: authToken, failing: map[string]bool{}}
+}
+
+// Handler returns the http handler of the twilio and inbox endpoints
+//
+//	POST   /2010-04-01/Accounts/{AccountSid}/Messages.json  sends an sms
+//	POST   /2010-04-01/Accounts/{AccountSid}/Calls.json     makes a call
+//	GET    /inbox?to={number}                                lists received sms
+//	GET    /inbox/latest?to={number}                         returns the latest sms
+//	GET    /calls?to={number}                                lists received calls
+//	DELETE /inbox                                            deletes all sms and calls
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/2010-04-01/Accounts/", g.twilio)
+	mux.HandleFunc("/inbox", g.inbox)
+	mux.HandleFunc("/inbox/latest", g.latest)
+	mux.HandleFunc("/calls", g.listCalls)
+	return mux
+}
+
+// Fail makes sms and calls to the number fail with an undelivered status, to simulate unreachable numbers
+func (g *Gateway) Fail(to string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failing[to] = true
+}
+
+// Messages returns sms sent to the number, or all sms if number is empty
+func (g *Gateway) Messages(to string) []Message {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	messages := []Message{}
+	for _, m := range g.messages {
+		if to == "" || m.To == to {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// Calls returns calls made to the number, or all calls if number is empty
+func (g *Gateway) Calls(to string) []Call {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	calls := []Call{}
+	for _, c := range g.calls {
+		if to == "" || c.To == to {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// WaitForMessage waits until an sms is sent to the number and returns the latest one
+func (g *Gateway) WaitForMessage(to string, timeout time.Duration) (Message, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if messages := g.Messages(to); len(messages) > 0 {
+			return messages[len(messages)-1], nil
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return Message{}, fmt.Errorf("no sms sent to %s within %s", to, timeout)
+}
+
+// Reset deletes all received sms and calls
+func (g *Gateway) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.messages = nil
+	g.calls = nil
+}
+
+// twilio handles the Messages.json and Calls.json endpoints
+func (g *Gateway) twilio(w http.ResponseWriter, r *http.Request) {
+	// path is /2010-04-01/Accounts/{AccountSid}/{Resource}.json
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/2010-04-01/Accounts/"), "/")
+	if len(parts) != 2 || r.Method != http.MethodPost {
+		twilioError(w, http.StatusNotFound, 20404, "The requested resource was not found")
+		return
+	}
+	accountSID := parts[0]
+
+	user, _, ok := r.BasicAuth()
+	if !ok || user != accountSID {
+		twilioError(w, http.StatusUnauthorized, 20003, "Authenticate")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		twilioError(w, http.StatusBadRequest, 21100, "Invalid request")
+		return
+	}
+
+	to := r.PostForm.Get("To")
+	if to == "" {
+		twilioError(w, http.StatusBadRequest, 21604, "A 'To' phone number is required.")
+		return
+	}
+
+	g.mu.Lock()
+	g.seq++
+	seq := g.seq
+	failing := g.failing[to]
+	g.mu.Unlock()
+
+	switch parts[1] {
+	case "Messages.json":
+		msg := Message{
+			SID:         fmt.Sprintf("SM%032d", seq),
+			AccountSID:  accountSID,
+			From:        r.PostForm.Get("From"),
+			To:          to,
+			Body:        r.PostForm.Get("Body"),
+			Status:      "queued",
+			DateCreated: time.Now(),
+		}
+		g.mu.Lock()
+		g.messages = append(g.messages, msg)
+		g.mu.Unlock()
+
+		status := "delivered"
+		if failing {
+			status = "undelivered"
+		}
+		g.statusCallback(r.PostForm.Get("StatusCallback"), url.Values{
+			"MessageSid":    {msg.SID},
+			"MessageStatus": {status},
+			"AccountSid":    {accountSID},
+		})
+		writeJSON(w, http.StatusCreated, msg)
+
+	case "Calls.json":
+		call := Call{
+			SID:         fmt.Sprintf("CA%032d", seq),
+			AccountSID:  accountSID,
+			From:        r.PostForm.Get("From"),
+			To:          to,
+			Twiml:       r.PostForm.Get("Twiml"),
+			Status:      "queued",
+			DateCreated: time.Now(),
+		}
+		g.mu.Lock()
+		g.calls = append(g.calls, call)
+		g.mu.Unlock()
+
+		status := "completed"
+		if failing {
+			status = "no-answer"
+		}
+		g.statusCallback(r.PostForm.Get("StatusCallback"), url.Values{
+			"CallSid":    {call.SID},
+			"CallStatus": {status},
+			"AccountSid": {accountSID},
+		})
+		writeJSON(w, http.StatusCreated, call)
+
+	default:
+		twilioError(w, http.StatusNotFound, 20404, "The requested resource was not found")
+	}
+}
+
+// statusCallback posts the final status to the callback url in background, signed like twilio does
+func (g *Gateway) statusCallback(callbackURL string, params url.Values) {
+	if callbackURL == "" {
+		return
+	}
+	go func() {
+		req, _ := http.NewRequest("POST", callbackURL, strings.NewReader(params.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Twilio-Signature", signature(g.authToken, callbackURL, params))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logrus.Errorf("could not send status callback: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+func (g *Gateway) inbox(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, g.Messages(r.URL.Query().Get("to")))
+	case http.MethodDelete:
+		g.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *Gateway) latest(w http.ResponseWriter, r *http.Request) {
+	messages := g.Messages(r.URL.Query().Get("to"))
+	if len(messages) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, messages[len(messages)-1])
+}
+
+func (g *Gateway) listCalls(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, g.Calls(r.URL.Query().Get("to")))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// twilioError writes an error response in the format of twilio's API
+func twilioError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"code":    code,
+		"message": message,
+		"status":  status,
+	})
+}
+
+// signature computes the X-Twilio-Signature header of a webhook request.
+// See https://www.twilio.com/docs/usage/security#validating-requests
+func signature(authToken, callbackURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	data := callbackURL
+	for _, k := range keys {
+		for _, v := range params[k] {
+			data += k + v
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGateway_Messages(t *testing.T) {
+	g := New("someToken")
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	send := func(accountSID, to, body string) *http.Response {
+		v := url.Values{"To": {to}, "From": {"+15005550006"}, "Body": {body}}
+		req, _ := http.NewRequest("POST", server.URL+"/2010-04-01/Accounts/"+accountSID+"/Messages.json", strings.NewReader(v.Encode()))
+		req.SetBasicAuth("AC123", "someToken")
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := send("AC123", "+21234567890", "123456 is your code")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Messages.json got status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	var created Message
+	json.NewDecoder(resp.Body).Decode(&created)
+	if !strings.HasPrefix(created.SID, "SM") {
+		t.Errorf("Messages.json got sid %v", created.SID)
+	}
+
+	// account sid of the path must match basic auth
+	if resp := send("AC999", "+21234567890", "123456 is your code"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Messages.json got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	msg, err := g.WaitForMessage("+21234567890", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != "123456 is your code" || msg.SID != created.SID {
+		t.Errorf("WaitForMessage() got = %v", msg)
+	}
+
+	resp, err = http.Get(server.URL + "/inbox/latest?to=%2B21234567890")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var latest Message
+	json.NewDecoder(resp.Body).Decode(&latest)
+	if latest.SID != created.SID {
+		t.Errorf("/inbox/latest got = %v, want %v", latest, created)
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/inbox", nil)
+	http.DefaultClient.Do(req)
+	if messages := g.Messages(""); len(messages) != 0 {
+		t.Errorf("Messages() after reset got = %v", messages)
+	}
+}
+
+func TestGateway_statusCallback(t *testing.T) {
+	received := make(chan url.Values, 1)
+	var callbackURL string
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Header.Get("X-Twilio-Signature") != signature("someToken", callbackURL, r.PostForm) {
+			t.Error("status callback has invalid signature")
+		}
+		received <- r.PostForm
+	}))
+	defer callback.Close()
+	callbackURL = callback.URL + "/twilio/status"
+
+	g := New("someToken")
+	g.Fail("+15550000000")
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	v := url.Values{"To": {"+15550000000"}, "Body": {"123456"}, "StatusCallback": {callbackURL}}
+	req, _ := http.NewRequest("POST", server.URL+"/2010-04-01/Accounts/AC123/Messages.json", strings.NewReader(v.Encode()))
+	req.SetBasicAuth("AC123", "someToken")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case params := <-received:
+		if params.Get("MessageStatus") != "undelivered" {
+			t.Errorf("status callback got status %v, want undelivered", params.Get("MessageStatus"))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("status callback was not sent")
+	}
+}
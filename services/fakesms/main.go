@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"github.com/bhrg3se/flahmingo-homework/services/fakesms/gateway"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// fakesms is a local twilio compatible sms gateway for development and tests.
+// Point twilio.baseURL of the otp service to it and read the sent otps from its inbox.
+func main() {
+	listen := flag.String("l", "127.0.0.1:8090", "listen address")
+	authToken := flag.String("t", "", "twilio auth token used to sign status callbacks")
+	flag.Parse()
+
+	g := gateway.New(*authToken)
+
+	logrus.Infof("starting fake sms gateway in %s", *listen)
+	err := http.ListenAndServe(*listen, g.Handler())
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
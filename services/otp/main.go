@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"github.com/bhrg3se/flahmingo-homework/services/otp/service"
 	"github.com/bhrg3se/flahmingo-homework/utils"
 	"github.com/sirupsen/logrus"
 	"os"
@@ -28,6 +29,6 @@ func main() {
 		logrus.SetOutput(f)
 	}
 
-	startService(config)
+	service.Start(config)
 
 }
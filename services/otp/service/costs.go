@@ -0,0 +1,184 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unknownCountry is recorded for phone numbers which do not match any configured country prefix
+const unknownCountry = "ZZ"
+
+// budgetWarning is the fraction of a daily budget after which a warning is logged
+const budgetWarning = 0.8
+
+// errorBudgetExceeded is the error code recorded in deliveries which were not sent because of the budget
+const errorBudgetExceeded = "budget_exceeded"
+
+var errBudgetExceeded = errors.New("daily sms budget exceeded")
+
+// smsCost is the price of an sms and the budget it is charged to
+type smsCost struct {
+	day      string
+	country  string
+	price    float64
+	budget   float64
+	currency string
+}
+
+// costs records sms spend per day and destination country and enforces the daily budgets.
+// Spend is kept in the sms_spend table so that all otp service instances share the budget.
+type costs struct {
+	config utils.Config
+	db     *sql.DB
+
+	mu sync.Mutex
+	// alerted has the day of the last budget alert of each country, to alert only once a day
+	alerted map[string]string
+}
+
+func newCosts(config utils.Config, db *sql.DB) *costs {
+	return &costs{config: config, db: db, alerted: map[string]string{}}
+}
+
+// estimate returns the cost of an sms sent today to the phone number from the configured price table
+func (c *costs) estimate(phoneNumber string) smsCost {
+	cost := smsCost{
+		day:      time.Now().UTC().Format("2006-01-02"),
+		country:  unknownCountry,
+		price:    c.config.OTP.Costs.DefaultPrice,
+		budget:   c.config.OTP.Costs.DefaultDailyBudget,
+		currency: c.config.OTP.Costs.Currency,
+	}
+
+	if country, countryCost, ok := countryOf(c.config, phoneNumber); ok {
+		cost.country = country
+		cost.price = countryCost.Price
+		cost.budget = countryCost.DailyBudget
+	}
+	return cost
+}
+
+// countryOf returns the configured country of the phone number.
+// The country is the one with the longest prefix matching the phone number.
+func countryOf(config utils.Config, phoneNumber string) (string, utils.CountryCost, bool) {
+	var country, prefix string
+	var found utils.CountryCost
+	for code, countryCost := range config.OTP.Costs.Countries {
+		if countryCost.Prefix == "" || len(countryCost.Prefix) <= len(prefix) || !strings.HasPrefix(phoneNumber, countryCost.Prefix) {
+			continue
+		}
+		prefix = countryCost.Prefix
+		// viper lower cases map keys
+		country = strings.ToUpper(code)
+		found = countryCost
+	}
+	return country, found, prefix != ""
+}
+
+// reserve adds the price to the day's spend of the country before the sms is sent.
+// It returns errBudgetExceeded without changing the spend if the budget does not allow it.
+func (c *costs) reserve(cost smsCost) error {
+	if cost.budget > 0 && cost.price > cost.budget {
+		c.alert(cost)
+		return errBudgetExceeded
+	}
+
+	// the budget is checked in the same statement so that concurrent sends cannot exceed it
+	var spend float64
+	err := c.db.QueryRow(`INSERT INTO sms_spend (day,country,currency,amount,messages) VALUES ($1,$2,$3,$4,1)
+		ON CONFLICT (day,country) DO UPDATE SET amount=sms_spend.amount+EXCLUDED.amount, messages=sms_spend.messages+1
+		WHERE CAST($5 AS NUMERIC) <= 0 OR sms_spend.amount+EXCLUDED.amount <= CAST($5 AS NUMERIC)
+		RETURNING amount`,
+		cost.day, cost.country, cost.currency, cost.price, cost.budget).Scan(&spend)
+	if err == sql.ErrNoRows {
+		c.alert(cost)
+		return errBudgetExceeded
+	}
+	if err != nil {
+		return err
+	}
+
+	if cost.budget > 0 && spend >= cost.budget*budgetWarning && spend-cost.price < cost.budget*budgetWarning {
+		logrus.WithFields(logrus.Fields{
+			"country":  cost.country,
+			"spend":    spend,
+			"budget":   cost.budget,
+			"currency": cost.currency,
+		}).Warn("sms spend reached 80% of the daily budget")
+	}
+	return nil
+}
+
+// release removes the reserved price of an sms which could not be sent
+func (c *costs) release(cost smsCost) {
+	_, err := c.db.Exec(`UPDATE sms_spend SET amount=amount-$1, messages=messages-1 WHERE day=$2 AND country=$3`,
+		cost.price, cost.day, cost.country)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// record stores the cost of a sent sms in its delivery.
+// If the provider reported the actual price, the spend is corrected by the difference to the estimate.
+func (c *costs) record(messageID string, cost smsCost, providerPrice float64, hasProviderPrice bool) {
+	price := cost.price
+	if hasProviderPrice && providerPrice != cost.price {
+		price = providerPrice
+		_, err := c.db.Exec(`UPDATE sms_spend SET amount=amount+$1 WHERE day=$2 AND country=$3`,
+			providerPrice-cost.price, cost.day, cost.country)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+
+	_, err := c.db.Exec(`UPDATE deliveries SET country=$1, price=$2, currency=$3 WHERE message_id=$4`,
+		cost.country, price, cost.currency, messageID)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// alert logs an error the first time the budget of a country is exceeded in a day
+func (c *costs) alert(cost smsCost) {
+	c.mu.Lock()
+	alerted := c.alerted[cost.country] == cost.day
+	c.alerted[cost.country] = cost.day
+	c.mu.Unlock()
+
+	if alerted {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"country":  cost.country,
+		"budget":   cost.budget,
+		"currency": cost.currency,
+	}).Error("daily sms budget exceeded, sending is paused until the end of the day (UTC)")
+}
+
+// twilioMessage is the message resource returned by twilio when an sms is sent
+type twilioMessage struct {
+	SID string `json:"sid"`
+	// Price is negative, like "-0.00750", and is usually null until the sms is sent to the carrier
+	Price     *string `json:"price"`
+	PriceUnit string  `json:"price_unit"`
+}
+
+// price returns the price of the message if twilio reported it in the given currency
+func (m twilioMessage) price(currency string) (float64, bool) {
+	if m.Price == nil || !strings.EqualFold(m.PriceUnit, currency) {
+		return 0, false
+	}
+	price, err := strconv.ParseFloat(*m.Price, 64)
+	if err != nil {
+		logrus.Warnf("could not parse twilio price %q: %v", *m.Price, err)
+		return 0, false
+	}
+	return math.Abs(price), true
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"testing"
+)
+
+func Test_costs_estimate(t *testing.T) {
+	var config utils.Config
+	config.OTP.Costs.Currency = "USD"
+	config.OTP.Costs.DefaultPrice = 0.05
+	config.OTP.Costs.DefaultDailyBudget = 10
+	config.OTP.Costs.Countries = map[string]utils.CountryCost{
+		"us": {Prefix: "+1", Price: 0.0079, DailyBudget: 50},
+		"ca": {Prefix: "+1604", Price: 0.0085, DailyBudget: 5},
+		"np": {Prefix: "+977", Price: 0.1},
+	}
+	c := newCosts(config, nil)
+
+	tests := []struct {
+		phoneNumber string
+		country     string
+		price       float64
+		budget      float64
+	}{
+		{phoneNumber: "+12025550123", country: "US", price: 0.0079, budget: 50},
+		{phoneNumber: "+16045550123", country: "CA", price: 0.0085, budget: 5},
+		{phoneNumber: "+9779841000000", country: "NP", price: 0.1, budget: 0},
+		{phoneNumber: "+447700900123", country: unknownCountry, price: 0.05, budget: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phoneNumber, func(t *testing.T) {
+			got := c.estimate(tt.phoneNumber)
+			if got.country != tt.country || got.price != tt.price || got.budget != tt.budget || got.currency != "USD" {
+				t.Errorf("estimate() = %+v, want country %s price %v budget %v", got, tt.country, tt.price, tt.budget)
+			}
+		})
+	}
+}
+
+func Test_twilioMessage_price(t *testing.T) {
+	price := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		message twilioMessage
+		want    float64
+		wantOk  bool
+	}{
+		{name: "reported", message: twilioMessage{Price: price("-0.00750"), PriceUnit: "USD"}, want: 0.0075, wantOk: true},
+		{name: "not yet priced", message: twilioMessage{PriceUnit: "USD"}},
+		{name: "other currency", message: twilioMessage{Price: price("-0.00750"), PriceUnit: "EUR"}},
+		{name: "invalid", message: twilioMessage{Price: price("abc"), PriceUnit: "USD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.message.price("usd")
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("price() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import "testing"
+
+func Test_regionOf(t *testing.T) {
+	tests := []struct {
+		phoneNumber string
+		want        string
+	}{
+		{phoneNumber: "+15005550006", want: "US"},
+		{phoneNumber: "+16045550006", want: "CA"},
+		{phoneNumber: "+18765550006", want: "JM"},
+		{phoneNumber: "+9779841234567", want: "NP"},
+		{phoneNumber: "+447700900123", want: "GB"},
+		{phoneNumber: "+77011234567", want: "KZ"},
+		{phoneNumber: "+74951234567", want: "RU"},
+		{phoneNumber: "+2125551234", want: "MA"},
+		{phoneNumber: "+8005551234", want: ""},
+		{phoneNumber: "9779841234567", want: ""},
+		{phoneNumber: "+", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phoneNumber, func(t *testing.T) {
+			if got := regionOf(tt.phoneNumber); got != tt.want {
+				t.Errorf("regionOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"container/list"
+	"database/sql"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// deduplicator remembers which messages have already been handled.
+// PubSub delivers at least once, so the same message can arrive more than once.
+type deduplicator interface {
+	// claim marks the message as handled. It returns false if the message was already claimed within the window.
+	claim(id string) (bool, error)
+}
+
+// newDeduplicator creates the deduplicator selected in config
+func newDeduplicator(config utils.Config, db *sql.DB) deduplicator {
+	if config.OTP.Dedup.Store == "postgres" {
+		return newPostgresDeduplicator(db, config.OTP.Dedup.Window)
+	}
+	return newMemoryDeduplicator(config.OTP.Dedup.CacheSize, config.OTP.Dedup.Window)
+}
+
+// memoryDeduplicator keeps recently handled message ids in a LRU cache.
+// Ids are forgotten when they are older than the window or when the cache is full.
+type memoryDeduplicator struct {
+	mu      sync.Mutex
+	size    int
+	window  time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	id        string
+	claimedAt time.Time
+}
+
+func newMemoryDeduplicator(size int, window time.Duration) *memoryDeduplicator {
+	return &memoryDeduplicator{
+		size:    size,
+		window:  window,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (d *memoryDeduplicator) claim(id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := d.entries[id]; ok {
+		entry := e.Value.(*memoryEntry)
+		if now.Sub(entry.claimedAt) < d.window {
+			return false, nil
+		}
+		// claimed long ago, treat it as a new message
+		entry.claimedAt = now
+		d.order.MoveToFront(e)
+		return true, nil
+	}
+
+	d.entries[id] = d.order.PushFront(&memoryEntry{id: id, claimedAt: now})
+
+	// evict least recently claimed ids
+	for d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*memoryEntry).id)
+	}
+	return true, nil
+}
+
+// postgresDeduplicator stores handled message ids in the database so that
+// they survive restarts and are shared between otp service instances.
+type postgresDeduplicator struct {
+	db     *sql.DB
+	window time.Duration
+}
+
+func newPostgresDeduplicator(db *sql.DB, window time.Duration) *postgresDeduplicator {
+	d := &postgresDeduplicator{db: db, window: window}
+	go d.cleanup()
+	return d
+}
+
+func (d *postgresDeduplicator) claim(id string) (bool, error) {
+	now := time.Now()
+	// insert the id, or take it over if the previous claim is outside the window
+	res, err := d.db.Exec(`INSERT INTO processed_messages (id,processed_at) VALUES ($1,$2)
+		ON CONFLICT (id) DO UPDATE SET processed_at=$2 WHERE processed_messages.processed_at < $3`,
+		id, now, now.Add(-d.window))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// cleanup periodically deletes ids which are older than the window
+func (d *postgresDeduplicator) cleanup() {
+	for range time.Tick(d.window) {
+		_, err := d.db.Exec(`DELETE FROM processed_messages WHERE processed_at < $1`, time.Now().Add(-d.window))
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_memoryDeduplicator_claim(t *testing.T) {
+	d := newMemoryDeduplicator(2, time.Hour)
+
+	claim := func(id string, want bool) {
+		t.Helper()
+		got, err := d.claim(id)
+		if err != nil {
+			t.Fatalf("claim(%s) error = %v", id, err)
+		}
+		if got != want {
+			t.Errorf("claim(%s) got = %v, want %v", id, got, want)
+		}
+	}
+
+	claim("a", true)
+	claim("a", false)
+	claim("b", true)
+
+	// "a" is evicted because cache size is 2
+	claim("c", true)
+	claim("a", true)
+	claim("c", false)
+}
+
+func Test_memoryDeduplicator_window(t *testing.T) {
+	d := newMemoryDeduplicator(10, time.Millisecond*10)
+
+	if ok, _ := d.claim("a"); !ok {
+		t.Fatal("first claim should pass")
+	}
+	if ok, _ := d.claim("a"); ok {
+		t.Fatal("second claim within window should fail")
+	}
+
+	time.Sleep(time.Millisecond * 20)
+	if ok, _ := d.claim("a"); !ok {
+		t.Error("claim after window should pass")
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// deliverEmail delivers the otp and magic link through email
+func (s *Service) deliverEmail(msg otpMessage) {
+	data := newTemplateData(s.config, msg)
+	subject, err := s.templates.render(msg.locale, msg.purpose, templateEmailSubject, data)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	body, err := s.templates.render(msg.locale, msg.purpose, templateEmailBody, data)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	release := s.limit(providerSMTP)
+	err = sendEmail(s.config, subject, body, msg.email)
+	release()
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// sendEmail sends a plain text email through the configured smtp server
+func sendEmail(config utils.Config, subject, body, to string) error {
+	from, err := mail.ParseAddress(config.SMTP.From)
+	if err != nil {
+		return fmt.Errorf("invalid smtp from address: %v", err)
+	}
+
+	var auth smtp.Auth
+	if config.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", config.SMTP.Username, config.SMTP.Password, config.SMTP.Host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	// headers are ascii, subjects of other languages are encoded as RFC 2047 words
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	addr := net.JoinHostPort(config.SMTP.Host, config.SMTP.Port)
+	err = smtp.SendMail(addr, auth, from.Address, []string{to}, msg.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not send email: %v", err)
+	}
+	return nil
+}
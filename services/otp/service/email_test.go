@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bufio"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"net"
+	"strings"
+	"testing"
+)
+
+// startSMTPSink starts a minimal smtp server which accepts every email and sends its data to the returned channel
+func startSMTPSink(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 sink ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 sink")
+			case cmd == "DATA":
+				reply("354 send data")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				received <- data.String()
+				reply("250 ok")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func Test_sendEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+	}{
+		{
+			name:    "should send ascii subject as it is",
+			subject: "Your login code",
+			want:    "Subject: Your login code\r\n",
+		},
+		{
+			name:    "should encode non ascii subject",
+			subject: "Tu código de inicio de sesión",
+			want:    "Subject: =?utf-8?q?Tu_c=C3=B3digo_de_inicio_de_sesi=C3=B3n?=\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, received := startSMTPSink(t)
+			host, port, _ := net.SplitHostPort(addr)
+
+			var config utils.Config
+			config.SMTP.Host = host
+			config.SMTP.Port = port
+			config.SMTP.From = "Flahmingo <no-reply@flahmingo.com>"
+
+			body := "Your one time password is: 123456\nhttp://localhost/login?token=abc\n"
+			err := sendEmail(config, tt.subject, body, "user@example.com")
+			if err != nil {
+				t.Fatalf("sendEmail() error = %v", err)
+			}
+
+			data := <-received
+			for _, want := range []string{"To: user@example.com", tt.want, "MIME-Version: 1.0\r\n",
+				"Content-Type: text/plain; charset=utf-8\r\n", "123456", "http://localhost/login?token=abc"} {
+				if !strings.Contains(data, want) {
+					t.Errorf("sendEmail() sent data %q does not contain %q", data, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"golang.org/x/time/rate"
+	"net"
+	"net/http"
+	"time"
+)
+
+// providers which requests are limited
+const (
+	providerTwilio   = "twilio"
+	providerWhatsApp = "whatsapp"
+	providerSMTP     = "smtp"
+)
+
+// limiter limits the concurrency and rate of requests sent to a provider
+type limiter struct {
+	slots chan struct{}
+	rate  *rate.Limiter
+}
+
+func newLimiter(limit utils.ProviderLimit) *limiter {
+	l := &limiter{}
+	if limit.Concurrency > 0 {
+		l.slots = make(chan struct{}, limit.Concurrency)
+	}
+	if limit.RatePerSecond > 0 {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.rate = rate.NewLimiter(rate.Limit(limit.RatePerSecond), burst)
+	}
+	return l
+}
+
+// acquire waits until a request can be sent. release must be called once the request is done.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	release = func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// newLimiters creates the limiters of all providers. Providers which are not configured are not limited.
+func newLimiters(config utils.Config) map[string]*limiter {
+	limiters := map[string]*limiter{}
+	for _, provider := range []string{providerTwilio, providerWhatsApp, providerSMTP} {
+		limiters[provider] = newLimiter(config.OTP.Limits[provider])
+	}
+	return limiters
+}
+
+// newHTTPClient creates the client used to call providers, with timeouts and a pool of idle connections
+func newHTTPClient(config utils.Config) *http.Client {
+	return &http.Client{
+		Timeout: config.OTP.HTTP.Timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   time.Second * 5,
+				KeepAlive: time.Second * 30,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   config.OTP.HTTP.MaxIdleConnsPerHost,
+			IdleConnTimeout:       config.OTP.HTTP.IdleConnTimeout,
+			TLSHandshakeTimeout:   time.Second * 5,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
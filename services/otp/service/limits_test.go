@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"testing"
+	"time"
+)
+
+func Test_limiter_concurrency(t *testing.T) {
+	l := newLimiter(utils.ProviderLimit{Concurrency: 1})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	// the only slot is taken
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := l.acquire(ctx); err == nil {
+		t.Fatal("acquire() wanted not nil error when concurrency limit is reached")
+	}
+
+	release()
+	release, err = l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	release()
+}
+
+func Test_limiter_rate(t *testing.T) {
+	l := newLimiter(utils.ProviderLimit{RatePerSecond: 20, Burst: 2})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		release, err := l.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+		release()
+	}
+
+	// 2 requests are sent at once, the other 2 wait 50ms each
+	if elapsed := time.Since(start); elapsed < time.Millisecond*90 {
+		t.Errorf("4 requests took %v, want at least 100ms", elapsed)
+	}
+}
+
+func Test_limiter_unlimited(t *testing.T) {
+	l := newLimiter(utils.ProviderLimit{})
+	for i := 0; i < 100; i++ {
+		if _, err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+	}
+}
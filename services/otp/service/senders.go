@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// senderNumber is the kind of senders which are phone numbers, others are short codes and alphanumeric ids
+const senderNumber = "number"
+
+// senderRefresh is how often the sender pool is reloaded from the database
+const senderRefresh = time.Second * 30
+
+// sender is a phone number, short code or alphanumeric id which sms are sent from
+type sender struct {
+	address       string
+	kind          string
+	countries     []string
+	ratePerSecond float64
+}
+
+// senderPool picks the sender of each sms. The pool is kept in the senders table and managed through
+// the admin api of the auth service, senders in config are added to it on startup.
+// Each recipient sticks to the sender picked for them as long as it can send to them.
+type senderPool struct {
+	config utils.Config
+	db     *sql.DB
+
+	mu       sync.Mutex
+	senders  []sender
+	loadedAt time.Time
+	// limiters limit the rate of each sender, carriers throttle senders which send faster
+	limiters map[string]*limiter
+}
+
+func newSenderPool(config utils.Config, db *sql.DB) *senderPool {
+	return &senderPool{config: config, db: db, limiters: map[string]*limiter{}}
+}
+
+// seed adds the senders in config to the pool. Senders which are already in the pool are not changed,
+// so that changes made through the admin api are kept.
+func (p *senderPool) seed() error {
+	now := time.Now()
+	for _, s := range p.config.Twilio.Senders {
+		kind := s.Kind
+		if kind == "" {
+			kind = senderNumber
+		}
+		_, err := p.db.Exec(`INSERT INTO senders (address,kind,countries,rate_per_second,enabled,created_at,updated_at) VALUES ($1,$2,$3,$4,true,$5,$5)
+			ON CONFLICT (address) DO NOTHING`,
+			s.Address, kind, strings.ToUpper(strings.Join(s.Countries, ",")), s.RatePerSecond, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load returns the enabled senders, reloading them if they are older than senderRefresh.
+// The last loaded senders are used if they can not be reloaded.
+func (p *senderPool) load() []sender {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.loadedAt) < senderRefresh {
+		return p.senders
+	}
+
+	rows, err := p.db.Query(`SELECT address,kind,countries,rate_per_second FROM senders WHERE enabled ORDER BY address`)
+	if err != nil {
+		logrus.Errorf("could not load senders: %v", err)
+		return p.senders
+	}
+	defer rows.Close()
+
+	var senders []sender
+	for rows.Next() {
+		var s sender
+		var countries string
+		if err := rows.Scan(&s.address, &s.kind, &countries, &s.ratePerSecond); err != nil {
+			logrus.Errorf("could not load senders: %v", err)
+			return p.senders
+		}
+		if countries != "" {
+			s.countries = strings.Split(countries, ",")
+		}
+		senders = append(senders, s)
+	}
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("could not load senders: %v", err)
+		return p.senders
+	}
+
+	p.senders = senders
+	p.loadedAt = time.Now()
+	return senders
+}
+
+// pick returns the sender of an sms or voice call to the phone number.
+// It falls back to twilio.phoneNumber if no sender in the pool can send to the recipient.
+func (p *senderPool) pick(phoneNumber string, voice bool) sender {
+	candidates := eligibleSenders(p.load(), regionOf(phoneNumber), voice)
+	if len(candidates) == 0 {
+		return sender{address: p.config.Twilio.PhoneNumber, kind: senderNumber, ratePerSecond: p.config.Twilio.PhoneNumberRatePerSecond}
+	}
+
+	var assigned string
+	err := p.db.QueryRow(`SELECT sender FROM sender_assignments WHERE phone_number=$1`, phoneNumber).Scan(&assigned)
+	if err != nil && err != sql.ErrNoRows {
+		logrus.Error(err)
+	}
+	for _, s := range candidates {
+		if s.address == assigned {
+			return s
+		}
+	}
+
+	// the same recipient gets the same sender even if the assignment could not be saved
+	h := fnv.New32a()
+	h.Write([]byte(phoneNumber))
+	picked := candidates[h.Sum32()%uint32(len(candidates))]
+
+	// voice calls can only come from numbers, they do not change the sender of sms
+	if !voice {
+		_, err = p.db.Exec(`INSERT INTO sender_assignments (phone_number,sender,assigned_at) VALUES ($1,$2,$3)
+			ON CONFLICT (phone_number) DO UPDATE SET sender=$2, assigned_at=$3`, phoneNumber, picked.address, time.Now())
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+	return picked
+}
+
+// limit waits until an sms can be sent from the sender and returns the function to call once it is sent
+func (p *senderPool) limit(s sender) func() {
+	// the rate can be changed through the admin api
+	key := fmt.Sprintf("%s/%v", s.address, s.ratePerSecond)
+	p.mu.Lock()
+	l, ok := p.limiters[key]
+	if !ok {
+		l = newLimiter(utils.ProviderLimit{RatePerSecond: s.ratePerSecond})
+		p.limiters[key] = l
+	}
+	p.mu.Unlock()
+
+	release, _ := l.acquire(context.Background())
+	return release
+}
+
+// eligibleSenders returns the senders which can send to the country.
+// Senders of the country are preferred over the ones without countries.
+// Only numbers can make voice calls.
+func eligibleSenders(senders []sender, country string, voice bool) []sender {
+	var local, global []sender
+	for _, s := range senders {
+		if voice && s.kind != senderNumber {
+			continue
+		}
+		if len(s.countries) == 0 {
+			global = append(global, s)
+			continue
+		}
+		for _, c := range s.countries {
+			if c == country {
+				local = append(local, s)
+				break
+			}
+		}
+	}
+	if len(local) > 0 {
+		return local
+	}
+	return global
+}
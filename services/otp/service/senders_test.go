@@ -0,0 +1,58 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_eligibleSenders(t *testing.T) {
+	us := sender{address: "+15005550006", kind: senderNumber, countries: []string{"US", "CA"}}
+	usShortCode := sender{address: "12345", kind: "short_code", countries: []string{"US"}}
+	np := sender{address: "Flahmingo", kind: "alphanumeric", countries: []string{"NP"}}
+	global := sender{address: "+15005550007", kind: senderNumber}
+	senders := []sender{us, usShortCode, np, global}
+
+	tests := []struct {
+		name    string
+		country string
+		voice   bool
+		want    []sender
+	}{
+		{name: "senders of the country are preferred", country: "US", want: []sender{us, usShortCode}},
+		{name: "only numbers make voice calls", country: "US", voice: true, want: []sender{us}},
+		{name: "alphanumeric ids can not make voice calls", country: "NP", voice: true, want: []sender{global}},
+		{name: "countries without senders use global ones", country: "GB", want: []sender{global}},
+		{name: "unknown countries use global ones", country: "", want: []sender{global}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eligibleSenders(senders, tt.country, tt.voice); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("eligibleSenders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := eligibleSenders([]sender{np}, "US", false); len(got) != 0 {
+		t.Errorf("eligibleSenders() = %v, want no senders", got)
+	}
+}
+
+func Test_senderPool_fallbackRate(t *testing.T) {
+	s, _, _ := newTestService(t)
+	s.senders.config.Twilio.PhoneNumberRatePerSecond = 20
+
+	// without senders in the pool the configured number is used, with its own rate
+	from := s.senders.pick("+21234567890", false)
+	if from.address != "+15005550006" || from.ratePerSecond != 20 {
+		t.Fatalf("pick() got = %+v, want twilio.phoneNumber with twilio.phoneNumberRatePerSecond", from)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.senders.limit(from)()
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("limit() let 3 sms through in %v, want at most 20 per second", elapsed)
+	}
+}
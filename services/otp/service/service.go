@@ -0,0 +1,366 @@
+package service
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/bhrg3se/flahmingo-homework/utils/migrations"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// otp delivery channels, same as the ones published by the auth service
+const (
+	channelSMS      = "sms"
+	channelVoice    = "voice"
+	channelEmail    = "email"
+	channelWhatsApp = "whatsapp"
+)
+
+// otpValidity is how long the otp is valid after it is sent, same as in the auth service
+const otpValidity = time.Minute * 5
+
+// Service delivers otp messages published by the auth service
+type Service struct {
+	config    utils.Config
+	templates *messageTemplates
+	dedup     deduplicator
+	tracker   deliveryTracker
+	fallbacks *fallbacks
+	costs     *costs
+	senders   *senderPool
+
+	// client is used to call providers
+	client   *http.Client
+	limiters map[string]*limiter
+	// workers limits the number of messages delivered at the same time
+	workers chan struct{}
+	// fallbacksQueued are fallbacks waiting for a worker or being delivered
+	fallbacksQueued sync.WaitGroup
+}
+
+// otpMessage is the otp message published by the auth service
+type otpMessage struct {
+	id          string
+	otp         string
+	phoneNumber string
+	email       string
+	magicLink   string
+	channel     string
+	purpose     string
+	locale      string
+}
+
+// New creates the otp service. It returns error if message templates are invalid or senders can not be added.
+func New(config utils.Config, db *sql.DB) (*Service, error) {
+	templates, err := loadTemplates(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not load templates: %v", err)
+	}
+
+	senders := newSenderPool(config, db)
+	err = senders.seed()
+	if err != nil {
+		return nil, fmt.Errorf("could not add senders in config: %v", err)
+	}
+
+	workers := config.OTP.Subscriber.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Service{
+		config:    config,
+		templates: templates,
+		dedup:     newDeduplicator(config, db),
+		tracker:   deliveryTracker{db: db},
+		fallbacks: newFallbacks(),
+		costs:     newCosts(config, db),
+		senders:   senders,
+		client:    newHTTPClient(config),
+		limiters:  newLimiters(config),
+		workers:   make(chan struct{}, workers),
+	}, nil
+}
+
+// Start creates the otp service with all its dependencies, starts the webhook server
+// and delivers otp messages until the subscription fails or the process is stopped.
+// On SIGINT or SIGTERM, it stops pulling messages and waits for the ones being delivered.
+func Start(config utils.Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//initialise pub sub client
+	psClient, err := utils.NewPubSubClient(ctx, config)
+	if err != nil {
+		panic(err)
+	}
+	if utils.UsingPubSubEmulator(config) {
+		_, err = utils.CreateVerificationTopic(ctx, psClient)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	db := utils.CreateDBPool(config)
+
+	// the otp service may start before auth, both of them apply migrations one at a time
+	if config.Database.AutoMigrate {
+		if err := utils.WaitForDB(ctx, db, "database"); err != nil {
+			logrus.Fatalf("could not connect to database: %v", err)
+		}
+		migrator, err := migrations.New(db, migrations.Postgres)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if _, err := migrator.Up(ctx); err != nil {
+			logrus.Fatalf("could not migrate database: %v", err)
+		}
+	}
+
+	// fail early if templates are invalid
+	s, err := New(config, db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// receive delivery status webhooks from providers
+	server := &http.Server{Addr: config.OTP.Listen, Handler: s.Handler()}
+	go func() {
+		logrus.Infof("starting http server in %s", config.OTP.Listen)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Fatal(err)
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		logrus.Info("waiting for PubSub messages")
+		done <- s.Receive(ctx, psClient.Subscription(utils.VerificationSubscription))
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-done:
+		if err != nil {
+			logrus.Error(err)
+		}
+	case sig := <-stop:
+		logrus.Infof("received %s, draining messages", sig)
+		cancel()
+		select {
+		case err = <-done:
+			if err != nil {
+				logrus.Error(err)
+			}
+		case <-time.After(config.OTP.Subscriber.ShutdownTimeout):
+			logrus.Warn("messages were not drained within shutdown timeout")
+		}
+	}
+
+	// status webhooks of messages sent while draining may still arrive, but there is no one to wait for them
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// Handler returns the handler of the http server which receives provider webhooks
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/twilio/status", s.twilioStatusCallback)
+	mux.HandleFunc("/twilio/voice-status", s.twilioVoiceStatusCallback)
+	mux.HandleFunc("/whatsapp/webhook", s.whatsAppWebhook)
+	return mux
+}
+
+// Receive delivers otp messages received from the subscription until ctx is done.
+// It returns after the messages being delivered and the fallbacks queued by webhooks are done.
+func (s *Service) Receive(ctx context.Context, sub *pubsub.Subscription) error {
+	if s.config.OTP.Subscriber.MaxOutstandingMessages > 0 {
+		sub.ReceiveSettings.MaxOutstandingMessages = s.config.OTP.Subscriber.MaxOutstandingMessages
+	}
+	if s.config.OTP.Subscriber.NumGoroutines > 0 {
+		sub.ReceiveSettings.NumGoroutines = s.config.OTP.Subscriber.NumGoroutines
+	}
+	err := sub.Receive(ctx, s.handleMessage)
+	s.fallbacksQueued.Wait()
+	return err
+}
+
+// queueFallback delivers the message through another channel once a worker is free, without waiting for it.
+// Webhooks use it so that they are answered right away, and the fallback is limited like any other delivery.
+func (s *Service) queueFallback(deliver func(msg otpMessage), msg otpMessage) {
+	s.fallbacksQueued.Add(1)
+	go func() {
+		defer s.fallbacksQueued.Done()
+		s.workers <- struct{}{}
+		defer func() { <-s.workers }()
+		deliver(msg)
+	}()
+}
+
+// limit waits until a request can be sent to the provider and returns the function to call once it is done.
+// It does not give up on shutdown, so that messages which are already being delivered are completed.
+func (s *Service) limit(provider string) func() {
+	release, _ := s.limiters[provider].acquire(context.Background())
+	return release
+}
+
+// handleMessage sends the otp in the received pubsub message
+func (s *Service) handleMessage(ctx context.Context, message *pubsub.Message) {
+	msg := parseMessage(message)
+
+	// wait for a free worker, messages which are not being delivered yet are redelivered after shutdown
+	select {
+	case s.workers <- struct{}{}:
+		defer func() { <-s.workers }()
+	case <-ctx.Done():
+		message.Nack()
+		return
+	}
+
+	// skip messages which were already delivered
+	claimed, err := s.dedup.claim(msg.id)
+	if err != nil {
+		// better to risk a duplicate sms than to not send the otp at all
+		logrus.Error(err)
+	} else if !claimed {
+		logrus.Infof("skipping duplicate message %s", msg.id)
+		message.Ack()
+		return
+	}
+
+	switch msg.channel {
+	case channelVoice:
+		s.deliverVoice(msg)
+	case channelWhatsApp:
+		s.deliverWhatsApp(msg)
+	case channelEmail:
+		s.deliverEmail(msg)
+	default:
+		s.deliverSMS(msg)
+	}
+	message.Ack()
+}
+
+// parseMessage reads the otp message from pubsub message attributes.
+// Messages published without an id fall back to the pubsub message id.
+func parseMessage(message *pubsub.Message) otpMessage {
+	msg := otpMessage{
+		id:          message.Attributes["MESSAGE_ID"],
+		otp:         message.Attributes["OTP"],
+		phoneNumber: message.Attributes["PHONE_NUMBER"],
+		email:       message.Attributes["EMAIL"],
+		magicLink:   message.Attributes["MAGIC_LINK"],
+		channel:     message.Attributes["CHANNEL"],
+		purpose:     message.Attributes["PURPOSE"],
+		locale:      message.Attributes["LOCALE"],
+	}
+	if msg.id == "" {
+		msg.id = message.ID
+	}
+	return msg
+}
+
+// deliverSMS delivers the otp through sms and records its delivery status.
+// It falls back to a voice call if the sms fails and voice fallback is enabled.
+func (s *Service) deliverSMS(msg otpMessage) {
+	body, err := s.templates.render(msg.locale, msg.purpose, templateSMS, newTemplateData(s.config, msg))
+	if err != nil {
+		logrus.Error(err)
+		s.tracker.failed(msg.id, channelSMS, "twilio", "")
+		return
+	}
+
+	// charge the sms to the budget of the destination country before sending it
+	cost := s.costs.estimate(msg.phoneNumber)
+	err = s.costs.reserve(cost)
+	if err == errBudgetExceeded {
+		logrus.Warnf("not sending sms for message %s to %s: %v", msg.id, cost.country, err)
+		s.tracker.failed(msg.id, channelSMS, "twilio", errorBudgetExceeded)
+		return
+	}
+	if err != nil {
+		// the spend is not tracked, but the otp is still sent
+		logrus.Error(err)
+	}
+
+	//send receive message through twilio sms api
+	from := s.senders.pick(msg.phoneNumber, false)
+	releaseSender := s.senders.limit(from)
+	release := s.limit(providerTwilio)
+	sent, err := sendSMS(s.client, s.config, from.address, body, msg.phoneNumber)
+	release()
+	releaseSender()
+	if err != nil {
+		logrus.Error(err)
+		s.costs.release(cost)
+		s.tracker.failed(msg.id, channelSMS, "twilio", "")
+		// notifications have no otp to read out in a voice call
+		if s.config.OTP.VoiceFallback && !notificationPurposes[msg.purpose] {
+			logrus.Infof("could not send sms for message %s, falling back to voice call", msg.id)
+			s.deliverVoice(msg)
+		}
+		return
+	}
+
+	s.tracker.sent(msg.id, channelSMS, "twilio", sent.SID)
+	price, ok := sent.price(cost.currency)
+	s.costs.record(msg.id, cost, price, ok)
+	// keep the otp until the sms is delivered, in case it has to be resent through voice call
+	if s.config.OTP.VoiceFallback && sent.SID != "" && !notificationPurposes[msg.purpose] {
+		s.fallbacks.add(sent.SID, pendingOTP{msg: msg, expiry: time.Now().Add(otpValidity)})
+	}
+}
+
+// sendSMS sends SMS using Twilio's API and returns the created message
+func sendSMS(client *http.Client, config utils.Config, from, msg, phoneNumber string) (twilioMessage, error) {
+
+	v := url.Values{}
+	v.Set("To", phoneNumber)
+	v.Set("From", from)
+	v.Set("Body", msg)
+	if config.OTP.StatusCallbackURL != "" {
+		v.Set("StatusCallback", config.OTP.StatusCallbackURL)
+	}
+	rb := *strings.NewReader(v.Encode())
+	urlStr := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", config.Twilio.BaseURL, config.Twilio.AccountSID)
+
+	req, _ := http.NewRequest("POST", urlStr, &rb)
+	req.SetBasicAuth(config.Twilio.AccountSID, config.Twilio.AuthToken)
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Make request
+	resp, err := client.Do(req)
+	if err != nil {
+		return twilioMessage{}, fmt.Errorf("could not send sms: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return twilioMessage{}, fmt.Errorf("got failed response from twilio: %s", resp.Status)
+	}
+
+	var message twilioMessage
+	err = json.NewDecoder(resp.Body).Decode(&message)
+	if err != nil {
+		// the sms is already sent, only its status cannot be tracked
+		logrus.Warnf("could not decode twilio response: %v", err)
+	}
+	return message, nil
+}
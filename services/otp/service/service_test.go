@@ -0,0 +1,39 @@
+package service
+
+import (
+	"github.com/bhrg3se/flahmingo-homework/services/fakesms/gateway"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_sendSMS(t *testing.T) {
+	g := gateway.New("someToken")
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	var config utils.Config
+	config.Twilio.BaseURL = server.URL
+	config.Twilio.AccountSID = "AC123"
+	config.Twilio.AuthToken = "someToken"
+	config.Twilio.PhoneNumber = "+15005550006"
+
+	sent, err := sendSMS(http.DefaultClient, config, config.Twilio.PhoneNumber, "123456 is your code", "+21234567890")
+	if err != nil {
+		t.Fatalf("sendSMS() error = %v", err)
+	}
+
+	messages := g.Messages("+21234567890")
+	if len(messages) != 1 {
+		t.Fatalf("gateway got %d messages, want 1", len(messages))
+	}
+	if messages[0].SID != sent.SID || messages[0].Body != "123456 is your code" || messages[0].From != "+15005550006" {
+		t.Errorf("gateway got message %v, sid %v", messages[0], sent.SID)
+	}
+
+	config.Twilio.BaseURL = server.URL + "/invalid"
+	if _, err := sendSMS(http.DefaultClient, config, config.Twilio.PhoneNumber, "123456 is your code", "+21234567890"); err == nil {
+		t.Error("sendSMS() wanted not nil error for failed response")
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// delivery statuses, same as the ones read by the auth service
+const (
+	deliverySent      = "sent"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// deliveryTracker records the delivery status of otp messages in the deliveries table
+type deliveryTracker struct {
+	db *sql.DB
+}
+
+// sent records that the message was accepted by the provider
+func (t deliveryTracker) sent(messageID, channel, provider, providerSID string) {
+	_, err := t.db.Exec(`UPDATE deliveries SET status=$1, channel=$2, provider=$3, provider_sid=$4, updated_at=$5 WHERE message_id=$6`,
+		deliverySent, channel, provider, providerSID, time.Now(), messageID)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// failed records that the message could not be sent
+func (t deliveryTracker) failed(messageID, channel, provider, errorCode string) {
+	_, err := t.db.Exec(`UPDATE deliveries SET status=$1, channel=$2, provider=$3, error_code=$4, updated_at=$5 WHERE message_id=$6`,
+		deliveryFailed, channel, provider, errorCode, time.Now(), messageID)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// providerStatus records a status reported by the provider for an already sent message
+func (t deliveryTracker) providerStatus(provider, providerSID, status, errorCode string) error {
+	_, err := t.db.Exec(`UPDATE deliveries SET status=$1, error_code=$2, updated_at=$3 WHERE provider=$4 AND provider_sid=$5`,
+		status, errorCode, time.Now(), provider, providerSID)
+	return err
+}
+
+// twilioStatusCallback receives message status updates from twilio.
+// See https://www.twilio.com/docs/sms/api/message-resource#twilios-request-to-the-statuscallback-url
+func (s *Service) twilioStatusCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !validTwilioSignature(s.config.Twilio.AuthToken, s.config.OTP.StatusCallbackURL, r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		logrus.Warn("received twilio status callback with invalid signature")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	sid := r.PostForm.Get("MessageSid")
+	status := twilioStatus(r.PostForm.Get("MessageStatus"))
+	if sid == "" || status == "" {
+		// intermediate statuses like "sending" are not tracked
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err := s.tracker.providerStatus("twilio", sid, status, r.PostForm.Get("ErrorCode"))
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the sms reached a final state, retry through a voice call if it failed
+	if status != deliverySent {
+		pending, ok := s.fallbacks.remove(sid)
+		if ok && status == deliveryFailed {
+			logrus.Infof("sms %s failed, falling back to voice call", sid)
+			s.queueFallback(s.deliverVoice, pending.msg)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// twilioStatus maps twilio message status to delivery status.
+// It returns empty string for statuses which are not tracked.
+func twilioStatus(status string) string {
+	switch status {
+	case "sent":
+		return deliverySent
+	case "delivered":
+		return deliveryDelivered
+	case "failed", "undelivered":
+		return deliveryFailed
+	}
+	return ""
+}
+
+// validTwilioSignature checks the X-Twilio-Signature header of a webhook request.
+// See https://www.twilio.com/docs/usage/security#validating-requests
+func validTwilioSignature(authToken, callbackURL string, params url.Values, signature string) bool {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	data := callbackURL
+	for _, k := range keys {
+		for _, v := range params[k] {
+			data += k + v
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"github.com/bhrg3se/flahmingo-homework/services/fakesms/gateway"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/bhrg3se/flahmingo-homework/utils/migrations"
+	_ "github.com/mattn/go-sqlite3"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestService creates the service on a migrated sqlite database, with twilio calls sent to a fake gateway
+func newTestService(t *testing.T) (*Service, *sql.DB, *gateway.Gateway) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "otp.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrations.New(db, migrations.SQLite)
+	if err == nil {
+		_, err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	g := gateway.New("someToken")
+	server := httptest.NewServer(g.Handler())
+	t.Cleanup(server.Close)
+
+	var config utils.Config
+	config.Twilio.BaseURL = server.URL
+	config.Twilio.AccountSID = "AC123"
+	config.Twilio.AuthToken = "someToken"
+	config.Twilio.PhoneNumber = "+15005550006"
+	config.OTP.StatusCallbackURL = "https://otp.example.com/twilio/status"
+	config.OTP.Templates.DefaultLocale = "en"
+
+	s, err := New(config, db)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s, db, g
+}
+
+// twilioSignature signs webhook params like twilio does
+func twilioSignature(authToken, callbackURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	data := callbackURL
+	for _, k := range keys {
+		data += k + params.Get(k)
+	}
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func Test_twilioStatusCallback(t *testing.T) {
+	const sid = "SM00000000000000000000000000000001"
+	tests := []struct {
+		name       string
+		method     string
+		params     url.Values
+		signature  string
+		fallback   bool
+		wantCode   int
+		wantStatus string
+		wantError  string
+		wantCalls  int
+	}{
+		{
+			name:       "should reject requests without signature",
+			params:     url.Values{"MessageSid": {sid}, "MessageStatus": {"delivered"}},
+			signature:  "-",
+			wantCode:   http.StatusForbidden,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should reject requests with invalid signature",
+			params:     url.Values{"MessageSid": {sid}, "MessageStatus": {"delivered"}},
+			signature:  twilioSignature("otherToken", "https://otp.example.com/twilio/status", url.Values{"MessageSid": {sid}, "MessageStatus": {"delivered"}}),
+			wantCode:   http.StatusForbidden,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should reject other methods",
+			method:     http.MethodGet,
+			wantCode:   http.StatusMethodNotAllowed,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should not track intermediate statuses",
+			params:     url.Values{"MessageSid": {sid}, "MessageStatus": {"sending"}},
+			wantCode:   http.StatusNoContent,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should record delivered sms",
+			params:     url.Values{"MessageSid": {sid}, "MessageStatus": {"delivered"}},
+			fallback:   true,
+			wantCode:   http.StatusNoContent,
+			wantStatus: deliveryDelivered,
+		},
+		{
+			name:       "should record failed sms without fallback",
+			params:     url.Values{"MessageSid": {sid}, "MessageStatus": {"undelivered"}, "ErrorCode": {"30003"}},
+			wantCode:   http.StatusNoContent,
+			wantStatus: deliveryFailed,
+			wantError:  "30003",
+		},
+		{
+			name:       "should fall back to voice call for failed sms",
+			params:     url.Values{"MessageSid": {sid}, "MessageStatus": {"failed"}, "ErrorCode": {"30005"}},
+			fallback:   true,
+			wantCode:   http.StatusNoContent,
+			wantStatus: deliverySent,
+			wantError:  "30005",
+			wantCalls:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, g := newTestService(t)
+			now := time.Now()
+			_, err := db.Exec(`INSERT INTO deliveries (message_id,phone_number,channel,provider,provider_sid,status,created_at,updated_at)
+				VALUES ('messageID','+21234567890','sms','twilio',$1,'sent',$2,$2)`, sid, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fallback {
+				msg := otpMessage{id: "messageID", otp: "123456", phoneNumber: "+21234567890", channel: channelSMS}
+				s.fallbacks.add(sid, pendingOTP{msg: msg, expiry: now.Add(time.Minute)})
+			}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, "/twilio/status", strings.NewReader(tt.params.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			switch tt.signature {
+			case "":
+				r.Header.Set("X-Twilio-Signature", twilioSignature("someToken", s.config.OTP.StatusCallbackURL, tt.params))
+			case "-":
+			default:
+				r.Header.Set("X-Twilio-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			// the fallback is delivered by a worker after the webhook is answered
+			s.fallbacksQueued.Wait()
+
+			if w.Code != tt.wantCode {
+				t.Errorf("twilioStatusCallback() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			var status, errorCode string
+			if err := db.QueryRow(`SELECT status,error_code FROM deliveries WHERE message_id='messageID'`).Scan(&status, &errorCode); err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus || errorCode != tt.wantError {
+				t.Errorf("twilioStatusCallback() delivery = %v %q, want %v %q", status, errorCode, tt.wantStatus, tt.wantError)
+			}
+			if calls := g.Calls("+21234567890"); len(calls) != tt.wantCalls {
+				t.Errorf("twilioStatusCallback() made %d calls, want %d", len(calls), tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// kinds of templates. A template named "<purpose>.<kind>", like "login.sms",
+// is used instead of the "<kind>" template for messages with that purpose.
+const (
+	templateSMS           = "sms"
+	templateVoice         = "voice"
+	templateVoiceLanguage = "voice_language"
+	templateEmailSubject  = "email_subject"
+	templateEmailBody     = "email_body"
+)
+
+// purposes of messages which carry no otp
+const (
+	// purposeNewLogin is the purpose of alerts of logins from new devices
+	purposeNewLogin = "new_login"
+	// purposeEmailVerification is the purpose of emails with a link to confirm the email
+	purposeEmailVerification = "email_verification"
+)
+
+// notificationPurposes are purposes of messages which carry no otp, like alerts
+var notificationPurposes = map[string]bool{purposeNewLogin: true, purposeEmailVerification: true}
+
+// requiredTemplates must be defined in the default locale
+var requiredTemplates = []string{templateSMS, templateVoice, templateEmailSubject, templateEmailBody}
+
+// autofillTemplate adds the lines which let phones read the otp from sms.
+// Android SMS Retriever API needs the app hash in the message, and iOS/web one time code
+// autofill needs "@domain #otp" as the last line.
+const autofillTemplate = `{{define "autofill"}}{{if .AppHash}}
+
+{{.AppHash}}{{end}}{{if .Domain}}
+
+@{{.Domain}} #{{.OTP}}{{end}}{{end}}`
+
+// defaultTemplates are the built in templates for each locale
+var defaultTemplates = map[string]map[string]string{
+	"en": {
+		templateSMS:           `{{.OTP}} is your Flahmingo verification code.{{template "autofill" .}}`,
+		"login.sms":           `{{.OTP}} is your Flahmingo login code. Do not share it with anyone.{{template "autofill" .}}`,
+		"phone_change.sms":    `{{.OTP}} is your code to change the phone number of your Flahmingo account. Ignore it if you did not ask for it.{{template "autofill" .}}`,
+		"account_restore.sms": `{{.OTP}} is your code to restore your deleted Flahmingo account. Ignore it if you did not ask for it.{{template "autofill" .}}`,
+		"new_login.sms":       `Your Flahmingo account was logged in from a new device. If it was not you, contact support right away.`,
+		templateVoice:         `Your Flahmingo code is. {{.Digits}}.`,
+		templateVoiceLanguage: `en-US`,
+		templateEmailSubject:  `Your Flahmingo login code`,
+		templateEmailBody: `Your one time password is: {{.OTP}}
+
+Or log in by opening this link:
+{{.MagicLink}}
+
+The link can be used only once.
+`,
+		"email_verification.email_subject": `Confirm your email for Flahmingo`,
+		"email_verification.email_body": `Confirm that this is the email of your Flahmingo account by opening this link:
+{{.MagicLink}}
+
+Ignore this email if you did not ask for it.
+`,
+	},
+	"es": {
+		templateSMS:           `{{.OTP}} es tu código de verificación de Flahmingo.{{template "autofill" .}}`,
+		"login.sms":           `{{.OTP}} es tu código de inicio de sesión de Flahmingo. No lo compartas con nadie.{{template "autofill" .}}`,
+		"phone_change.sms":    `{{.OTP}} es tu código para cambiar el número de teléfono de tu cuenta de Flahmingo. Si no lo pediste, ignora este mensaje.{{template "autofill" .}}`,
+		"account_restore.sms": `{{.OTP}} es tu código para recuperar tu cuenta eliminada de Flahmingo. Si no lo pediste, ignora este mensaje.{{template "autofill" .}}`,
+		"new_login.sms":       `Se inició sesión en tu cuenta de Flahmingo desde un dispositivo nuevo. Si no fuiste tú, contacta con soporte de inmediato.`,
+		templateVoice:         `Tu código de Flahmingo es. {{.Digits}}.`,
+		templateVoiceLanguage: `es-ES`,
+		templateEmailSubject:  `Tu código de inicio de sesión de Flahmingo`,
+		templateEmailBody: `Tu contraseña de un solo uso es: {{.OTP}}
+
+O inicia sesión abriendo este enlace:
+{{.MagicLink}}
+
+El enlace solo se puede usar una vez.
+`,
+		"email_verification.email_subject": `Confirma tu correo de Flahmingo`,
+		"email_verification.email_body": `Confirma que este es el correo de tu cuenta de Flahmingo abriendo este enlace:
+{{.MagicLink}}
+
+Si no lo pediste, ignora este correo.
+`,
+	},
+}
+
+// templateData is the data available in templates
+type templateData struct {
+	OTP string
+	// Digits are the digits of the otp separated by pauses, for text to speech
+	Digits    string
+	MagicLink string
+	AppHash   string
+	Domain    string
+}
+
+func newTemplateData(config utils.Config, msg otpMessage) templateData {
+	return templateData{
+		OTP:       msg.otp,
+		Digits:    strings.Join(strings.Split(msg.otp, ""), ". "),
+		MagicLink: msg.magicLink,
+		AppHash:   config.OTP.SMS.AppHash,
+		Domain:    config.OTP.SMS.Domain,
+	}
+}
+
+// messageTemplates renders otp messages in the locale of the user
+type messageTemplates struct {
+	defaultLocale string
+	// templates by locale and name
+	templates map[string]map[string]*template.Template
+}
+
+// loadTemplates parses the built in templates and the ones in the configured directory, then validates them
+func loadTemplates(config utils.Config) (*messageTemplates, error) {
+	sources := map[string]map[string]string{}
+	for locale, templates := range defaultTemplates {
+		sources[locale] = map[string]string{}
+		for name, text := range templates {
+			sources[locale][name] = text
+		}
+	}
+
+	if config.OTP.Templates.Dir != "" {
+		err := readTemplateDir(config.OTP.Templates.Dir, sources)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := &messageTemplates{
+		defaultLocale: normalizeLocale(config.OTP.Templates.DefaultLocale),
+		templates:     map[string]map[string]*template.Template{},
+	}
+	for locale, templates := range sources {
+		locale = normalizeLocale(locale)
+		if t.templates[locale] == nil {
+			t.templates[locale] = map[string]*template.Template{}
+		}
+		for name, text := range templates {
+			tmpl, err := template.New(name).Parse(autofillTemplate)
+			if err == nil {
+				tmpl, err = tmpl.Parse(text)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid template %s/%s: %v", locale, name, err)
+			}
+			t.templates[locale][name] = tmpl
+		}
+	}
+
+	return t, t.validate()
+}
+
+// readTemplateDir reads <dir>/<locale>/<name>.tmpl files into sources
+func readTemplateDir(dir string, sources map[string]map[string]string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		locale := filepath.Base(filepath.Dir(file))
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		if sources[locale] == nil {
+			sources[locale] = map[string]string{}
+		}
+		sources[locale][name] = string(text)
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("could not read templates: %v", err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the default locale has all required templates,
+// and that every template renders and contains the otp where it is needed, which is not in notifications
+func (t *messageTemplates) validate() error {
+	if t.templates[t.defaultLocale] == nil {
+		return fmt.Errorf("no templates for default locale %s", t.defaultLocale)
+	}
+	for _, name := range requiredTemplates {
+		if t.templates[t.defaultLocale][name] == nil {
+			return fmt.Errorf("template %s is missing in default locale %s", name, t.defaultLocale)
+		}
+	}
+
+	sample := templateData{OTP: "123456", Digits: "1. 2. 3. 4. 5. 6", MagicLink: "https://example.com", AppHash: "FA+9qCX9VSu", Domain: "example.com"}
+	for locale, templates := range t.templates {
+		for name, tmpl := range templates {
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, sample); err != nil {
+				return fmt.Errorf("invalid template %s/%s: %v", locale, name, err)
+			}
+
+			purpose, kind := "", name
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				purpose, kind = name[:i], name[i+1:]
+			}
+			if notificationPurposes[purpose] {
+				continue
+			}
+			if kind == templateSMS || kind == templateEmailBody {
+				if !strings.Contains(out.String(), sample.OTP) {
+					return fmt.Errorf("template %s/%s does not contain the otp", locale, name)
+				}
+			}
+			if kind == templateVoice && !strings.Contains(out.String(), sample.Digits) {
+				return fmt.Errorf("template %s/%s does not contain the otp digits", locale, name)
+			}
+		}
+	}
+	return nil
+}
+
+// render renders the template of the given kind for the purpose, in the best matching locale.
+// It looks up "pt-br", then "pt", then the default locale, and in each one the purpose specific template first.
+func (t *messageTemplates) render(locale, purpose, kind string, data templateData) (string, error) {
+	for _, l := range t.localeCandidates(locale) {
+		templates := t.templates[l]
+		tmpl := templates[purpose+"."+kind]
+		if tmpl == nil {
+			tmpl = templates[kind]
+		}
+		if tmpl == nil {
+			continue
+		}
+
+		var out bytes.Buffer
+		err := tmpl.Execute(&out, data)
+		return out.String(), err
+	}
+	return "", fmt.Errorf("template %s not found", kind)
+}
+
+// localeCandidates returns the locales to look up for the given locale, most specific first
+func (t *messageTemplates) localeCandidates(locale string) []string {
+	locale = normalizeLocale(locale)
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if i := strings.Index(locale, "-"); i > 0 {
+			candidates = append(candidates, locale[:i])
+		}
+	}
+	return append(candidates, t.defaultLocale)
+}
+
+// normalizeLocale turns locales like "pt_BR" into "pt-br"
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
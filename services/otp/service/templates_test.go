@@ -0,0 +1,134 @@
+package service
+
+import (
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_messageTemplates_render(t *testing.T) {
+	var config utils.Config
+	config.OTP.Templates.DefaultLocale = "en"
+	templates, err := loadTemplates(config)
+	if err != nil {
+		t.Fatalf("loadTemplates() error = %v", err)
+	}
+
+	data := templateData{OTP: "123456", AppHash: "FA+9qCX9VSu", Domain: "flahmingo.com"}
+	tests := []struct {
+		name    string
+		locale  string
+		purpose string
+		kind    string
+		want    string
+	}{
+		{
+			name: "should render default template",
+			kind: templateSMS,
+			want: "123456 is your Flahmingo verification code.\n\nFA+9qCX9VSu\n\n@flahmingo.com #123456",
+		},
+		{
+			name:    "should render purpose specific template",
+			purpose: "login",
+			kind:    templateSMS,
+			want:    "123456 is your Flahmingo login code. Do not share it with anyone.\n\nFA+9qCX9VSu\n\n@flahmingo.com #123456",
+		},
+		{
+			name:   "should fall back to language of the locale",
+			locale: "es_MX",
+			kind:   templateSMS,
+			want:   "123456 es tu código de verificación de Flahmingo.\n\nFA+9qCX9VSu\n\n@flahmingo.com #123456",
+		},
+		{
+			name:    "should render notifications without otp",
+			locale:  "es",
+			purpose: purposeNewLogin,
+			kind:    templateSMS,
+			want:    "Se inició sesión en tu cuenta de Flahmingo desde un dispositivo nuevo. Si no fuiste tú, contacta con soporte de inmediato.",
+		},
+		{
+			name:    "should render email verification link",
+			purpose: purposeEmailVerification,
+			kind:    templateEmailSubject,
+			want:    "Confirm your email for Flahmingo",
+		},
+		{
+			name:   "should fall back to default locale",
+			locale: "xx",
+			kind:   templateEmailSubject,
+			want:   "Your Flahmingo login code",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := templates.render(tt.locale, tt.purpose, tt.kind, data)
+			if err != nil {
+				t.Fatalf("render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("render() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadTemplates_validation(t *testing.T) {
+	writeTemplate := func(t *testing.T, locale, name, text string) string {
+		dir, err := ioutil.TempDir("", "templates")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		os.MkdirAll(filepath.Join(dir, locale), 0755)
+		ioutil.WriteFile(filepath.Join(dir, locale, name+".tmpl"), []byte(text), 0644)
+		return dir
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr string
+	}{
+		{
+			name:    "should fail when template does not parse",
+			dir:     writeTemplate(t, "fr", "sms", "{{.OTP} est votre code"),
+			wantErr: "invalid template fr/sms",
+		},
+		{
+			name:    "should fail when template uses unknown field",
+			dir:     writeTemplate(t, "fr", "sms", "{{.Code}} est votre code"),
+			wantErr: "invalid template fr/sms",
+		},
+		{
+			name:    "should fail when sms does not contain otp",
+			dir:     writeTemplate(t, "fr", "login.sms", "Voici votre code"),
+			wantErr: "does not contain the otp",
+		},
+		{
+			name: "should load notification without otp",
+			dir:  writeTemplate(t, "fr", "new_login.sms", "Nouvelle connexion à votre compte Flahmingo"),
+		},
+		{
+			name: "should load valid template",
+			dir:  writeTemplate(t, "fr", "sms", "{{.OTP}} est votre code Flahmingo.{{template \"autofill\" .}}"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config utils.Config
+			config.OTP.Templates.DefaultLocale = "en"
+			config.OTP.Templates.Dir = tt.dir
+
+			_, err := loadTemplates(config)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("loadTemplates() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("loadTemplates() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// voiceTwiML returns the TwiML which says the text twice
+func voiceTwiML(text, language string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+
+	var say string
+	if language != "" {
+		say = fmt.Sprintf(`<Say language="%s">%s</Say>`, language, escaped.String())
+	} else {
+		say = fmt.Sprintf("<Say>%s</Say>", escaped.String())
+	}
+	return fmt.Sprintf(`<Response>%s<Pause length="1"/>%s</Response>`, say, say)
+}
+
+// makeCall calls the phone number using Twilio's API and returns the sid of the created call
+func makeCall(client *http.Client, config utils.Config, from, twiml, phoneNumber string) (string, error) {
+
+	v := url.Values{}
+	v.Set("To", phoneNumber)
+	v.Set("From", from)
+	v.Set("Twiml", twiml)
+	if config.OTP.StatusCallbackURL != "" {
+		v.Set("StatusCallback", voiceStatusCallbackURL(config))
+	}
+	rb := *strings.NewReader(v.Encode())
+	urlStr := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", config.Twilio.BaseURL, config.Twilio.AccountSID)
+
+	req, _ := http.NewRequest("POST", urlStr, &rb)
+	req.SetBasicAuth(config.Twilio.AccountSID, config.Twilio.AuthToken)
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Make request
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not make call: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("got failed response from twilio: %s", resp.Status)
+	}
+
+	var call struct {
+		SID string `json:"sid"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&call)
+	if err != nil {
+		// the call is already placed, only its status cannot be tracked
+		logrus.Warnf("could not decode twilio response: %v", err)
+	}
+	return call.SID, nil
+}
+
+// voiceStatusCallbackURL returns the public url of the call status webhook.
+// It is served next to the sms status webhook.
+func voiceStatusCallbackURL(config utils.Config) string {
+	return strings.TrimSuffix(config.OTP.StatusCallbackURL, "/status") + "/voice-status"
+}
+
+// twilioCallStatus maps twilio call status to delivery status.
+// It returns empty string for statuses which are not tracked.
+func twilioCallStatus(status string) string {
+	switch status {
+	case "in-progress":
+		return deliverySent
+	case "completed":
+		return deliveryDelivered
+	case "busy", "no-answer", "failed", "canceled":
+		return deliveryFailed
+	}
+	return ""
+}
+
+// pendingOTP is an otp sent through sms or whatsapp which may still fall back to another channel
+type pendingOTP struct {
+	msg    otpMessage
+	expiry time.Time
+}
+
+// fallbacks remembers otps which have not been delivered yet, so that they can be
+// resent through another channel when the provider reports them as failed.
+// The otp is only kept in memory, so only the instance which sent the otp can fall back.
+type fallbacks struct {
+	mu      sync.Mutex
+	pending map[string]pendingOTP
+}
+
+func newFallbacks() *fallbacks {
+	return &fallbacks{pending: map[string]pendingOTP{}}
+}
+
+// add remembers the otp sent with the given provider sid until the otp expires
+func (f *fallbacks) add(sid string, otp pendingOTP) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// forget otps which have expired
+	now := time.Now()
+	for k, v := range f.pending {
+		if now.After(v.expiry) {
+			delete(f.pending, k)
+		}
+	}
+	f.pending[sid] = otp
+}
+
+// remove forgets the otp sent with the given provider sid and returns it if it has not expired
+func (f *fallbacks) remove(sid string) (pendingOTP, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	otp, ok := f.pending[sid]
+	delete(f.pending, sid)
+	if !ok || time.Now().After(otp.expiry) {
+		return pendingOTP{}, false
+	}
+	return otp, true
+}
+
+// deliverVoice delivers the otp through a voice call which reads it out digit by digit, and records its delivery status
+func (s *Service) deliverVoice(msg otpMessage) {
+	data := newTemplateData(s.config, msg)
+	text, err := s.templates.render(msg.locale, msg.purpose, templateVoice, data)
+	if err != nil {
+		logrus.Error(err)
+		s.tracker.failed(msg.id, channelVoice, "twilio", "")
+		return
+	}
+	// the language is optional, twilio uses english without it
+	language, _ := s.templates.render(msg.locale, msg.purpose, templateVoiceLanguage, data)
+
+	from := s.senders.pick(msg.phoneNumber, true)
+	release := s.limit(providerTwilio)
+	sid, err := makeCall(s.client, s.config, from.address, voiceTwiML(text, strings.TrimSpace(language)), msg.phoneNumber)
+	release()
+	if err != nil {
+		logrus.Error(err)
+		s.tracker.failed(msg.id, channelVoice, "twilio", "")
+		return
+	}
+	s.tracker.sent(msg.id, channelVoice, "twilio", sid)
+}
+
+// twilioVoiceStatusCallback receives call status updates from twilio.
+// See https://www.twilio.com/docs/voice/api/call-resource#statuscallback
+func (s *Service) twilioVoiceStatusCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !validTwilioSignature(s.config.Twilio.AuthToken, voiceStatusCallbackURL(s.config), r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		logrus.Warn("received twilio voice status callback with invalid signature")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	sid := r.PostForm.Get("CallSid")
+	status := twilioCallStatus(r.PostForm.Get("CallStatus"))
+	if sid == "" || status == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err := s.tracker.providerStatus("twilio", sid, status, r.PostForm.Get("ErrorCode"))
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_voiceTwiML(t *testing.T) {
+	got := voiceTwiML("Your code is. 1. 2. 3.", "en-US")
+	want := `<Response><Say language="en-US">Your code is. 1. 2. 3.</Say><Pause length="1"/><Say language="en-US">Your code is. 1. 2. 3.</Say></Response>`
+	if got != want {
+		t.Errorf("voiceTwiML() got = %v, want %v", got, want)
+	}
+
+	got = voiceTwiML("Tom & Jerry", "")
+	want = `<Response><Say>Tom &amp; Jerry</Say><Pause length="1"/><Say>Tom &amp; Jerry</Say></Response>`
+	if got != want {
+		t.Errorf("voiceTwiML() got = %v, want %v", got, want)
+	}
+}
+
+func Test_fallbacks(t *testing.T) {
+	f := newFallbacks()
+	f.add("SM1", pendingOTP{msg: otpMessage{id: "1", otp: "123456"}, expiry: time.Now().Add(time.Minute)})
+	f.add("SM2", pendingOTP{msg: otpMessage{id: "2", otp: "654321"}, expiry: time.Now().Add(-time.Minute)})
+
+	pending, ok := f.remove("SM1")
+	if !ok || pending.msg.id != "1" {
+		t.Errorf("remove(SM1) got = %v, %v", pending, ok)
+	}
+	if _, ok := f.remove("SM1"); ok {
+		t.Error("remove(SM1) should not return already removed otp")
+	}
+	if _, ok := f.remove("SM2"); ok {
+		t.Error("remove(SM2) should not return expired otp")
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// whatsAppTemplateMessage is the request body of a template message.
+// See https://developers.facebook.com/docs/whatsapp/cloud-api/reference/messages
+type whatsAppTemplateMessage struct {
+	MessagingProduct string `json:"messaging_product"`
+	To               string `json:"to"`
+	Type             string `json:"type"`
+	Template         struct {
+		Name     string `json:"name"`
+		Language struct {
+			Code string `json:"code"`
+		} `json:"language"`
+		Components []whatsAppComponent `json:"components"`
+	} `json:"template"`
+}
+
+type whatsAppComponent struct {
+	Type       string              `json:"type"`
+	SubType    string              `json:"sub_type,omitempty"`
+	Index      string              `json:"index,omitempty"`
+	Parameters []whatsAppParameter `json:"parameters"`
+}
+
+type whatsAppParameter struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// newWhatsAppOTPMessage creates a message of the configured authentication template in the language for the locale.
+// Authentication templates take the otp as body parameter and as parameter of the copy code button.
+func newWhatsAppOTPMessage(config utils.Config, otp, phoneNumber, locale string) whatsAppTemplateMessage {
+	var msg whatsAppTemplateMessage
+	msg.MessagingProduct = "whatsapp"
+	msg.To = strings.TrimPrefix(phoneNumber, "+")
+	msg.Type = "template"
+	msg.Template.Name = config.WhatsApp.TemplateName
+	msg.Template.Language.Code = whatsAppLanguage(config, locale)
+	msg.Template.Components = []whatsAppComponent{
+		{
+			Type:       "body",
+			Parameters: []whatsAppParameter{{Type: "text", Text: otp}},
+		},
+		{
+			Type:       "button",
+			SubType:    "url",
+			Index:      "0",
+			Parameters: []whatsAppParameter{{Type: "text", Text: otp}},
+		},
+	}
+	return msg
+}
+
+// whatsAppLanguage returns the code of an approved language of the template for the locale. Messages in a language
+// the template is not approved in are rejected, so locales without one get the configured template language.
+func whatsAppLanguage(config utils.Config, locale string) string {
+	languages := make(map[string]string, len(config.WhatsApp.TemplateLanguages))
+	for configured, code := range config.WhatsApp.TemplateLanguages {
+		languages[normalizeLocale(configured)] = code
+	}
+
+	locale = normalizeLocale(locale)
+	if code, ok := languages[locale]; ok && locale != "" {
+		return code
+	}
+	if i := strings.Index(locale, "-"); i > 0 {
+		if code, ok := languages[locale[:i]]; ok {
+			return code
+		}
+	}
+	return config.WhatsApp.TemplateLanguage
+}
+
+// sendWhatsApp sends the message using WhatsApp cloud API and returns the id of the created message
+func sendWhatsApp(client *http.Client, config utils.Config, msg whatsAppTemplateMessage) (string, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	urlStr := fmt.Sprintf("%s/%s/messages", config.WhatsApp.BaseURL, config.WhatsApp.PhoneNumberID)
+
+	req, _ := http.NewRequest("POST", urlStr, bytes.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+config.WhatsApp.AccessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	// Make request
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not send whatsapp message: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("got failed response from whatsapp: %s: %d %s", resp.Status, result.Error.Code, result.Error.Message)
+	}
+	if err != nil || len(result.Messages) < 1 {
+		// the message is already sent, only its status cannot be tracked
+		logrus.Warnf("could not decode whatsapp response: %v", err)
+		return "", nil
+	}
+	return result.Messages[0].ID, nil
+}
+
+// deliverWhatsApp delivers the otp through whatsapp and records its delivery status.
+// It falls back to sms if the message cannot be sent or is reported as failed,
+// for example when the phone number is not registered on whatsapp.
+func (s *Service) deliverWhatsApp(msg otpMessage) {
+	release := s.limit(providerWhatsApp)
+	id, err := sendWhatsApp(s.client, s.config, newWhatsAppOTPMessage(s.config, msg.otp, msg.phoneNumber, msg.locale))
+	release()
+	if err != nil {
+		logrus.Error(err)
+		logrus.Infof("could not send whatsapp message for message %s, falling back to sms", msg.id)
+		s.deliverSMS(msg)
+		return
+	}
+
+	s.tracker.sent(msg.id, channelWhatsApp, "whatsapp", id)
+	// keep the otp until the message is delivered, in case it has to be resent through sms
+	if id != "" {
+		s.fallbacks.add(id, pendingOTP{msg: msg, expiry: time.Now().Add(otpValidity)})
+	}
+}
+
+// whatsAppWebhook verifies the webhook subscription and receives message status updates from whatsapp.
+// See https://developers.facebook.com/docs/whatsapp/cloud-api/webhooks/components
+func (s *Service) whatsAppWebhook(w http.ResponseWriter, r *http.Request) {
+	// subscription verification request
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		if query.Get("hub.mode") != "subscribe" || query.Get("hub.verify_token") != s.config.WhatsApp.VerifyToken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(query.Get("hub.challenge")))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !validWhatsAppSignature(s.config.WhatsApp.AppSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		logrus.Warn("received whatsapp webhook with invalid signature")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var notification struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Statuses []struct {
+						ID     string `json:"id"`
+						Status string `json:"status"`
+						Errors []struct {
+							Code int `json:"code"`
+						} `json:"errors"`
+					} `json:"statuses"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	err = json.Unmarshal(body, &notification)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, entry := range notification.Entry {
+		for _, change := range entry.Changes {
+			for _, st := range change.Value.Statuses {
+				var errorCode string
+				if len(st.Errors) > 0 {
+					errorCode = fmt.Sprint(st.Errors[0].Code)
+				}
+				s.recordWhatsAppStatus(st.ID, whatsAppStatus(st.Status), errorCode)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// recordWhatsAppStatus records the status of a whatsapp message and falls back to sms if it failed
+func (s *Service) recordWhatsAppStatus(id, status, errorCode string) {
+	if id == "" || status == "" {
+		return
+	}
+
+	err := s.tracker.providerStatus("whatsapp", id, status, errorCode)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	if status == deliverySent {
+		return
+	}
+	pending, ok := s.fallbacks.remove(id)
+	if ok && status == deliveryFailed {
+		logrus.Infof("whatsapp message %s failed with error %s, falling back to sms", id, errorCode)
+		s.queueFallback(s.deliverSMS, pending.msg)
+	}
+}
+
+// whatsAppStatus maps whatsapp message status to delivery status.
+// It returns empty string for statuses which are not tracked.
+func whatsAppStatus(status string) string {
+	switch status {
+	case "sent":
+		return deliverySent
+	case "delivered", "read":
+		return deliveryDelivered
+	case "failed":
+		return deliveryFailed
+	}
+	return ""
+}
+
+// validWhatsAppSignature checks the X-Hub-Signature-256 header of a webhook request
+func validWhatsAppSignature(appSecret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
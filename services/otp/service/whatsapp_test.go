@@ -0,0 +1,182 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/bhrg3se/flahmingo-homework/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sendWhatsApp(t *testing.T) {
+	var received whatsAppTemplateMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/12345/messages" || r.Header.Get("Authorization") != "Bearer someToken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		if received.To == "15550000000" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":131026,"message":"Message undeliverable"}}`))
+			return
+		}
+		w.Write([]byte(`{"messaging_product":"whatsapp","messages":[{"id":"wamid.1"}]}`))
+	}))
+	defer server.Close()
+
+	var config utils.Config
+	config.WhatsApp.BaseURL = server.URL
+	config.WhatsApp.PhoneNumberID = "12345"
+	config.WhatsApp.AccessToken = "someToken"
+	config.WhatsApp.TemplateName = "otp"
+	config.WhatsApp.TemplateLanguage = "en_US"
+
+	id, err := sendWhatsApp(http.DefaultClient, config, newWhatsAppOTPMessage(config, "123456", "+21234567890", ""))
+	if err != nil {
+		t.Fatalf("sendWhatsApp() error = %v", err)
+	}
+	if id != "wamid.1" {
+		t.Errorf("sendWhatsApp() got = %v, want wamid.1", id)
+	}
+	if received.To != "21234567890" || received.Template.Name != "otp" || received.Template.Components[0].Parameters[0].Text != "123456" {
+		t.Errorf("sendWhatsApp() sent unexpected message %+v", received)
+	}
+
+	_, err = sendWhatsApp(http.DefaultClient, config, newWhatsAppOTPMessage(config, "123456", "+15550000000", ""))
+	if err == nil {
+		t.Error("sendWhatsApp() wanted not nil error for undeliverable message")
+	}
+}
+
+func Test_whatsAppLanguage(t *testing.T) {
+	var config utils.Config
+	config.WhatsApp.TemplateLanguage = "en_US"
+	config.WhatsApp.TemplateLanguages = map[string]string{"pt-br": "pt_BR", "es": "es"}
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "", want: "en_US"},
+		{locale: "en", want: "en_US"},
+		{locale: "pt-BR", want: "pt_BR"},
+		{locale: "pt_br", want: "pt_BR"},
+		{locale: "pt-PT", want: "en_US"},
+		{locale: "es-MX", want: "es"},
+		{locale: "fr", want: "en_US"},
+	}
+	for _, tt := range tests {
+		if got := whatsAppLanguage(config, tt.locale); got != tt.want {
+			t.Errorf("whatsAppLanguage(%q) = %v, want %v", tt.locale, got, tt.want)
+		}
+	}
+}
+
+// whatsAppSignature signs webhook bodies like whatsapp does
+func whatsAppSignature(appSecret, body string) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_whatsAppWebhook(t *testing.T) {
+	const id = "wamid.1"
+	statusBody := func(status string) string {
+		return `{"entry":[{"changes":[{"value":{"statuses":[{"id":"` + id + `","status":"` + status + `","errors":[{"code":131026}]}]}}]}]}`
+	}
+	tests := []struct {
+		name       string
+		body       string
+		signature  string
+		fallback   bool
+		wantCode   int
+		wantStatus string
+		wantSMS    int
+	}{
+		{
+			name:       "should reject requests without signature",
+			body:       statusBody("delivered"),
+			signature:  "-",
+			wantCode:   http.StatusForbidden,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should reject requests with invalid signature",
+			body:       statusBody("delivered"),
+			signature:  whatsAppSignature("otherSecret", statusBody("delivered")),
+			wantCode:   http.StatusForbidden,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should reject requests signed for another body",
+			body:       statusBody("failed"),
+			signature:  whatsAppSignature("someSecret", statusBody("delivered")),
+			wantCode:   http.StatusForbidden,
+			wantStatus: deliverySent,
+		},
+		{
+			name:       "should record read messages as delivered",
+			body:       statusBody("read"),
+			fallback:   true,
+			wantCode:   http.StatusOK,
+			wantStatus: deliveryDelivered,
+		},
+		{
+			name:       "should fall back to sms for failed messages",
+			body:       statusBody("failed"),
+			fallback:   true,
+			wantCode:   http.StatusOK,
+			wantStatus: deliverySent,
+			wantSMS:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, g := newTestService(t)
+			s.config.WhatsApp.AppSecret = "someSecret"
+			now := time.Now()
+			_, err := db.Exec(`INSERT INTO deliveries (message_id,phone_number,channel,provider,provider_sid,status,created_at,updated_at)
+				VALUES ('messageID','+21234567890','whatsapp','whatsapp',$1,'sent',$2,$2)`, id, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fallback {
+				msg := otpMessage{id: "messageID", otp: "123456", phoneNumber: "+21234567890", channel: channelWhatsApp}
+				s.fallbacks.add(id, pendingOTP{msg: msg, expiry: now.Add(time.Minute)})
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/whatsapp/webhook", strings.NewReader(tt.body))
+			switch tt.signature {
+			case "":
+				r.Header.Set("X-Hub-Signature-256", whatsAppSignature("someSecret", tt.body))
+			case "-":
+			default:
+				r.Header.Set("X-Hub-Signature-256", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			// the fallback is delivered by a worker after the webhook is answered
+			s.fallbacksQueued.Wait()
+
+			if w.Code != tt.wantCode {
+				t.Errorf("whatsAppWebhook() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			var status string
+			if err := db.QueryRow(`SELECT status FROM deliveries WHERE message_id='messageID'`).Scan(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("whatsAppWebhook() delivery status = %v, want %v", status, tt.wantStatus)
+			}
+			if messages := g.Messages("+21234567890"); len(messages) != tt.wantSMS {
+				t.Errorf("whatsAppWebhook() sent %d sms, want %d", len(messages), tt.wantSMS)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"path/filepath"
+	"time"
 )
 
 // ParseConfig uses viper to read and parse config file.
@@ -17,16 +18,63 @@ func ParseConfig(path string) Config {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(absPath)
 
+	viper.SetDefault("database.driver", "postgres")
+	viper.SetDefault("database.path", "/var/lib/flahmingo/flahmingo.db")
+	viper.SetDefault("database.autoMigrate", true)
+	viper.SetDefault("database.queryTimeout", "5s")
+	viper.SetDefault("database.maxOpenConns", 20)
+	viper.SetDefault("database.maxIdleConns", 5)
+	viper.SetDefault("database.connMaxLifetime", "30m")
+	viper.SetDefault("database.connMaxIdleTime", "5m")
+	viper.SetDefault("database.healthCheckInterval", "10s")
 	viper.SetDefault("database.host", "127.0.0.1")
 	viper.SetDefault("database.port", "5432")
 	viper.SetDefault("database.name", "flahmingo")
 
 	viper.SetDefault("server.listen", "127.0.0.1:9090")
+	viper.SetDefault("googleCloud.keyFile", "/etc/flahmingo/key.json")
+	viper.SetDefault("auth.jwtKeyFile", "/etc/flahmingo/jwt.key")
 	viper.SetDefault("database.user", "flahmingo")
 	viper.SetDefault("database.user", "flahmingo")
 	viper.SetDefault("database.user", "flahmingo")
 	viper.SetDefault("database.user", "flahmingo")
 
+	viper.SetDefault("auth.magicLinkExpiry", "15m")
+	viper.SetDefault("auth.emailVerificationExpiry", "24h")
+	viper.SetDefault("auth.otpStore", "database")
+	viper.SetDefault("auth.otpExpiry", "5m")
+	viper.SetDefault("auth.otpMaxAttempts", 5)
+	viper.SetDefault("auth.otpRequestLimit", 5)
+	viper.SetDefault("auth.otpRequestWindow", "15m")
+	viper.SetDefault("auth.deliveryStatusLimit", 30)
+	viper.SetDefault("auth.deliveryStatusWindow", "15m")
+	viper.SetDefault("auth.outboxInterval", "10s")
+	viper.SetDefault("auth.deletionGracePeriod", "720h")
+	viper.SetDefault("auth.purgeInterval", "1h")
+	viper.SetDefault("redis.address", "localhost:6379")
+	viper.SetDefault("redis.timeout", "1s")
+	viper.SetDefault("smtp.port", "25")
+
+	viper.SetDefault("twilio.baseURL", "https://api.twilio.com")
+	viper.SetDefault("twilio.phoneNumberRatePerSecond", 1)
+	viper.SetDefault("whatsApp.baseURL", "https://graph.facebook.com/v17.0")
+	viper.SetDefault("whatsApp.templateName", "otp")
+	viper.SetDefault("whatsApp.templateLanguage", "en_US")
+
+	viper.SetDefault("otp.listen", "127.0.0.1:8080")
+	viper.SetDefault("otp.templates.defaultLocale", "en")
+	viper.SetDefault("otp.dedup.store", "memory")
+	viper.SetDefault("otp.dedup.window", "1h")
+	viper.SetDefault("otp.dedup.cacheSize", 10000)
+	viper.SetDefault("otp.costs.currency", "USD")
+	viper.SetDefault("otp.subscriber.maxOutstandingMessages", 100)
+	viper.SetDefault("otp.subscriber.numGoroutines", 1)
+	viper.SetDefault("otp.subscriber.workers", 10)
+	viper.SetDefault("otp.subscriber.shutdownTimeout", "30s")
+	viper.SetDefault("otp.http.timeout", "10s")
+	viper.SetDefault("otp.http.maxIdleConnsPerHost", 10)
+	viper.SetDefault("otp.http.idleConnTimeout", "90s")
+
 	if err = viper.ReadInConfig(); err != nil {
 		logrus.Fatalf("could not read config file: %v", err)
 	}
@@ -40,6 +88,26 @@ func ParseConfig(path string) Config {
 
 type Config struct {
 	Database struct {
+		// Driver is "postgres", "sqlite" or "memory"
+		Driver string `toml:"driver"`
+		// Path is the file of the sqlite database
+		Path string `toml:"path"`
+		// QueryTimeout limits how long a store query can take, 0 does not limit it
+		QueryTimeout time.Duration `toml:"queryTimeout"`
+		// AutoMigrate applies pending migrations when the auth service starts
+		AutoMigrate bool `toml:"autoMigrate"`
+
+		// pool settings of postgres connections, 0 keeps the database/sql defaults
+		MaxOpenConns    int           `toml:"maxOpenConns"`
+		MaxIdleConns    int           `toml:"maxIdleConns"`
+		ConnMaxLifetime time.Duration `toml:"connMaxLifetime"`
+		ConnMaxIdleTime time.Duration `toml:"connMaxIdleTime"`
+
+		// ReplicaDSN is the postgres connection string of a read replica, user lookups use it while it is reachable
+		ReplicaDSN string `toml:"replicaDSN"`
+		// HealthCheckInterval is how often an unreachable read replica is checked again
+		HealthCheckInterval time.Duration `toml:"healthCheckInterval"`
+
 		User         string `toml:"user"`
 		Password     string `toml:"password"`
 		Host         string `toml:"host"`
@@ -61,11 +129,194 @@ type Config struct {
 
 	GoogleCloud struct {
 		ProjectID string `toml:"projectID"`
+		KeyFile   string `toml:"keyFile"`
+		// EmulatorHost is the address of a pubsub emulator, used instead of google cloud if set
+		EmulatorHost string `toml:"emulatorHost"`
+		// LogOTPs lets the auth service run without pubsub, logging otps and magic links in plain text instead of
+		// sending them. It is for development only, the auth service does not start without pubsub otherwise.
+		LogOTPs bool `toml:"logOTPs"`
 	} `toml:"googleCloud"`
 
 	Twilio struct {
-		AccountSID  string `toml:"accountSid"`
-		AuthToken   string `toml:"authToken"`
+		// BaseURL of twilio's API, can be pointed to the fake sms gateway in services/fakesms
+		BaseURL    string `toml:"baseURL"`
+		AccountSID string `toml:"accountSid"`
+		AuthToken  string `toml:"authToken"`
+		// PhoneNumber is used when no sender in the pool can send to the recipient
 		PhoneNumber string `toml:"phoneNumber"`
+		// PhoneNumberRatePerSecond is how many sms can be sent per second from PhoneNumber, 0 is unlimited
+		PhoneNumberRatePerSecond float64 `toml:"phoneNumberRatePerSecond"`
+		// Senders are added to the sender pool when the otp service starts,
+		// afterwards the pool is managed through the admin api
+		Senders []Sender `toml:"senders"`
 	} `toml:"twilio"`
+
+	// WhatsApp configures the whatsapp business cloud api
+	WhatsApp struct {
+		BaseURL       string `toml:"baseURL"`
+		PhoneNumberID string `toml:"phoneNumberID"`
+		AccessToken   string `toml:"accessToken"`
+		// AppSecret is used to verify the signature of webhook requests
+		AppSecret string `toml:"appSecret"`
+		// VerifyToken is the token entered when subscribing to the webhook
+		VerifyToken string `toml:"verifyToken"`
+		// TemplateName is the name of an approved authentication template with the otp as its only parameter
+		TemplateName string `toml:"templateName"`
+		// TemplateLanguage is the language code of the template sent to users whose locale has no approved language
+		TemplateLanguage string `toml:"templateLanguage"`
+		// TemplateLanguages maps locales like "pt-BR" or "pt" to the codes of the languages the template
+		// is approved in, like "pt_BR"
+		TemplateLanguages map[string]string `toml:"templateLanguages"`
+	} `toml:"whatsApp"`
+
+	Auth struct {
+		// JWTKeyFile is the private key used to sign auth tokens, it is generated if it does not exist
+		JWTKeyFile string `toml:"jwtKeyFile"`
+
+		// MagicLinkURL is the url of the app page which handles magic links.
+		// The magic link token is added to it as "token" query parameter.
+		MagicLinkURL    string        `toml:"magicLinkURL"`
+		MagicLinkExpiry time.Duration `toml:"magicLinkExpiry"`
+		// EmailVerificationURL is the url of the app page which confirms emails, with the token of the link sent to
+		// the email as "token" query parameter
+		EmailVerificationURL    string        `toml:"emailVerificationURL"`
+		EmailVerificationExpiry time.Duration `toml:"emailVerificationExpiry"`
+
+		// AdminToken authorizes requests to the admin service with full access, it is disabled if empty.
+		// Users with the support or admin role can use the admin service with their auth tokens.
+		AdminToken string `toml:"adminToken"`
+
+		// OTPStore keeps otps in the "database" or in "redis"
+		OTPStore string `toml:"otpStore"`
+		// OTPExpiry is how long an otp can be used
+		OTPExpiry time.Duration `toml:"otpExpiry"`
+		// OTPMaxAttempts is how many times an otp can be tried, a new otp has to be requested after that
+		OTPMaxAttempts int `toml:"otpMaxAttempts"`
+		// OTPRequestLimit is how many otps can be sent to a phone number or email in OTPRequestWindow, 0 disables the limit
+		OTPRequestLimit  int           `toml:"otpRequestLimit"`
+		OTPRequestWindow time.Duration `toml:"otpRequestWindow"`
+		// DeliveryStatusLimit is how many delivery statuses a client address can request in DeliveryStatusWindow,
+		// 0 disables the limit
+		DeliveryStatusLimit  int           `toml:"deliveryStatusLimit"`
+		DeliveryStatusWindow time.Duration `toml:"deliveryStatusWindow"`
+
+		// OutboxInterval is how often otp messages which could not be published are retried
+		OutboxInterval time.Duration `toml:"outboxInterval"`
+
+		// VerifyOldPhoneNumber makes phone number changes need an otp sent to the current phone number too.
+		// It is off by default, as users usually change their number after losing the old one.
+		VerifyOldPhoneNumber bool `toml:"verifyOldPhoneNumber"`
+
+		// DeletionGracePeriod is how long deleted accounts are kept before they are purged with all their data
+		DeletionGracePeriod time.Duration `toml:"deletionGracePeriod"`
+		// PurgeInterval is how often accounts past their grace period are purged
+		PurgeInterval time.Duration `toml:"purgeInterval"`
+	} `toml:"auth"`
+
+	Redis struct {
+		Address  string `toml:"address"`
+		Password string `toml:"password"`
+		DB       int    `toml:"db"`
+		// Timeout limits how long a redis command can take, 0 does not limit it
+		Timeout time.Duration `toml:"timeout"`
+	} `toml:"redis"`
+
+	SMTP struct {
+		Host     string `toml:"host"`
+		Port     string `toml:"port"`
+		Username string `toml:"username"`
+		Password string `toml:"password"`
+		From     string `toml:"from"`
+	} `toml:"smtp"`
+
+	OTP struct {
+		// Listen is the address of the http server which receives provider webhooks
+		Listen string `toml:"listen"`
+		// StatusCallbackURL is the public url of the status webhook, given to the provider when sending sms
+		StatusCallbackURL string `toml:"statusCallbackURL"`
+		// VoiceFallback resends the otp through a voice call when the sms could not be delivered
+		VoiceFallback bool `toml:"voiceFallback"`
+
+		// Templates configures the templates of otp messages
+		Templates struct {
+			// Dir contains templates which override the built in ones, as <dir>/<locale>/<name>.tmpl files
+			Dir           string `toml:"dir"`
+			DefaultLocale string `toml:"defaultLocale"`
+		} `toml:"templates"`
+
+		SMS struct {
+			// AppHash is the hash of the android app, added to sms so that the SMS Retriever API can read the otp
+			AppHash string `toml:"appHash"`
+			// Domain is added as "@domain #otp" line so that iOS and browsers can autofill the otp
+			Domain string `toml:"domain"`
+		} `toml:"sms"`
+
+		// Dedup configures how the otp service remembers already delivered messages
+		Dedup struct {
+			Store     string        `toml:"store"` // "memory" or "postgres"
+			Window    time.Duration `toml:"window"`
+			CacheSize int           `toml:"cacheSize"`
+		} `toml:"dedup"`
+
+		// Subscriber configures how many otp messages are handled at the same time
+		Subscriber struct {
+			// MaxOutstandingMessages is the number of messages pulled from pubsub and not yet acknowledged
+			MaxOutstandingMessages int `toml:"maxOutstandingMessages"`
+			// NumGoroutines is the number of streaming pulls from pubsub
+			NumGoroutines int `toml:"numGoroutines"`
+			// Workers is the number of messages delivered at the same time
+			Workers int `toml:"workers"`
+			// ShutdownTimeout is how long messages being delivered are waited for on shutdown
+			ShutdownTimeout time.Duration `toml:"shutdownTimeout"`
+		} `toml:"subscriber"`
+
+		// HTTP configures the client used to call providers
+		HTTP struct {
+			Timeout             time.Duration `toml:"timeout"`
+			MaxIdleConnsPerHost int           `toml:"maxIdleConnsPerHost"`
+			IdleConnTimeout     time.Duration `toml:"idleConnTimeout"`
+		} `toml:"http"`
+
+		// Limits are the concurrency and rate limits of each provider, keyed by "twilio", "whatsapp" or "smtp"
+		Limits map[string]ProviderLimit `toml:"limits"`
+
+		// Costs configures sms prices and daily spend budgets per destination country
+		Costs struct {
+			Currency string `toml:"currency"`
+			// DefaultPrice and DefaultDailyBudget are used for countries which are not configured
+			DefaultPrice       float64 `toml:"defaultPrice"`
+			DefaultDailyBudget float64 `toml:"defaultDailyBudget"` // 0 is unlimited
+			// Countries is keyed by ISO 3166 country code
+			Countries map[string]CountryCost `toml:"countries"`
+		} `toml:"costs"`
+	} `toml:"otp"`
+}
+
+// Sender is a phone number, short code or alphanumeric id which sms are sent from
+type Sender struct {
+	Address string `toml:"address"`
+	// Kind is "number", "short_code" or "alphanumeric"
+	Kind string `toml:"kind"`
+	// Countries are ISO 3166 codes of countries the sender is used for,
+	// empty to use it for countries without a sender of their own
+	Countries     []string `toml:"countries"`
+	RatePerSecond float64  `toml:"ratePerSecond"` // 0 is unlimited
+}
+
+// ProviderLimit limits the requests sent to a provider
+type ProviderLimit struct {
+	// Concurrency is the number of requests sent at the same time, 0 is unlimited
+	Concurrency int `toml:"concurrency"`
+	// RatePerSecond is the number of requests sent per second, 0 is unlimited
+	RatePerSecond float64 `toml:"ratePerSecond"`
+	// Burst is the number of requests which can be sent at once before the rate applies
+	Burst int `toml:"burst"`
+}
+
+// CountryCost is the sms price and daily spend budget of a destination country
+type CountryCost struct {
+	// Prefix is the country calling code of phone numbers in the country, like "+977"
+	Prefix      string  `toml:"prefix"`
+	Price       float64 `toml:"price"`
+	DailyBudget float64 `toml:"dailyBudget"` // 0 is unlimited
 }
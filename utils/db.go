@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"time"
+)
+
+// how long WaitForDB waits between pings, the wait doubles after each failed ping
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// CreateDBPool creates the connection pool of the postgres database.
+// It does not wait for the database, it is connected in the background and queries fail until then.
+func CreateDBPool(config Config) *sql.DB {
+	var str string
+
+	if config.Database.SSL {
+
+		caCert, _ := filepath.Abs(config.Database.CaCertPath)
+		userCert, _ := filepath.Abs(config.Database.UserCertPath)
+		userKey, _ := filepath.Abs(config.Database.UserKeyPath)
+
+		str = fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=verify-full&sslrootcert=%s&sslcert=%s&sslkey=%s",
+			config.Database.User,
+			config.Database.Password,
+			config.Database.Host,
+			config.Database.Port,
+			config.Database.Name,
+			caCert,
+			userCert,
+			userKey,
+		)
+
+	} else {
+		str = fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable",
+			config.Database.User,
+			config.Database.Password,
+			config.Database.Host,
+			config.Database.Port,
+			config.Database.Name,
+		)
+	}
+
+	return openPool(config, str, "database")
+}
+
+// CreateReplicaPool creates the connection pool of the read replica in database.replicaDSN, or returns nil if there is none
+func CreateReplicaPool(config Config) *sql.DB {
+	if config.Database.ReplicaDSN == "" {
+		return nil
+	}
+	return openPool(config, config.Database.ReplicaDSN, "read replica")
+}
+
+// openPool opens a postgres pool with the pool settings in config and connects it in the background
+func openPool(config Config, dsn, name string) *sql.DB {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		// the driver is always registered, so this only happens if the connection string can not be parsed
+		panic(err.Error())
+	}
+	db.SetMaxOpenConns(config.Database.MaxOpenConns)
+	if config.Database.MaxIdleConns > 0 {
+		// 0 would close every idle connection
+		db.SetMaxIdleConns(config.Database.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(config.Database.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.Database.ConnMaxIdleTime)
+
+	go WaitForDB(context.Background(), db, name)
+	return db
+}
+
+// WaitForDB pings the database until it is reachable or ctx is done, waiting longer after each failed ping
+func WaitForDB(ctx context.Context, db *sql.DB, name string) error {
+	delay := minReconnectDelay
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			logrus.Infof("%s connection successful", name)
+			return nil
+		}
+		logrus.Errorf("could not connect to %s, retrying in %v: %v", name, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE sender_assignments;
+DROP TABLE senders;
+DROP TABLE magic_links;
+DROP TABLE sms_spend;
+DROP TABLE deliveries;
+DROP TABLE processed_messages;
+DROP TABLE otp;
+DROP TABLE users;
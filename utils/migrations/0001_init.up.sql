@@ -0,0 +1,76 @@
+CREATE TABLE users (
+    id VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(50),
+    phone_number VARCHAR(50) UNIQUE NOT NULL,
+    email VARCHAR(255) UNIQUE,
+    is_verified BOOL DEFAULT FALSE
+);
+
+-- otp is saved against the phone number, or the email for email logins
+CREATE TABLE otp (
+    value VARCHAR(50),
+    phone_number VARCHAR(50) UNIQUE NOT NULL,
+    expiry timestamp
+);
+
+-- pubsub messages already handled by the otp service, used to drop redeliveries
+CREATE TABLE processed_messages (
+    id VARCHAR(50) PRIMARY KEY,
+    processed_at timestamp NOT NULL
+);
+
+-- delivery state of otp messages, written by auth when publishing and by otp while delivering
+CREATE TABLE deliveries (
+    message_id VARCHAR(50) PRIMARY KEY,
+    phone_number VARCHAR(50) NOT NULL,
+    channel VARCHAR(20) NOT NULL DEFAULT 'sms',
+    provider VARCHAR(50) NOT NULL DEFAULT '',
+    provider_sid VARCHAR(64) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL,
+    error_code VARCHAR(50) NOT NULL DEFAULT '',
+    country VARCHAR(10) NOT NULL DEFAULT '',
+    price NUMERIC(10,5),
+    currency VARCHAR(3) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX deliveries_phone_number_idx ON deliveries (phone_number, created_at);
+CREATE INDEX deliveries_provider_sid_idx ON deliveries (provider_sid);
+
+-- sms spend per day (UTC) and destination country, used to enforce daily budgets
+CREATE TABLE sms_spend (
+    day DATE NOT NULL,
+    country VARCHAR(10) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    amount NUMERIC(12,5) NOT NULL DEFAULT 0,
+    messages INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, country)
+);
+
+-- single use magic link tokens sent in login emails
+CREATE TABLE magic_links (
+    id VARCHAR(50) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    expiry timestamp NOT NULL,
+    used_at timestamp
+);
+
+-- numbers, short codes and alphanumeric ids which sms are sent from
+CREATE TABLE senders (
+    address VARCHAR(20) PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    -- comma separated ISO 3166 country codes, empty for all countries without a sender of their own
+    countries VARCHAR(255) NOT NULL DEFAULT '',
+    rate_per_second NUMERIC(8,2) NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+-- sender each recipient gets sms from, so that all their sms come from the same sender
+CREATE TABLE sender_assignments (
+    phone_number VARCHAR(50) PRIMARY KEY,
+    sender VARCHAR(20) NOT NULL REFERENCES senders (address) ON DELETE CASCADE,
+    assigned_at timestamp NOT NULL
+);
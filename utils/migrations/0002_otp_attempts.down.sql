@@ -0,0 +1,2 @@
+DROP TABLE otp_requests;
+ALTER TABLE otp DROP COLUMN attempts;
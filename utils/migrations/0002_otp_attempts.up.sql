@@ -0,0 +1,9 @@
+-- wrong otps tried since the otp was saved
+ALTER TABLE otp ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+-- otps requested for a phone number or email in the current window, used to limit how often otps are sent
+CREATE TABLE otp_requests (
+    key VARCHAR(255) PRIMARY KEY,
+    window_start timestamp NOT NULL,
+    count INTEGER NOT NULL
+);
@@ -0,0 +1,9 @@
+-- pubsub messages saved in the same transaction as the changes which produced them, kept until they are published
+CREATE TABLE outbox (
+    id VARCHAR(50) PRIMARY KEY,
+    attributes TEXT NOT NULL,
+    created_at timestamp NOT NULL,
+    published_at timestamp
+);
+
+CREATE INDEX outbox_pending ON outbox (created_at) WHERE published_at IS NULL;
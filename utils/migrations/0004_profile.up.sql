@@ -0,0 +1,11 @@
+-- profile fields changed with UpdateProfile, version is increased by every update
+ALTER TABLE users ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url VARCHAR(2048) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '';
+-- json object of client data
+ALTER TABLE users ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN created_at timestamp;
+ALTER TABLE users ADD COLUMN updated_at timestamp;
+UPDATE users SET created_at=CURRENT_TIMESTAMP, updated_at=CURRENT_TIMESTAMP;
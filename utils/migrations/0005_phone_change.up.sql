@@ -0,0 +1,2 @@
+-- auth tokens carry the token version of the user, increasing it revokes all tokens issued before
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- accounts deleted with DeleteAccount, they are purged with their data once auth.deletionGracePeriod passed
+ALTER TABLE users ADD COLUMN deleted_at timestamp;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at);
@@ -0,0 +1,4 @@
+-- role of the user in the admin service: user, support or admin
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+CREATE INDEX users_created_at_idx ON users (created_at, id);
@@ -0,0 +1,10 @@
+-- status of the account: pending until the phone number is verified, then active,
+-- suspended or locked through the admin service, or deleted
+ALTER TABLE users ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'pending';
+-- why the account was suspended or locked
+ALTER TABLE users ADD COLUMN status_reason VARCHAR(500) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN status_changed_at timestamp;
+
+UPDATE users SET status='active', status_changed_at=updated_at WHERE is_verified;
+UPDATE users SET status='deleted', status_changed_at=deleted_at WHERE deleted_at IS NOT NULL;
+UPDATE users SET status_changed_at=created_at WHERE status_changed_at IS NULL;
@@ -0,0 +1,26 @@
+-- authentication events: signups, otps, logins, auth tokens and admin actions. Rows are never removed, they are kept
+-- when deleted accounts are purged but lose their ip, user_agent and the phone number or email of the user as target.
+CREATE TABLE auth_events (
+    id VARCHAR(50) PRIMARY KEY,
+    -- signup, otp_sent, otp_verified, otp_failed, login, token_issued, token_refreshed, token_revoked or admin_action
+    type VARCHAR(30) NOT NULL,
+    -- name of the admin action, empty for other events
+    action VARCHAR(50) NOT NULL DEFAULT '',
+    -- success or failure
+    outcome VARCHAR(10) NOT NULL,
+    -- user the event is about, empty if it is not known
+    user_id VARCHAR(50) NOT NULL DEFAULT '',
+    -- id of the user who caused the event, or "admin-token"
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    -- phone number, email or otp key of the event, or what an admin action was taken on
+    target VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(500) NOT NULL DEFAULT '',
+    -- json object with the details of the event
+    details TEXT NOT NULL DEFAULT '{}',
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX auth_events_created_at_idx ON auth_events (created_at, id);
+CREATE INDEX auth_events_user_id_idx ON auth_events (user_id, created_at);
+
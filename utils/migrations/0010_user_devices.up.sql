@@ -0,0 +1,10 @@
+-- devices users logged in from, a login from a device which is not in the table is notified to the user
+CREATE TABLE user_devices (
+    user_id VARCHAR(50) NOT NULL,
+    -- sha256 of the device-id metadata sent by the client, or of its user agent
+    fingerprint VARCHAR(64) NOT NULL,
+    user_agent VARCHAR(500) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    last_seen_at timestamp NOT NULL,
+    PRIMARY KEY (user_id, fingerprint)
+);
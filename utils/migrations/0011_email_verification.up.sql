@@ -0,0 +1,6 @@
+-- email which the user gave but has not confirmed yet by opening the link sent to it. It becomes the email of the
+-- user once confirmed, so that unconfirmed emails can not be used to login or keep others from using their email.
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NOT NULL DEFAULT '';
+
+-- emails saved before were never confirmed
+UPDATE users SET pending_email=email, email=NULL WHERE email IS NOT NULL;
@@ -0,0 +1,2 @@
+-- deleted messages were already published, there is nothing to restore
+SELECT 1;
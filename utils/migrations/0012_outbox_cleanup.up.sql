@@ -0,0 +1,2 @@
+-- published messages are deleted right away now, the ones kept before carry otps and magic links in plain text
+DELETE FROM outbox WHERE published_at IS NOT NULL;
@@ -0,0 +1,4 @@
+UPDATE users SET phone_number=deleted_phone_number, email=NULLIF(deleted_email,'') WHERE deleted_at IS NOT NULL;
+ALTER TABLE users DROP COLUMN deleted_status;
+ALTER TABLE users DROP COLUMN deleted_email;
+ALTER TABLE users DROP COLUMN deleted_phone_number;
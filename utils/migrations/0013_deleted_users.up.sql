@@ -0,0 +1,10 @@
+-- deleted accounts free their phone number and email, so that they can be registered again during the grace period.
+-- They are kept here to restore the account, and to purge what was kept against them once the grace period is over.
+ALTER TABLE users ADD COLUMN deleted_phone_number VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN deleted_email VARCHAR(255) NOT NULL DEFAULT '';
+-- the status before the deletion, so that restoring an account does not lift its suspension or lock
+ALTER TABLE users ADD COLUMN deleted_status VARCHAR(20) NOT NULL DEFAULT '';
+
+UPDATE users SET deleted_phone_number=phone_number, phone_number='deleted:'||id, deleted_email=COALESCE(email,''), email=NULL,
+    deleted_status=CASE WHEN is_verified THEN 'active' ELSE 'pending' END
+    WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,311 @@
+// Package migrations keeps the versioned database schema shared by auth and otp services.
+//
+// Each migration is a pair of <version>_<name>.up.sql and <version>_<name>.down.sql files embedded in the binary.
+// Migrations run on both postgres and sqlite, so they must only use SQL which both of them understand.
+// Applied migrations are recorded in the schema_migrations table with the checksum of their up file,
+// a migration must never be changed after it is applied.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// database dialects
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// lockID is the postgres advisory lock held while migrating, so that only one instance migrates at a time
+const lockID = 7215430981
+
+//go:embed *.sql
+var embedded embed.FS
+
+var filePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a version of the schema
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status is a migration and whether it is applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts migrations
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// New creates a migrator of the migrations embedded in the binary
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	return NewFromFS(db, dialect, embedded)
+}
+
+// NewFromFS creates a migrator of the migrations in fsys
+func NewFromFS(db *sql.DB, dialect string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// load reads migrations from the sql files in fsys, sorted by version
+func load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := filePattern.FindStringSubmatch(path.Base(file))
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies all pending migrations and returns how many were applied.
+// It fails without applying anything if an applied migration was changed or is missing.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]bool) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := m.apply(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version,name,checksum,applied_at) VALUES ($1,$2,$3,$4)`,
+				migration.Version, migration.Name, migration.Checksum, time.Now())
+			if err != nil {
+				return fmt.Errorf("could not apply migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down reverts the last steps applied migrations and returns how many were reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]bool) error {
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can not be reverted, it has no down file", migration.Version, migration.Name)
+			}
+			err := m.apply(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version=$1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("could not revert migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Baseline records migrations up to the version as applied without running them.
+// It is used for databases which were created before migrations existed.
+func (m *Migrator) Baseline(ctx context.Context, version int) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]bool) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+			_, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations (version,name,checksum,applied_at) VALUES ($1,$2,$3,$4)`,
+				migration.Version, migration.Name, migration.Checksum, time.Now())
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Status returns all migrations and when they were applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.createTable(ctx, m.db); err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	rows, err := m.db.QueryContext(ctx, `SELECT version,applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if at, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// locked runs f on a single connection while holding the migration lock.
+// f gets the applied migrations, which are verified against the migration files first.
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[int]bool) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// sqlite has a single writer, there is nothing to lock
+	if m.dialect == Postgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+			return fmt.Errorf("could not lock migrations: %v", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+	}
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return err
+	}
+	applied, err := m.verify(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return f(conn, applied)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (m *Migrator) createTable(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at timestamp NOT NULL
+	)`)
+	return err
+}
+
+// verify returns the versions of applied migrations.
+// It returns error if an applied migration was changed or does not exist anymore.
+func (m *Migrator) verify(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version,name,checksum FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byVersion := map[int]Migration{}
+	for _, migration := range m.migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		var name, checksum string
+		if err := rows.Scan(&version, &name, &checksum); err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("applied migration %d_%s does not exist", version, name)
+		}
+		if migration.Checksum != checksum {
+			return nil, fmt.Errorf("migration %d_%s was changed after it was applied", version, name)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// apply runs the migration sql and records it in schema_migrations in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,204 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_users.up.sql":     {Data: []byte(`CREATE TABLE users (id VARCHAR(36) PRIMARY KEY);`)},
+		"0001_users.down.sql":   {Data: []byte(`DROP TABLE users;`)},
+		"0002_devices.up.sql":   {Data: []byte(`CREATE TABLE devices (id VARCHAR(36) PRIMARY KEY);`)},
+		"0002_devices.down.sql": {Data: []byte(`DROP TABLE devices;`)},
+	}
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	var count int
+	err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name=$1`, table).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func newMigrator(t *testing.T, db *sql.DB, fsys fstest.MapFS) *Migrator {
+	m, err := NewFromFS(db, SQLite, fsys)
+	if err != nil {
+		t.Fatalf("NewFromFS() error = %v", err)
+	}
+	return m
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	m := newMigrator(t, db, testFS())
+
+	applied, err := m.Up(ctx)
+	if err != nil || applied != 2 {
+		t.Fatalf("Up() = %d, %v, want 2, nil", applied, err)
+	}
+	if !tableExists(t, db, "users") || !tableExists(t, db, "devices") {
+		t.Fatal("Up() did not create tables")
+	}
+
+	// applying again does nothing
+	applied, err = m.Up(ctx)
+	if err != nil || applied != 0 {
+		t.Fatalf("second Up() = %d, %v, want 0, nil", applied, err)
+	}
+
+	reverted, err := m.Down(ctx, 1)
+	if err != nil || reverted != 1 {
+		t.Fatalf("Down() = %d, %v, want 1, nil", reverted, err)
+	}
+	if !tableExists(t, db, "users") || tableExists(t, db, "devices") {
+		t.Fatal("Down() did not revert only the last migration")
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil {
+		t.Errorf("Status() = %+v, want first applied and second pending", statuses)
+	}
+}
+
+func TestMigrator_Up_failed(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	fsys := testFS()
+	fsys["0003_broken.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE broken (;`)}
+	m := newMigrator(t, db, fsys)
+
+	applied, err := m.Up(ctx)
+	if err == nil || applied != 2 {
+		t.Fatalf("Up() = %d, %v, want 2 and not nil error", applied, err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if statuses[2].AppliedAt != nil {
+		t.Error("failed migration is recorded as applied")
+	}
+}
+
+func TestMigrator_verify(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(fsys fstest.MapFS)
+	}{
+		{
+			name: "changed after applied",
+			change: func(fsys fstest.MapFS) {
+				fsys["0001_users.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)}
+			},
+		},
+		{
+			name: "applied migration removed",
+			change: func(fsys fstest.MapFS) {
+				delete(fsys, "0002_devices.up.sql")
+				delete(fsys, "0002_devices.down.sql")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openDB(t)
+			if _, err := newMigrator(t, db, testFS()).Up(ctx); err != nil {
+				t.Fatalf("Up() error = %v", err)
+			}
+
+			fsys := testFS()
+			tt.change(fsys)
+			fsys["0003_sessions.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE sessions (id VARCHAR(36) PRIMARY KEY);`)}
+			if _, err := newMigrator(t, db, fsys).Up(ctx); err == nil {
+				t.Fatal("Up() wanted not nil error")
+			}
+			if tableExists(t, db, "sessions") {
+				t.Error("Up() applied migrations after failing verification")
+			}
+		})
+	}
+}
+
+func TestMigrator_Baseline(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	// the database was created before migrations existed
+	if _, err := db.Exec(`CREATE TABLE users (id VARCHAR(36) PRIMARY KEY);`); err != nil {
+		t.Fatal(err)
+	}
+	m := newMigrator(t, db, testFS())
+
+	recorded, err := m.Baseline(ctx, 1)
+	if err != nil || recorded != 1 {
+		t.Fatalf("Baseline() = %d, %v, want 1, nil", recorded, err)
+	}
+	applied, err := m.Up(ctx)
+	if err != nil || applied != 1 {
+		t.Fatalf("Up() = %d, %v, want 1, nil", applied, err)
+	}
+}
+
+func TestNewFromFS_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{name: "invalid name", fsys: fstest.MapFS{"users.sql": {Data: []byte(`SELECT 1;`)}}},
+		{name: "without up", fsys: fstest.MapFS{"0001_users.down.sql": {Data: []byte(`SELECT 1;`)}}},
+		{name: "different names", fsys: fstest.MapFS{
+			"0001_users.up.sql":   {Data: []byte(`SELECT 1;`)},
+			"0001_devices.up.sql": {Data: []byte(`SELECT 1;`)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFromFS(nil, SQLite, tt.fsys); err == nil {
+				t.Error("NewFromFS() wanted not nil error")
+			}
+		})
+	}
+}
+
+// the embedded migrations must apply and revert cleanly
+func TestEmbedded(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	m, err := New(db, SQLite)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if _, err := m.Down(ctx, len(m.migrations)); err != nil {
+		t.Fatalf("Down() error = %v", err)
+	}
+	if tableExists(t, db, "users") {
+		t.Error("Down() did not revert all migrations")
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up() after Down() error = %v", err)
+	}
+}
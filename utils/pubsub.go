@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"os"
+	"path/filepath"
+)
+
+// names of the topic and subscription which carry otp messages from auth to otp service
+const (
+	VerificationTopic        = "verification"
+	VerificationSubscription = "verification-sub"
+)
+
+// UsingPubSubEmulator reports whether pubsub clients connect to an emulator instead of google cloud
+func UsingPubSubEmulator(config Config) bool {
+	return config.GoogleCloud.EmulatorHost != "" || os.Getenv("PUBSUB_EMULATOR_HOST") != ""
+}
+
+// NewPubSubClient creates a pubsub client. It connects to the emulator if googleCloud.emulatorHost
+// or PUBSUB_EMULATOR_HOST is set, otherwise it authenticates with the configured key file.
+func NewPubSubClient(ctx context.Context, config Config) (*pubsub.Client, error) {
+	if config.GoogleCloud.EmulatorHost != "" {
+		conn, err := grpc.Dial(config.GoogleCloud.EmulatorHost, grpc.WithInsecure())
+		if err != nil {
+			return nil, err
+		}
+		return pubsub.NewClient(ctx, config.GoogleCloud.ProjectID, option.WithGRPCConn(conn))
+	}
+
+	// the client connects to PUBSUB_EMULATOR_HOST by itself
+	if os.Getenv("PUBSUB_EMULATOR_HOST") != "" {
+		return pubsub.NewClient(ctx, config.GoogleCloud.ProjectID)
+	}
+
+	absPath, err := filepath.Abs(config.GoogleCloud.KeyFile)
+	if err != nil {
+		logrus.Errorf("google key not found: %v", err)
+	}
+	return pubsub.NewClient(ctx, config.GoogleCloud.ProjectID, option.WithCredentialsFile(absPath))
+}
+
+// CreateVerificationTopic creates the verification topic and subscription if they do not exist.
+// The emulator starts without any topic, while in google cloud they are created beforehand.
+func CreateVerificationTopic(ctx context.Context, client *pubsub.Client) (*pubsub.Topic, error) {
+	topic := client.Topic(VerificationTopic)
+	exists, err := topic.Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		topic, err = client.CreateTopic(ctx, VerificationTopic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sub := client.Subscription(VerificationSubscription)
+	exists, err = sub.Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		_, err = client.CreateSubscription(ctx, VerificationSubscription, pubsub.SubscriptionConfig{Topic: topic})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return topic, nil
+}